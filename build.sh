@@ -2,6 +2,7 @@
 
 BUILD_DIR="build"
 PRGN_NAME="dwelling"
+TOOLS="orestats worldpreview occlusionbench shprobe meshbench"
 
 function build {
     echo "-Building ${PRGN_NAME}-"
@@ -13,8 +14,16 @@ function build {
     echo "Building..."
     cd ${BUILD_DIR}
     go build "$@" ${PRGN_NAME}
+    BUILD_STATUS=$?
+    for tool in ${TOOLS}
+    do
+        if [ ${BUILD_STATUS} -eq 0 ]; then
+            go build "$@" ${PRGN_NAME}/tools/${tool}
+            BUILD_STATUS=$?
+        fi
+    done
 
-    if [ $? -eq 0 ]; then
+    if [ ${BUILD_STATUS} -eq 0 ]; then
         echo "Build OK"
 
         echo "Running tests..."
@@ -39,7 +48,7 @@ function build {
 }
 
 function tests {
-    go test bedrock/math/matrix
+    go test bedrock/math/matrix dwelling/chunkmanager dwelling/renderer dwelling/renderer/glbackend
 }
 
 function run {
@@ -1,7 +1,6 @@
 package camera
 
 import (
-	"bedrock"
 	"bedrock/math/matrix"
 	"bedrock/math/vector"
 	"math"
@@ -13,6 +12,9 @@ type Camera struct {
 	FrustumPos, FrustumRot vector.Vector3f
 	MousePos, MouseDir     vector.Vector3f
 
+	// Viewport in pixels
+	Width, Height int
+
 	ViewMatrix       *matrix.Matrix
 	ProjectionMatrix *matrix.Matrix
 	PVMatrix         *matrix.Matrix
@@ -26,8 +28,12 @@ type Plane struct {
 	A, B, C, D float64
 }
 
-func (cam *Camera) Init() error {
-	ratio := float64(bedrock.ScreenWidth) / float64(bedrock.ScreenHeight)
+// Sets up the camera for a viewport, the window or an offscreen render.
+func (cam *Camera) InitViewport(width, height int) error {
+	ratio := float64(width) / float64(height)
+
+	cam.Width = width
+	cam.Height = height
 
 	cam.Pos = vector.Vector3f{X: -48.0, Y: 32.0, Z: -48.0}
 	cam.Rot = vector.Vector3f{X: 0.0, Y: 135, Z: 0.0}
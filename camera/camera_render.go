@@ -1,32 +1,18 @@
 package camera
 
 import (
-	"bedrock/shader"
 	"bedrock/math/matrix"
 	"bedrock/math/vector"
-	gl "github.com/chsc/gogl/gl33"
-	"unsafe"
+	"dwelling/renderer"
 )
 
 type DebugData struct {
-	debugVao           gl.Uint
-	debugShader        *shader.ShaderProgram
-	debugFrustumBuffer gl.Uint
-	debugGridBuffer    gl.Uint
-	debugMouseBuffer   gl.Uint
+	debugFrustumMesh renderer.MeshId
+	debugGridMesh    renderer.MeshId
+	debugMouseMesh   renderer.MeshId
 }
 
 func (cam *Camera) setUpDebugRenderer() error {
-	var err error
-	cam.debugData.debugShader, err = shader.LoadShaderProgram("debug", nil)
-	if err != nil {
-		return err
-	}
-
-	gl.GenVertexArrays(1, &cam.debugData.debugVao)
-	gl.BindVertexArray(cam.debugData.debugVao)
-	gl.EnableVertexAttribArray(0)
-
 	cam.createFrustumMesh()
 	cam.createGridMesh()
 
@@ -34,39 +20,29 @@ func (cam *Camera) setUpDebugRenderer() error {
 }
 
 func (cam *Camera) RenderDebugMeshes() {
-	gl.BindVertexArray(cam.debugData.debugVao)
-	cam.debugData.debugShader.Use()
-	cam.debugData.debugShader.SetUniformMatrix("pv", cam.PVMatrix)
+	backend := renderer.Current()
 
 	// Render frustum
-	cam.debugData.debugShader.SetUniformVector3f("flatColor", vector.Vector3f{X: 0.5, Y: 0.5, Z: 1.0})
 	modelMatrix := matrix.NewIdentityMatrix()
 	modelMatrix.TranslateVector(cam.FrustumPos)
 	modelMatrix.RotateY(cam.FrustumRot.Y)
 	modelMatrix.RotateX(cam.FrustumRot.X)
-	cam.debugData.debugShader.SetUniformMatrix("model", modelMatrix)
-	cam.renderFrustumMesh()
+	backend.DrawLines(cam.debugData.debugFrustumMesh, cam.PVMatrix, modelMatrix, vector.Vector3f{X: 0.5, Y: 0.5, Z: 1.0})
 	// Render frustum
 
 	// Render Mouse ray
-	cam.debugData.debugShader.SetUniformVector3f("flatColor", vector.Vector3f{X: 1.0, Y: 0.5, Z: 0.5})
 	cam.createMouseMesh()
 	modelMatrix = matrix.NewIdentityMatrix()
-	cam.debugData.debugShader.SetUniformMatrix("model", modelMatrix)
-	cam.renderMouseMesh()
+	backend.DrawLines(cam.debugData.debugMouseMesh, cam.PVMatrix, modelMatrix, vector.Vector3f{X: 1.0, Y: 0.5, Z: 0.5})
 	// Render Mouse ray
 
 	// Render Grid
-	cam.debugData.debugShader.SetUniformVector3f("flatColor", vector.Vector3f{X: 0.0, Y: 0.0, Z: 0.0})
 	modelMatrix = matrix.NewIdentityMatrix()
-	cam.debugData.debugShader.SetUniformMatrix("model", modelMatrix)
-	cam.renderGridMesh()
-	// Render Mouse ray
+	backend.DrawLines(cam.debugData.debugGridMesh, cam.PVMatrix, modelMatrix, vector.Vector3f{X: 0.0, Y: 0.0, Z: 0.0})
+	// Render Grid
 }
 
 func (cam *Camera) createFrustumMesh() {
-	sizeFloat := int(unsafe.Sizeof([1]float32{}))
-
 	proj := cam.ProjectionMatrix.Values
 	near := proj[11] / (proj[10] - 1.0)
 	far := 100.0 //proj[11] / (1.0 + proj[10])
@@ -117,25 +93,10 @@ func (cam *Camera) createFrustumMesh() {
 		nRight, nBottom, float32(-near),
 	}
 
-	gl.GenBuffers(1, &cam.debugData.debugFrustumBuffer)
-	gl.BindBuffer(gl.ARRAY_BUFFER, cam.debugData.debugFrustumBuffer)
-	gl.BufferData(gl.ARRAY_BUFFER, gl.Sizeiptr(sizeFloat*len(vertices)), gl.Pointer(&vertices[0]), gl.STATIC_DRAW)
-
-	gl.BindBuffer(gl.ARRAY_BUFFER, cam.debugData.debugFrustumBuffer)
-	gl.EnableVertexAttribArray(0)
-	gl.VertexAttribPointer(0, 3, gl.FLOAT, gl.FALSE, 0, nil)
-	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
-}
-
-func (cam *Camera) renderFrustumMesh() {
-	gl.BindBuffer(gl.ARRAY_BUFFER, cam.debugData.debugFrustumBuffer)
-	gl.VertexAttribPointer(0, 3, gl.FLOAT, gl.FALSE, 0, nil)
-	gl.DrawArrays(gl.LINES, 0, 24)
+	cam.debugData.debugFrustumMesh = renderer.Current().UpdateLineMesh(cam.debugData.debugFrustumMesh, vertices[:])
 }
 
 func (cam *Camera) createMouseMesh() {
-	sizeFloat := int(unsafe.Sizeof([1]float32{}))
-
 	startPos := cam.MousePos
 	endPos := startPos.Add(cam.MouseDir.MulScalar(1000.0))
 	vertices := [...]float32{
@@ -143,28 +104,10 @@ func (cam *Camera) createMouseMesh() {
 		float32(endPos.X), float32(endPos.Y), float32(endPos.Z),
 	}
 
-	if cam.debugData.debugMouseBuffer == 0 {
-		gl.GenBuffers(1, &cam.debugData.debugMouseBuffer)
-
-	}
-	gl.BindBuffer(gl.ARRAY_BUFFER, cam.debugData.debugMouseBuffer)
-	gl.BufferData(gl.ARRAY_BUFFER, gl.Sizeiptr(sizeFloat*len(vertices)), gl.Pointer(&vertices[0]), gl.STATIC_DRAW)
-
-	gl.BindBuffer(gl.ARRAY_BUFFER, cam.debugData.debugMouseBuffer)
-	gl.EnableVertexAttribArray(0)
-	gl.VertexAttribPointer(0, 3, gl.FLOAT, gl.FALSE, 0, nil)
-	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
-}
-
-func (cam *Camera) renderMouseMesh() {
-	gl.BindBuffer(gl.ARRAY_BUFFER, cam.debugData.debugMouseBuffer)
-	gl.VertexAttribPointer(0, 3, gl.FLOAT, gl.FALSE, 0, nil)
-	gl.DrawArrays(gl.LINES, 0, 2)
+	cam.debugData.debugMouseMesh = renderer.Current().UpdateLineMesh(cam.debugData.debugMouseMesh, vertices[:])
 }
 
 func (cam *Camera) createGridMesh() {
-	sizeFloat := int(unsafe.Sizeof([1]float32{}))
-
 	var vertices []float32
 	gridSize := 128
 	for t := -gridSize; t < gridSize; t++ {
@@ -193,18 +136,5 @@ func (cam *Camera) createGridMesh() {
 		vertices = append(vertices, 0.0, i, g)
 	}
 
-	gl.GenBuffers(1, &cam.debugData.debugGridBuffer)
-	gl.BindBuffer(gl.ARRAY_BUFFER, cam.debugData.debugGridBuffer)
-	gl.BufferData(gl.ARRAY_BUFFER, gl.Sizeiptr(sizeFloat*len(vertices)), gl.Pointer(&vertices[0]), gl.STATIC_DRAW)
-
-	gl.BindBuffer(gl.ARRAY_BUFFER, cam.debugData.debugGridBuffer)
-	gl.EnableVertexAttribArray(0)
-	gl.VertexAttribPointer(0, 3, gl.FLOAT, gl.FALSE, 0, nil)
-	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
-}
-
-func (cam *Camera) renderGridMesh() {
-	gl.BindBuffer(gl.ARRAY_BUFFER, cam.debugData.debugGridBuffer)
-	gl.VertexAttribPointer(0, 3, gl.FLOAT, gl.FALSE, 0, nil)
-	gl.DrawArrays(gl.LINES, 0, 3072)
+	cam.debugData.debugGridMesh = renderer.Current().UpdateLineMesh(cam.debugData.debugGridMesh, vertices)
 }
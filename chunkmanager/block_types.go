@@ -0,0 +1,160 @@
+package chunkmanager
+
+import (
+	"fmt"
+	"image/color"
+	"sync"
+)
+
+type BlockType uint8
+
+const (
+	// Hides whatever is behind it
+	TRANSPARENCY_NONE int = iota
+	// Opaque pass, texels with low alpha are cut out, e.g. leaves
+	TRANSPARENCY_ALPHA_TEST
+	// Blended in the translucent pass, e.g. glass and water
+	TRANSPARENCY_BLEND
+)
+
+// Block types with this flow out of their source blocks.
+type FluidInfo struct {
+	// Flow added per block spread sideways, the fluid stops at FLUID_MAX_FLOW
+	Decay int
+	// Logic ticks between fluid updates
+	TickRate int
+}
+
+type BlockTypeInfo struct {
+	Name  string
+	Color color.RGBA
+	// Atlas tile names per face, FRONT to BOTTOM. Empty faces are drawn
+	// untextured.
+	Textures     [6]string
+	Transparency int
+	// Nil for solid blocks
+	Fluid *FluidInfo
+}
+
+func allFaces(texture string) [6]string {
+	return [6]string{texture, texture, texture, texture, texture, texture}
+}
+
+func sidesTopBottom(sides, top, bottom string) [6]string {
+	return [6]string{sides, sides, sides, sides, top, bottom}
+}
+
+const (
+	BLOCK_DEFAULT BlockType = iota
+	BLOCK_WOOD
+	BLOCK_LEAVES
+	BLOCK_STONE
+	BLOCK_DIRT
+	BLOCK_GRASS
+	BLOCK_SAND
+	BLOCK_SNOW
+	BLOCK_COAL_ORE
+	BLOCK_IRON_ORE
+	BLOCK_GOLD_ORE
+	BLOCK_GLASS
+	BLOCK_WATER
+	BLOCK_LAVA
+)
+
+var blockTypes = []BlockTypeInfo{
+	{Name: "default", Color: color.RGBA{128, 128, 128, 255}},
+	{Name: "wood", Color: color.RGBA{102, 76, 51, 255}, Textures: sidesTopBottom("wood_side", "wood_top", "wood_top")},
+	{Name: "leaves", Color: color.RGBA{60, 130, 40, 255}, Textures: allFaces("leaves"), Transparency: TRANSPARENCY_ALPHA_TEST},
+	{Name: "stone", Color: color.RGBA{112, 112, 112, 255}, Textures: allFaces("stone")},
+	{Name: "dirt", Color: color.RGBA{121, 85, 58, 255}, Textures: allFaces("dirt")},
+	{Name: "grass", Color: color.RGBA{95, 159, 53, 255}, Textures: sidesTopBottom("grass_side", "grass_top", "dirt")},
+	{Name: "sand", Color: color.RGBA{219, 207, 163, 255}, Textures: allFaces("sand")},
+	{Name: "snow", Color: color.RGBA{240, 245, 250, 255}, Textures: allFaces("snow")},
+	{Name: "coal_ore", Color: color.RGBA{45, 45, 45, 255}, Textures: allFaces("coal_ore")},
+	{Name: "iron_ore", Color: color.RGBA{188, 152, 128, 255}, Textures: allFaces("iron_ore")},
+	{Name: "gold_ore", Color: color.RGBA{230, 200, 60, 255}, Textures: allFaces("gold_ore")},
+	{Name: "glass", Color: color.RGBA{200, 225, 235, 64}, Textures: allFaces("glass"), Transparency: TRANSPARENCY_BLEND},
+	{Name: "water", Color: color.RGBA{40, 90, 200, 160}, Textures: allFaces("water"), Transparency: TRANSPARENCY_BLEND, Fluid: &FluidInfo{Decay: 1, TickRate: 5}},
+	{Name: "lava", Color: color.RGBA{220, 90, 20, 255}, Textures: allFaces("lava"), Fluid: &FluidInfo{Decay: 2, TickRate: 15}},
+}
+
+// Chunk rebuilds read block types on their own goroutines while new ones
+// can be registered
+var blockTypesLock sync.RWMutex
+
+// Registers a new block type.
+// Returns:
+// BlockType The id of the new type
+// error If the registry is full or the name is taken
+func RegisterBlockType(info BlockTypeInfo) (BlockType, error) {
+	blockTypesLock.Lock()
+	defer blockTypesLock.Unlock()
+
+	return registerBlockType(info)
+}
+
+func registerBlockType(info BlockTypeInfo) (BlockType, error) {
+	if len(blockTypes) > 255 {
+		return 0, fmt.Errorf("block types: registry full, can't add %q", info.Name)
+	}
+	if _, ok := blockTypeByName(info.Name); ok {
+		return 0, fmt.Errorf("block types: %q already registered", info.Name)
+	}
+
+	blockTypes = append(blockTypes, info)
+	return BlockType(len(blockTypes) - 1), nil
+}
+
+func BlockTypeByName(name string) (BlockType, bool) {
+	blockTypesLock.RLock()
+	defer blockTypesLock.RUnlock()
+
+	return blockTypeByName(name)
+}
+
+func blockTypeByName(name string) (BlockType, bool) {
+	for t := range blockTypes {
+		if blockTypes[t].Name == name {
+			return BlockType(t), true
+		}
+	}
+
+	return 0, false
+}
+
+func GetBlockTypeInfo(blockType BlockType) BlockTypeInfo {
+	blockTypesLock.RLock()
+	defer blockTypesLock.RUnlock()
+
+	if int(blockType) >= len(blockTypes) {
+		return blockTypes[BLOCK_DEFAULT]
+	}
+
+	return blockTypes[blockType]
+}
+
+// Returns: a copy of every registered block type, indexed by BlockType
+func allBlockTypes() []BlockTypeInfo {
+	blockTypesLock.RLock()
+	defer blockTypesLock.RUnlock()
+
+	return append([]BlockTypeInfo{}, blockTypes...)
+}
+
+// Finds the block type with the given colour, registering a new one if
+// none exists.
+func blockTypeForColor(col color.RGBA) (BlockType, error) {
+	blockTypesLock.Lock()
+	defer blockTypesLock.Unlock()
+
+	for t := range blockTypes {
+		if blockTypes[t].Color == col {
+			return BlockType(t), nil
+		}
+	}
+
+	return registerBlockType(BlockTypeInfo{
+		Name:  fmt.Sprintf("color_%02x%02x%02x%02x", col.R, col.G, col.B, col.A),
+		Color: col,
+	})
+}
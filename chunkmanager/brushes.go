@@ -0,0 +1,151 @@
+package chunkmanager
+
+import (
+	"fmt"
+	"math"
+)
+
+// Bulk edits. Each brush builds a single transaction, so however many
+// blocks it covers the affected chunks are rebuilt once and it's undone in
+// one step. Submit the result with SubmitTransaction.
+
+// Blocks a flood fill may change before it gives up
+const MaxFloodFill = 4096
+
+// A placed block of the given type, fluids become sources
+func SolidBlock(blockType BlockType) BlockState {
+	return BlockState{Present: true, Type: blockType}
+}
+
+// Sets every cell of the axis aligned box with corners a and b, inclusive.
+func BoxBrush(a, b WorldCoord, state BlockState) *Transaction {
+	tx := NewTransaction("box")
+	for x := imin(a.X, b.X); x <= imax(a.X, b.X); x++ {
+		for y := imin(a.Y, b.Y); y <= imax(a.Y, b.Y); y++ {
+			for z := imin(a.Z, b.Z); z <= imax(a.Z, b.Z); z++ {
+				tx.set(WorldCoord{x, y, z}, state)
+			}
+		}
+	}
+
+	return tx
+}
+
+// Sets every cell whose centre is within radius of the centre of center.
+func SphereBrush(center WorldCoord, radius float64, state BlockState) *Transaction {
+	tx := NewTransaction("sphere")
+	extent := int(math.Ceil(radius))
+	for x := -extent; x <= extent; x++ {
+		for y := -extent; y <= extent; y++ {
+			for z := -extent; z <= extent; z++ {
+				if float64((x*x)+(y*y)+(z*z)) <= radius*radius {
+					tx.set(center.add(WorldCoord{x, y, z}), state)
+				}
+			}
+		}
+	}
+
+	return tx
+}
+
+// Sets the cells on a straight, 6-connected line from a to b, stepping
+// through cells the way a ray would so the line has no diagonal gaps.
+func LineBrush(a, b WorldCoord, state BlockState) *Transaction {
+	tx := NewTransaction("line")
+
+	dir := [3]float64{float64(b.X - a.X), float64(b.Y - a.Y), float64(b.Z - a.Z)}
+	pos := [3]int{a.X, a.Y, a.Z}
+	end := [3]int{b.X, b.Y, b.Z}
+	step := [3]int{}
+	// Ray parameter of the next cell border per axis, and between borders
+	next := [3]float64{}
+	delta := [3]float64{}
+	for axis := 0; axis < 3; axis++ {
+		switch {
+		case dir[axis] > 0.0:
+			step[axis] = 1
+		case dir[axis] < 0.0:
+			step[axis] = -1
+		}
+		if step[axis] == 0 {
+			next[axis] = math.Inf(1)
+			delta[axis] = math.Inf(1)
+			continue
+		}
+		// The line runs between cell centres
+		delta[axis] = 1.0 / math.Abs(dir[axis])
+		next[axis] = 0.5 * delta[axis]
+	}
+
+	tx.set(a, state)
+	for pos != end {
+		axis := 0
+		if next[1] < next[axis] {
+			axis = 1
+		}
+		if next[2] < next[axis] {
+			axis = 2
+		}
+
+		pos[axis] += step[axis]
+		next[axis] += delta[axis]
+		tx.set(WorldCoord{pos[0], pos[1], pos[2]}, state)
+	}
+
+	return tx
+}
+
+// Replaces the blocks 6-connected to start that have its type. The world is
+// only read when the transaction is applied, if more than MaxFloodFill
+// blocks would change nothing is.
+func FloodFillBrush(start WorldCoord, state BlockState) *Transaction {
+	tx := NewTransaction("flood fill")
+	tx.expand = func(tx *Transaction) {
+		first := getWorldBlock(start.X, start.Y, start.Z)
+		if first == nil {
+			return
+		}
+		target := first.blockType
+
+		seen := map[WorldCoord]bool{start: true}
+		queue := []WorldCoord{start}
+		for len(queue) > 0 {
+			pos := queue[0]
+			queue = queue[1:]
+
+			if len(tx.Edits) >= MaxFloodFill {
+				fmt.Printf("edits: Flood fill at %v covers more than %d blocks, skipped\n", start, MaxFloodFill)
+				tx.Edits = []BlockEdit{}
+				return
+			}
+			tx.set(pos, state)
+
+			for _, offset := range neighborOffsets {
+				neighborPos := pos.add(offset)
+				if seen[neighborPos] || !blockEditable(neighborPos) {
+					continue
+				}
+				seen[neighborPos] = true
+				if blk := getWorldBlock(neighborPos.X, neighborPos.Y, neighborPos.Z); blk != nil && blk.blockType == target {
+					queue = append(queue, neighborPos)
+				}
+			}
+		}
+	}
+
+	return tx
+}
+
+func imin(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func imax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
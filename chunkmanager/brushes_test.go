@@ -0,0 +1,185 @@
+package chunkmanager
+
+import (
+	"math"
+	"testing"
+)
+
+// Returns: the cells a transaction sets, in order
+func editedCells(tx *Transaction) []WorldCoord {
+	cells := []WorldCoord{}
+	for _, edit := range tx.Edits {
+		cells = append(cells, edit.Pos)
+	}
+
+	return cells
+}
+
+func iabs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// Every line starts at a and ends at b, each cell shares a face with the
+// one before it and none strays more than a cell from the straight line
+// between the centres.
+func TestLineBrushConnected(t *testing.T) {
+	tests := []struct {
+		a, b WorldCoord
+	}{
+		{WorldCoord{0, 0, 0}, WorldCoord{0, 0, 0}},
+		{WorldCoord{0, 0, 0}, WorldCoord{5, 0, 0}},
+		{WorldCoord{0, 0, 0}, WorldCoord{0, -7, 0}},
+		{WorldCoord{0, 0, 0}, WorldCoord{4, 4, 0}},
+		{WorldCoord{0, 0, 0}, WorldCoord{3, 3, 3}},
+		{WorldCoord{2, -3, 5}, WorldCoord{-6, 4, 1}},
+		{WorldCoord{10, 20, 30}, WorldCoord{11, 9, 31}},
+		{WorldCoord{-1, -1, -1}, WorldCoord{-13, -2, -5}},
+	}
+
+	for _, test := range tests {
+		cells := editedCells(LineBrush(test.a, test.b, SolidBlock(BLOCK_STONE)))
+
+		want := iabs(test.b.X-test.a.X) + iabs(test.b.Y-test.a.Y) + iabs(test.b.Z-test.a.Z) + 1
+		if len(cells) != want {
+			t.Errorf("%v to %v: %d cells, want %d", test.a, test.b, len(cells), want)
+		}
+		if cells[0] != test.a || cells[len(cells)-1] != test.b {
+			t.Errorf("%v to %v: runs from %v to %v", test.a, test.b, cells[0], cells[len(cells)-1])
+		}
+
+		dir := [3]float64{float64(test.b.X - test.a.X), float64(test.b.Y - test.a.Y), float64(test.b.Z - test.a.Z)}
+		length := math.Sqrt((dir[0] * dir[0]) + (dir[1] * dir[1]) + (dir[2] * dir[2]))
+		for index, cell := range cells {
+			if index > 0 {
+				prev := cells[index-1]
+				if iabs(cell.X-prev.X)+iabs(cell.Y-prev.Y)+iabs(cell.Z-prev.Z) != 1 {
+					t.Errorf("%v to %v: %v doesn't share a face with %v", test.a, test.b, cell, prev)
+				}
+			}
+			if length == 0.0 {
+				continue
+			}
+
+			// Distance of the cell centre from the line
+			offset := [3]float64{float64(cell.X - test.a.X), float64(cell.Y - test.a.Y), float64(cell.Z - test.a.Z)}
+			along := ((offset[0] * dir[0]) + (offset[1] * dir[1]) + (offset[2] * dir[2])) / length
+			distSq := (offset[0] * offset[0]) + (offset[1] * offset[1]) + (offset[2] * offset[2]) - (along * along)
+			if distSq > 1.0 {
+				t.Errorf("%v to %v: %v is %.2f from the line", test.a, test.b, cell, math.Sqrt(distSq))
+			}
+		}
+	}
+}
+
+func TestSphereBrushExtent(t *testing.T) {
+	tests := []struct {
+		radius float64
+		// Cells within the radius, counted by distance squared 0, 1, 2, ...
+		want int
+	}{
+		{0.0, 1},
+		{0.5, 1},
+		{1.0, 1 + 6},
+		{1.5, 1 + 6 + 12},
+		{2.0, 1 + 6 + 12 + 8 + 6},
+	}
+
+	center := WorldCoord{20, -4, 7}
+	for _, test := range tests {
+		cells := editedCells(SphereBrush(center, test.radius, SolidBlock(BLOCK_STONE)))
+		if len(cells) != test.want {
+			t.Errorf("radius %v: %d cells, want %d", test.radius, len(cells), test.want)
+		}
+		for _, cell := range cells {
+			x, y, z := float64(cell.X-center.X), float64(cell.Y-center.Y), float64(cell.Z-center.Z)
+			if (x*x)+(y*y)+(z*z) > test.radius*test.radius {
+				t.Errorf("radius %v: %v is outside", test.radius, cell)
+			}
+		}
+	}
+}
+
+// Corners can be given in any order, both are included.
+func TestBoxBrushExtent(t *testing.T) {
+	a := WorldCoord{3, -2, 10}
+	b := WorldCoord{-1, 4, 10}
+	for _, tx := range []*Transaction{BoxBrush(a, b, BlockState{}), BoxBrush(b, a, BlockState{})} {
+		cells := editedCells(tx)
+		if len(cells) != 5*7*1 {
+			t.Errorf("%d cells, want %d", len(cells), 5*7*1)
+		}
+
+		min := cells[0]
+		max := cells[0]
+		for _, cell := range cells {
+			min = WorldCoord{imin(min.X, cell.X), imin(min.Y, cell.Y), imin(min.Z, cell.Z)}
+			max = WorldCoord{imax(max.X, cell.X), imax(max.Y, cell.Y), imax(max.Z, cell.Z)}
+		}
+		if min != (WorldCoord{-1, -2, 10}) || max != (WorldCoord{3, 4, 10}) {
+			t.Errorf("covers %v to %v, want -1, -2, 10 to 3, 4, 10", min, max)
+		}
+	}
+}
+
+// Builds two finished chunks along X, the first one full of stone with a
+// dirt block in the middle. The second has a stone block touching the first
+// through a face and one that doesn't touch it.
+func buildFloodWorld() {
+	resetWorld()
+
+	for x := 0; x < 2; x++ {
+		chunk := newEmptyChunk(ChunkCoord{x, 0, 0})
+		chunk.Stage = STAGE_OCCLUSION
+		chunkMap[chunk.position] = chunk
+	}
+	for x := 0; x < ChunkBase; x++ {
+		for y := 0; y < ChunkBase; y++ {
+			for z := 0; z < ChunkBase; z++ {
+				setBlockState(WorldCoord{x, y, z}, SolidBlock(BLOCK_STONE))
+			}
+		}
+	}
+	setBlockState(WorldCoord{8, 8, 8}, SolidBlock(BLOCK_DIRT))
+	setBlockState(WorldCoord{16, 3, 3}, SolidBlock(BLOCK_STONE))
+	setBlockState(WorldCoord{17, 7, 7}, SolidBlock(BLOCK_STONE))
+}
+
+func TestFloodFillBrush(t *testing.T) {
+	buildFloodWorld()
+	defer resetWorld()
+
+	// The chunk less the dirt block, and the block across the border
+	tx := FloodFillBrush(WorldCoord{0, 0, 0}, SolidBlock(BLOCK_SAND))
+	expandTransaction(tx)
+	if len(tx.Edits) != MaxFloodFill {
+		t.Fatalf("%d cells filled, want %d", len(tx.Edits), MaxFloodFill)
+	}
+	for _, edit := range tx.Edits {
+		if edit.Pos == (WorldCoord{8, 8, 8}) || edit.Pos == (WorldCoord{17, 7, 7}) {
+			t.Errorf("filled %v, which isn't stone connected to the start", edit.Pos)
+		}
+	}
+
+	// One more and the fill is too big
+	setBlockState(WorldCoord{16, 8, 3}, SolidBlock(BLOCK_STONE))
+	tx = FloodFillBrush(WorldCoord{0, 0, 0}, SolidBlock(BLOCK_SAND))
+	expandTransaction(tx)
+	if len(tx.Edits) != 0 {
+		t.Errorf("%d cells filled past the limit of %d, want none", len(tx.Edits), MaxFloodFill)
+	}
+
+	tx = FloodFillBrush(WorldCoord{8, 8, 8}, SolidBlock(BLOCK_SAND))
+	expandTransaction(tx)
+	if cells := editedCells(tx); len(cells) != 1 || cells[0] != (WorldCoord{8, 8, 8}) {
+		t.Errorf("filling the dirt block set %v", cells)
+	}
+
+	tx = FloodFillBrush(WorldCoord{40, 0, 0}, SolidBlock(BLOCK_SAND))
+	expandTransaction(tx)
+	if len(tx.Edits) != 0 {
+		t.Errorf("filling an empty cell set %v", editedCells(tx))
+	}
+}
@@ -0,0 +1,173 @@
+package chunkmanager
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Caves are carved from worms spawned per region of chunks. Every chunk
+// re-walks the worms of all regions in reach, so a tunnel comes out the same
+// no matter which chunk along it is generated first.
+
+type CaveCarver struct {
+	// Region size in chunks
+	RegionSize     int
+	WormsPerRegion int
+	WormLength     int
+	WormStep       float64
+	// How quickly worms turn, and how far
+	WormTurnScale    float64
+	WormTurnStrength float64
+	MinRadius        float64
+	MaxRadius        float64
+	// Caverns are where 3D noise goes above the threshold
+	CavernScale     float64
+	CavernThreshold float64
+	// Nothing is carved above this world Y
+	MaxY int
+}
+
+var defaultCaves = CaveCarver{
+	RegionSize:       4,
+	WormsPerRegion:   3,
+	WormLength:       80,
+	WormStep:         0.75,
+	WormTurnScale:    0.08,
+	WormTurnStrength: 0.35,
+	MinRadius:        1.2,
+	MaxRadius:        2.8,
+	CavernScale:      32.0,
+	CavernThreshold:  0.55,
+	MaxY:             40,
+}
+
+type caveSegment struct {
+	x, y, z float64
+	radius  float64
+}
+
+type regionCoord struct {
+	X, Y, Z int
+}
+
+func (carver *CaveCarver) regionOf(pos ChunkCoord) regionCoord {
+	return regionCoord{
+		floorDiv(pos.X, carver.RegionSize),
+		floorDiv(pos.Y, carver.RegionSize),
+		floorDiv(pos.Z, carver.RegionSize),
+	}
+}
+
+// Walks the worms of a region, returning the spheres they carve.
+func (carver *CaveCarver) regionWorms(region regionCoord) []caveSegment {
+	segments := []caveSegment{}
+
+	regionBlocks := float64(carver.RegionSize * ChunkBase)
+	rnd := rand.New(rand.NewSource(chunkSeed(ChunkCoord{region.X, region.Y, region.Z}, 0x4341)))
+	for t := 0; t < carver.WormsPerRegion; t++ {
+		x := (float64(region.X) + rnd.Float64()) * regionBlocks
+		y := (float64(region.Y) + rnd.Float64()) * regionBlocks
+		z := (float64(region.Z) + rnd.Float64()) * regionBlocks
+		yaw := rnd.Float64() * 2.0 * math.Pi
+		pitch := (rnd.Float64() - 0.5) * 0.5
+		noiseOffset := rnd.Float64() * 1000.0
+
+		for step := 0; step < carver.WormLength; step++ {
+			along := noiseOffset + float64(step)*carver.WormTurnScale
+			yaw += signedNoiseOctave(2, along, 0.0, noiseOffset) * carver.WormTurnStrength
+			pitch += signedNoiseOctave(2, along, 100.0, noiseOffset) * carver.WormTurnStrength * 0.5
+			// Keep tunnels mostly horizontal
+			pitch = math.Max(math.Min(pitch, 0.6), -0.6)
+
+			x += math.Cos(yaw) * math.Cos(pitch) * carver.WormStep
+			y += math.Sin(pitch) * carver.WormStep
+			z += math.Sin(yaw) * math.Cos(pitch) * carver.WormStep
+
+			radiusNoise := (signedNoiseOctave(1, along*2.0, 200.0, noiseOffset) + 1.0) / 2.0
+			radius := carver.MinRadius + (carver.MaxRadius-carver.MinRadius)*radiusNoise
+			segments = append(segments, caveSegment{x, y, z, radius})
+		}
+	}
+
+	return segments
+}
+
+// How many regions away a worm can reach
+func (carver *CaveCarver) regionReach() int {
+	reach := (float64(carver.WormLength) * carver.WormStep) + carver.MaxRadius
+	return int(math.Ceil(reach / float64(carver.RegionSize*ChunkBase)))
+}
+
+func (carver *CaveCarver) Carve(chunk *Chunk) {
+	minX := float64(chunk.position.X * ChunkBase)
+	minY := float64(chunk.position.Y * ChunkBase)
+	minZ := float64(chunk.position.Z * ChunkBase)
+	maxX := minX + float64(ChunkBase)
+	maxY := minY + float64(ChunkBase)
+	maxZ := minZ + float64(ChunkBase)
+
+	if int(minY) > carver.MaxY {
+		return
+	}
+
+	region := carver.regionOf(chunk.position)
+	reach := carver.regionReach()
+	for rx := region.X - reach; rx <= region.X+reach; rx++ {
+		for ry := region.Y - reach; ry <= region.Y+reach; ry++ {
+			for rz := region.Z - reach; rz <= region.Z+reach; rz++ {
+				for _, seg := range carver.regionWorms(regionCoord{rx, ry, rz}) {
+					if seg.x+seg.radius < minX || seg.x-seg.radius >= maxX ||
+						seg.y+seg.radius < minY || seg.y-seg.radius >= maxY ||
+						seg.z+seg.radius < minZ || seg.z-seg.radius >= maxZ {
+						continue
+					}
+					carver.carveSphere(chunk, seg)
+				}
+			}
+		}
+	}
+
+	// Same for the whole world, moves the caverns with the seed like the
+	// worms' own offsets
+	noiseOffset := rand.New(rand.NewSource(chunkSeed(ChunkCoord{}, 0x4356))).Float64() * 1000.0
+	for pos := range chunk.data {
+		x := minX + float64(pos.X)
+		y := minY + float64(pos.Y)
+		z := minZ + float64(pos.Z)
+		if int(y) > carver.MaxY {
+			continue
+		}
+		density := signedNoiseOctave(3, (x/carver.CavernScale)+noiseOffset, y/carver.CavernScale, (z/carver.CavernScale)+noiseOffset)
+		if density > carver.CavernThreshold {
+			delete(chunk.data, pos)
+		}
+	}
+}
+
+func (carver *CaveCarver) carveSphere(chunk *Chunk, seg caveSegment) {
+	baseX := chunk.position.X * ChunkBase
+	baseY := chunk.position.Y * ChunkBase
+	baseZ := chunk.position.Z * ChunkBase
+
+	for y := int(math.Floor(seg.y - seg.radius)); y <= int(math.Ceil(seg.y+seg.radius)); y++ {
+		if y > carver.MaxY {
+			break
+		}
+		for x := int(math.Floor(seg.x - seg.radius)); x <= int(math.Ceil(seg.x+seg.radius)); x++ {
+			for z := int(math.Floor(seg.z - seg.radius)); z <= int(math.Ceil(seg.z+seg.radius)); z++ {
+				dx := float64(x) + 0.5 - seg.x
+				dy := float64(y) + 0.5 - seg.y
+				dz := float64(z) + 0.5 - seg.z
+				if (dx*dx)+(dy*dy)+(dz*dz) > seg.radius*seg.radius {
+					continue
+				}
+
+				pos := BlockCoord{x - baseX, y - baseY, z - baseZ}
+				if pos.X < 0 || pos.X >= ChunkBase || pos.Y < 0 || pos.Y >= ChunkBase || pos.Z < 0 || pos.Z >= ChunkBase {
+					continue
+				}
+				delete(chunk.data, pos)
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package chunkmanager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func solidChunk(pos ChunkCoord) *Chunk {
+	chunk := &Chunk{data: map[BlockCoord]*Block{}, position: pos}
+	for x := 0; x < ChunkBase; x++ {
+		for y := 0; y < ChunkBase; y++ {
+			for z := 0; z < ChunkBase; z++ {
+				chunk.data[BlockCoord{x, y, z}] = &Block{position: BlockCoord{x, y, z}, blockType: BLOCK_STONE}
+			}
+		}
+	}
+	return chunk
+}
+
+// Blocks the caverns alone take out of solid chunks around the origin
+func carvedCaverns(seed int64) map[ChunkCoord][]BlockCoord {
+	SetSeed(seed)
+	carver := defaultCaves
+	carver.WormsPerRegion = 0
+
+	carved := map[ChunkCoord][]BlockCoord{}
+	for x := 0; x < 3; x++ {
+		for z := 0; z < 3; z++ {
+			pos := ChunkCoord{x, 0, z}
+			chunk := solidChunk(pos)
+			carver.Carve(chunk)
+			for y := 0; y < ChunkBase; y++ {
+				for bx := 0; bx < ChunkBase; bx++ {
+					for bz := 0; bz < ChunkBase; bz++ {
+						if _, ok := chunk.data[BlockCoord{bx, y, bz}]; !ok {
+							carved[pos] = append(carved[pos], BlockCoord{bx, y, bz})
+						}
+					}
+				}
+			}
+		}
+	}
+	return carved
+}
+
+func TestCavernsFollowSeed(t *testing.T) {
+	defer SetSeed(0)
+
+	first := carvedCaverns(1)
+	if !reflect.DeepEqual(first, carvedCaverns(1)) {
+		t.Error("the same seed carved different caverns")
+	}
+	if reflect.DeepEqual(first, carvedCaverns(999999)) {
+		t.Error("seeds 1 and 999999 carved the same caverns")
+	}
+}
@@ -25,13 +25,12 @@ func newPyramidChunk(invert bool) *Chunk {
 		}
 	}
 
-	chunk.IsLoaded = true
 	chunk.MouseHit = false
 
 	return chunk
 }
 
-func newCubeChunk(random bool) *Chunk {
+func newCubeChunk(random bool, rnd *rand.Rand) *Chunk {
 	chunk := &Chunk{}
 
 	chunk.data = map[BlockCoord]*Block{}
@@ -40,7 +39,7 @@ func newCubeChunk(random bool) *Chunk {
 			for z := 0; z < ChunkBase; z++ {
 				val := 1
 				if random == false {
-					val = rand.Intn(2)
+					val = rnd.Intn(2)
 				}
 
 				if val == 1 {
@@ -54,7 +53,6 @@ func newCubeChunk(random bool) *Chunk {
 		}
 	}
 
-	chunk.IsLoaded = true
 	chunk.MouseHit = false
 
 	return chunk
@@ -83,7 +81,6 @@ func newSphereChunk() *Chunk {
 		}
 	}
 
-	chunk.IsLoaded = true
 	chunk.MouseHit = false
 
 	return chunk
@@ -110,13 +107,24 @@ func newWireCubeChunk() *Chunk {
 		}
 	}
 
-	chunk.IsLoaded = true
 	chunk.MouseHit = false
 
 	return chunk
 }
 
-func newSimplexChunk(pos ChunkCoord, size int) *Chunk {
+type SimplexParams struct {
+	Scale     float64
+	Threshold float64
+	Block     BlockType
+}
+
+var defaultSimplex = SimplexParams{
+	Scale:     3.0,
+	Threshold: 1.1,
+	Block:     BLOCK_DEFAULT,
+}
+
+func newSimplexChunk(pos ChunkCoord, size int, params *SimplexParams) *Chunk {
 	chunk := &Chunk{}
 
 	// Expecting a perfect cube world
@@ -128,25 +136,64 @@ func newSimplexChunk(pos ChunkCoord, size int) *Chunk {
 				bX := float64((pos.X*ChunkBase)+x) / worldMax
 				bY := float64((pos.Y*ChunkBase)+y) / worldMax
 				bZ := float64((pos.Z*ChunkBase)+z) / worldMax
-				noise := simplex.Noise(bX*3.0, bY*3.0, bZ*3.0)
-				if noise > 1.1 {
+				noise := simplex.Noise(bX*params.Scale, bY*params.Scale, bZ*params.Scale)
+				if noise > params.Threshold {
 					index := BlockCoord{x, y, z}
 					chunk.data[index] = &Block{
-						visible:  false,
-						position: index,
+						visible:   false,
+						position:  index,
+						blockType: params.Block,
 					}
 				}
 			}
 		}
 	}
 
-	chunk.IsLoaded = true
 	chunk.MouseHit = false
 
 	return chunk
 }
 
-func newFloatingRockChunk(pos ChunkCoord, size int) *Chunk {
+// All positions are relative to the world size, 0..1
+type FloatingRockParams struct {
+	// Rock fades out between these heights
+	PlateauStart, PlateauEnd float64
+	// Density bulge around the top center of the world
+	CenterFalloff  float64
+	CenterSpreadXZ float64
+	CenterSpreadY  float64
+	// Holes where the cave noise is below the threshold
+	CaveScale     float64
+	CavePower     float64
+	CaveThreshold float64
+	// Main density noise
+	DensityOctaves int
+	DensityYScale  float64
+	// Breaks up the shape
+	DetailScale      float64
+	DetailPower      float64
+	DensityThreshold float64
+	Block            BlockType
+}
+
+var defaultFloatingRock = FloatingRockParams{
+	PlateauStart:     0.8,
+	PlateauEnd:       0.9,
+	CenterFalloff:    0.2,
+	CenterSpreadXZ:   1.5,
+	CenterSpreadY:    0.8,
+	CaveScale:        5.0,
+	CavePower:        3.0,
+	CaveThreshold:    0.5,
+	DensityOctaves:   5,
+	DensityYScale:    0.5,
+	DetailScale:      3.0,
+	DetailPower:      1.8,
+	DensityThreshold: 3.1,
+	Block:            BLOCK_DEFAULT,
+}
+
+func newFloatingRockChunk(pos ChunkCoord, size int, params *FloatingRockParams) *Chunk {
 	chunk := &Chunk{}
 
 	// Expecting a perfect cube world
@@ -160,33 +207,33 @@ func newFloatingRockChunk(pos ChunkCoord, size int) *Chunk {
 				bZ := float64((pos.Z*ChunkBase)+z) / worldMax
 
 				plateauFallof := 0.0
-				if bY <= 0.8 {
+				if bY <= params.PlateauStart {
 					plateauFallof = 1.0
-				} else if 0.8 < bY && bY < 0.9 {
-					plateauFallof = 1.0 - (bY-0.8)*10.0
+				} else if params.PlateauStart < bY && bY < params.PlateauEnd {
+					plateauFallof = 1.0 - (bY-params.PlateauStart)/(params.PlateauEnd-params.PlateauStart)
 				}
 
-				centerFallof := 0.2 / (math.Pow((bX-0.5)*1.5, 2) + math.Pow((bY-1.0)*0.8, 2) + math.Pow((bZ-0.5)*1.5, 2))
+				centerFallof := params.CenterFalloff / (math.Pow((bX-0.5)*params.CenterSpreadXZ, 2) + math.Pow((bY-1.0)*params.CenterSpreadY, 2) + math.Pow((bZ-0.5)*params.CenterSpreadXZ, 2))
 
-				caves := math.Pow(simplex.Noise(bX*5.0, bY*5.0, bZ*5.0), 3)
+				caves := math.Pow(simplex.Noise(bX*params.CaveScale, bY*params.CaveScale, bZ*params.CaveScale), params.CavePower)
 				density := 0.0
-				if caves >= 0.5 {
-					density = simplex.NoiseOctave(5, bX, bY*0.5, bZ) * centerFallof * plateauFallof
-					density *= math.Pow(simplex.Noise((bX+1.0)*3.0, (bY+1.0)*3.0, ((bZ+1.0)*3.0)+0.4), 1.8)
+				if caves >= params.CaveThreshold {
+					density = simplex.NoiseOctave(params.DensityOctaves, bX, bY*params.DensityYScale, bZ) * centerFallof * plateauFallof
+					density *= math.Pow(simplex.Noise((bX+1.0)*params.DetailScale, (bY+1.0)*params.DetailScale, ((bZ+1.0)*params.DetailScale)+0.4), params.DetailPower)
 				}
 
-				if density > 3.1 {
+				if density > params.DensityThreshold {
 					index := BlockCoord{x, y, z}
 					chunk.data[index] = &Block{
-						visible:  false,
-						position: index,
+						visible:   false,
+						position:  index,
+						blockType: params.Block,
 					}
 				}
 			}
 		}
 	}
 
-	chunk.IsLoaded = true
 	chunk.MouseHit = false
 
 	return chunk
@@ -232,7 +279,6 @@ func newFloatingRockChunk(pos ChunkCoord, size int) *Chunk {
 		}
 	}
 
-	chunk.IsLoaded = true
 	chunk.MouseHit = false
 
 	return chunk
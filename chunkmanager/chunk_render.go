@@ -1,12 +1,11 @@
 package chunkmanager
 
 import (
-	"bedrock/shader"
 	"bedrock/math/matrix"
 	"bedrock/math/vector"
+	"dwelling/renderer"
 	"fmt"
-	gl "github.com/chsc/gogl/gl33"
-	"unsafe"
+	"time"
 )
 
 const (
@@ -18,449 +17,288 @@ const (
 	BOTTOM
 )
 
-var chunkNormals = [6]vector.Vector3f{
-	{0.0, 0.0, 1.0},
-	{0.0, 0.0, -1.0},
-	{-1.0, 0.0, 0.0},
-	{1.0, 0.0, 0.0},
-	{0.0, 1.0, 0.0},
-	{0.0, -1.0, 0.0},
-}
+var chunkNormals = renderer.FaceNormals
 
 type ChunkMesh struct {
-	vao             [6]gl.Uint
-	vertexBufferIds [6]gl.Uint
-	indexBufferIds  [6]gl.Uint
-	occBufferIds    [6]gl.Uint
-	numVertices     [6]gl.Sizei
-	numIndices      [6]gl.Sizei
+	id          renderer.MeshId
+	numVertices [6]int
+	numIndices  [6]int
 }
 
-func appendChunkFace(faceBuffer *[]float32, indexBuffer *[]uint32, occBuffer *[]float32, occFactor [6]float64, x, y, z float32, face int) {
-	var vertices [4]vector.Vector4f
-
-	switch face {
-	case FRONT:
-		avgOcc := [4]float64{
-			(occFactor[FRONT] + occFactor[LEFT] + occFactor[BOTTOM]) / 3.0,
-			(occFactor[FRONT] + occFactor[RIGHT] + occFactor[BOTTOM]) / 3.0,
-			(occFactor[FRONT] + occFactor[RIGHT] + occFactor[TOP]) / 3.0,
-			(occFactor[FRONT] + occFactor[LEFT] + occFactor[TOP]) / 3.0,
-		}
-		vertices = [4]vector.Vector4f{
-			{float64(x), float64(y), float64(z) + 1.0, avgOcc[0]},
-			{float64(x) + 1.0, float64(y), float64(z) + 1.0, avgOcc[1]},
-			{float64(x) + 1.0, float64(y) + 1.0, float64(z) + 1.0, avgOcc[2]},
-			{float64(x), float64(y) + 1.0, float64(z) + 1.0, avgOcc[3]},
-		}
-
-	case BACK:
-		avgOcc := [4]float64{
-			(occFactor[BACK] + occFactor[RIGHT] + occFactor[TOP]) / 3.0,
-			(occFactor[BACK] + occFactor[RIGHT] + occFactor[BOTTOM]) / 3.0,
-			(occFactor[BACK] + occFactor[LEFT] + occFactor[BOTTOM]) / 3.0,
-			(occFactor[BACK] + occFactor[LEFT] + occFactor[TOP]) / 3.0,
-		}
-		vertices = [4]vector.Vector4f{
-			{float64(x) + 1.0, float64(y) + 1.0, float64(z), avgOcc[0]},
-			{float64(x) + 1.0, float64(y), float64(z), avgOcc[1]},
-			{float64(x), float64(y), float64(z), avgOcc[2]},
-			{float64(x), float64(y) + 1.0, float64(z), avgOcc[3]},
-		}
-
-	case LEFT:
-		avgOcc := [4]float64{
-			(occFactor[LEFT] + occFactor[BACK] + occFactor[BOTTOM]) / 3.0,
-			(occFactor[LEFT] + occFactor[FRONT] + occFactor[BOTTOM]) / 3.0,
-			(occFactor[LEFT] + occFactor[FRONT] + occFactor[TOP]) / 3.0,
-			(occFactor[LEFT] + occFactor[BACK] + occFactor[TOP]) / 3.0,
-		}
-		vertices = [4]vector.Vector4f{
-			{float64(x), float64(y), float64(z), avgOcc[0]},
-			{float64(x), float64(y), float64(z) + 1.0, avgOcc[1]},
-			{float64(x), float64(y) + 1.0, float64(z) + 1.0, avgOcc[2]},
-			{float64(x), float64(y) + 1.0, float64(z), avgOcc[3]},
-		}
+// Block offset towards each face
+var faceOffsets = [6][3]int{
+	{0, 0, 1},
+	{0, 0, -1},
+	{-1, 0, 0},
+	{1, 0, 0},
+	{0, 1, 0},
+	{0, -1, 0},
+}
 
-	case RIGHT:
-		avgOcc := [4]float64{
-			(occFactor[RIGHT] + occFactor[FRONT] + occFactor[TOP]) / 3.0,
-			(occFactor[RIGHT] + occFactor[FRONT] + occFactor[BOTTOM]) / 3.0,
-			(occFactor[RIGHT] + occFactor[BACK] + occFactor[BOTTOM]) / 3.0,
-			(occFactor[RIGHT] + occFactor[BACK] + occFactor[TOP]) / 3.0,
-		}
-		vertices = [4]vector.Vector4f{
-			{float64(x) + 1.0, float64(y) + 1.0, float64(z) + 1.0, avgOcc[0]},
-			{float64(x) + 1.0, float64(y), float64(z) + 1.0, avgOcc[1]},
-			{float64(x) + 1.0, float64(y), float64(z), avgOcc[2]},
-			{float64(x) + 1.0, float64(y) + 1.0, float64(z), avgOcc[3]},
-		}
+// Height is where the top of the block is, below 1.0 for fluids that aren't
+// full. vertOcc and vertShadow are the occlusion and sun shadow of each
+// corner.
+func appendChunkFace(mesh *renderer.ChunkMeshData, vertOcc, vertShadow [4]float64, pos BlockCoord, height float64, face int, tile int) {
+	for index := range renderer.FaceCorners[face] {
+		mesh.AppendVertex(face, renderer.ChunkVertex{
+			X:         pos.X,
+			Y:         pos.Y,
+			Z:         pos.Z,
+			Face:      face,
+			Corner:    index,
+			Height:    height,
+			Occlusion: vertOcc[index],
+			Shadow:    vertShadow[index],
+			Tile:      tile,
+		})
+	}
+}
 
-	case TOP:
-		avgOcc := [4]float64{
-			(occFactor[TOP] + occFactor[RIGHT] + occFactor[FRONT]) / 3.0,
-			(occFactor[TOP] + occFactor[RIGHT] + occFactor[BACK]) / 3.0,
-			(occFactor[TOP] + occFactor[LEFT] + occFactor[BACK]) / 3.0,
-			(occFactor[TOP] + occFactor[LEFT] + occFactor[FRONT]) / 3.0,
-		}
-		vertices = [4]vector.Vector4f{
-			{float64(x) + 1.0, float64(y) + 1.0, float64(z) + 1.0, avgOcc[0]},
-			{float64(x) + 1.0, float64(y) + 1.0, float64(z), avgOcc[1]},
-			{float64(x), float64(y) + 1.0, float64(z), avgOcc[2]},
-			{float64(x), float64(y) + 1.0, float64(z) + 1.0, avgOcc[3]},
-		}
+// A chunk and the 26 around it, so blocks just across any border, edges and
+// corners included, can be looked up while building a mesh.
+type chunkNeighborhood [3][3][3]*Chunk
 
-	case BOTTOM:
-		avgOcc := [4]float64{
-			(occFactor[BOTTOM] + occFactor[LEFT] + occFactor[BACK]) / 3.0,
-			(occFactor[BOTTOM] + occFactor[RIGHT] + occFactor[BACK]) / 3.0,
-			(occFactor[BOTTOM] + occFactor[RIGHT] + occFactor[FRONT]) / 3.0,
-			(occFactor[BOTTOM] + occFactor[LEFT] + occFactor[FRONT]) / 3.0,
-		}
-		vertices = [4]vector.Vector4f{
-			{float64(x), float64(y), float64(z), avgOcc[0]},
-			{float64(x) + 1.0, float64(y), float64(z), avgOcc[1]},
-			{float64(x) + 1.0, float64(y), float64(z) + 1.0, avgOcc[2]},
-			{float64(x), float64(y), float64(z) + 1.0, avgOcc[3]},
+func getChunkNeighborhood(pos ChunkCoord) *chunkNeighborhood {
+	neighbors := &chunkNeighborhood{}
+	for x := -1; x <= 1; x++ {
+		for y := -1; y <= 1; y++ {
+			for z := -1; z <= 1; z++ {
+				neighbors[x+1][y+1][z+1] = chunkMap[ChunkCoord{pos.X + x, pos.Y + y, pos.Z + z}]
+			}
 		}
-
-	default:
-		return
-	}
-
-	vertIds := [4]uint32{}
-	for index, vertex := range vertices {
-		(*faceBuffer) = append((*faceBuffer), float32(vertex.X), float32(vertex.Y), float32(vertex.Z))
-		(*occBuffer) = append((*occBuffer), float32(vertex.W))
-		vertIds[index] = uint32((len((*faceBuffer)) - 3) / 3)
 	}
 
-	a := vertIds[0]
-	b := vertIds[1]
-	c := vertIds[2]
-	d := vertIds[3]
-
-	(*indexBuffer) = append((*indexBuffer),
-		a, b, c,
-		c, d, a,
-	)
+	return neighbors
 }
 
-func createMeshBuffer(faceBuffer *[]float32, size int) gl.Uint {
-	var buffer gl.Uint
-	sizeFloat := int(unsafe.Sizeof([1]float32{}))
-	bufferPtr := (*faceBuffer)
-
-	gl.GenBuffers(1, &buffer)
-	gl.BindBuffer(gl.ARRAY_BUFFER, buffer)
-	gl.BufferData(gl.ARRAY_BUFFER, gl.Sizeiptr(sizeFloat*size), gl.Pointer(&bufferPtr[0]), gl.STATIC_DRAW)
+// Block at a position relative to the centre chunk, up to a chunk outside
+// of it.
+func (neighbors *chunkNeighborhood) block(x, y, z int) *Block {
+	chnkX := floorDiv(x, ChunkBase)
+	chnkY := floorDiv(y, ChunkBase)
+	chnkZ := floorDiv(z, ChunkBase)
+	chnk := neighbors[chnkX+1][chnkY+1][chnkZ+1]
+	if chnk == nil {
+		return nil
+	}
 
-	return buffer
+	return chnk.data[BlockCoord{x - (chnkX * ChunkBase), y - (chnkY * ChunkBase), z - (chnkZ * ChunkBase)}]
 }
 
-func createIndexBuffer(faceBuffer *[]uint32, size int) gl.Uint {
-	var buffer gl.Uint
-	sizeInt := int(unsafe.Sizeof([1]uint32{}))
-	bufferPtr := (*faceBuffer)
+// Occlusion and sun shadow of the corner of a face at vertex, relative to
+// the centre chunk. Averages the four cells around the vertex in the layer
+// the face lies on: a cell counts with its own values for the face
+// direction when that face is open, as fully occluded and shadowed when a
+// block sits in front of it and not at all when it and the cell in front
+// are empty. Faces meeting at a vertex see the same cells, so they get the
+// same values even across chunks.
+func vertexOcclusion(neighbors *chunkNeighborhood, vertex [3]int, face int) (float64, float64) {
+	normal := faceOffsets[face]
+	axis := 0
+	for normal[axis] == 0 {
+		axis++
+	}
+	tangentA := (axis + 1) % 3
+	tangentB := (axis + 2) % 3
+
+	total := 0.0
+	shadow := 0.0
+	count := 0
+	for a := 0; a < 2; a++ {
+		for b := 0; b < 2; b++ {
+			cell := vertex
+			cell[tangentA] -= a
+			cell[tangentB] -= b
+			// The vertex is on the far side of blocks facing the positive
+			// direction
+			if normal[axis] > 0 {
+				cell[axis]--
+			}
+			front := cell
+			front[axis] += normal[axis]
+
+			if neighbors.block(front[0], front[1], front[2]) != nil {
+				count++
+			} else if blk := neighbors.block(cell[0], cell[1], cell[2]); blk != nil {
+				total += blk.occlusion[face]
+				shadow += blk.shadow[face]
+				count++
+			}
+		}
+	}
 
-	gl.GenBuffers(1, &buffer)
-	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, buffer)
-	gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, gl.Sizeiptr(sizeInt*size), gl.Pointer(&bufferPtr[0]), gl.STATIC_DRAW)
+	if count == 0 {
+		return 1.0, 1.0
+	}
+	return total / float64(count), shadow / float64(count)
+}
 
-	return buffer
+// Whether neighbor covers the face of blk touching it, the heights are the
+// tops of the two blocks. See-through blocks only hide faces of their own
+// type, so water doesn't show its inside while stone behind glass or leaves
+// still gets drawn.
+func hidesFace(blk, neighbor *Block, height, neighborHeight float64) bool {
+	if neighbor == nil {
+		return false
+	}
+	// Fluids are usually lower than a full block, a lower neighbour leaves
+	// the top of the side showing
+	if GetBlockTypeInfo(neighbor.blockType).Fluid != nil {
+		return neighbor.blockType == blk.blockType && neighborHeight >= height
+	}
+	if GetBlockTypeInfo(neighbor.blockType).Transparency == TRANSPARENCY_NONE {
+		return true
+	}
+	return neighbor.blockType == blk.blockType
 }
 
 func (chunk *Chunk) CreateVertexData(rebuildCh chan<- RebuildData) {
-	chunks := GetChunksAroundChunk(chunk.position)
-
-	vertexBuffers := [6][]float32{}
-	indexBuffers := [6][]uint32{}
-	occBuffers := [6][]float32{}
-	for pos := range chunk.data {
-		x := float32(pos.X)
-		y := float32(pos.Y)
-		z := float32(pos.Z)
-
-		sides := 0
-		if _, ok := chunk.data[BlockCoord{pos.X, pos.Y, pos.Z + 1}]; !ok {
-			skip := false
-			if pos.Z == ChunkBase-1 && chunks[FRONT] != nil {
-				if _, ok := chunks[FRONT].data[BlockCoord{pos.X, pos.Y, 0}]; ok {
-					skip = true
-				}
-			}
-
-			if !skip {
-				occFactor := [6]float64{
-					chunk.data[pos].occlusion[FRONT],
-					chunk.data[pos].occlusion[FRONT],
-					chunk.data[pos].occlusion[FRONT],
-					chunk.data[pos].occlusion[FRONT],
-					chunk.data[pos].occlusion[FRONT],
-					chunk.data[pos].occlusion[FRONT],
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X, pos.Y + 1, pos.Z}]; ok {
-					occFactor[TOP] = neighbor.occlusion[FRONT]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X, pos.Y - 1, pos.Z}]; ok {
-					occFactor[BOTTOM] = neighbor.occlusion[FRONT]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X - 1, pos.Y, pos.Z}]; ok {
-					occFactor[LEFT] = neighbor.occlusion[FRONT]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X + 1, pos.Y, pos.Z}]; ok {
-					occFactor[RIGHT] = neighbor.occlusion[FRONT]
-				}
-
-				sides++
-				appendChunkFace(&vertexBuffers[FRONT], &indexBuffers[FRONT], &occBuffers[FRONT], occFactor, x, y, z, FRONT)
-			}
+	neighbors := getChunkNeighborhood(chunk.position)
+
+	opaque := &renderer.ChunkMeshData{}
+	translucent := &renderer.ChunkMeshData{}
+	for pos, blk := range chunk.data {
+		mesh := opaque
+		if GetBlockTypeInfo(blk.blockType).Transparency == TRANSPARENCY_BLEND {
+			mesh = translucent
 		}
-		if _, ok := chunk.data[BlockCoord{pos.X, pos.Y, pos.Z - 1}]; !ok {
-			skip := false
-			if pos.Z == 0 && chunks[BACK] != nil {
-				if _, ok := chunks[BACK].data[BlockCoord{pos.X, pos.Y, ChunkBase - 1}]; ok {
-					skip = true
-				}
-			}
 
-			if !skip {
-				occFactor := [6]float64{
-					chunk.data[pos].occlusion[BACK],
-					chunk.data[pos].occlusion[BACK],
-					chunk.data[pos].occlusion[BACK],
-					chunk.data[pos].occlusion[BACK],
-					chunk.data[pos].occlusion[BACK],
-					chunk.data[pos].occlusion[BACK],
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X, pos.Y + 1, pos.Z}]; ok {
-					occFactor[TOP] = neighbor.occlusion[BACK]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X, pos.Y - 1, pos.Z}]; ok {
-					occFactor[BOTTOM] = neighbor.occlusion[BACK]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X - 1, pos.Y, pos.Z}]; ok {
-					occFactor[LEFT] = neighbor.occlusion[BACK]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X + 1, pos.Y, pos.Z}]; ok {
-					occFactor[RIGHT] = neighbor.occlusion[BACK]
-				}
-
-				sides++
-				appendChunkFace(&vertexBuffers[BACK], &indexBuffers[BACK], &occBuffers[BACK], occFactor, x, y, z, BACK)
-			}
-		}
-		if _, ok := chunk.data[BlockCoord{pos.X - 1, pos.Y, pos.Z}]; !ok {
-			skip := false
-			if pos.X == 0 && chunks[LEFT] != nil {
-				if _, ok := chunks[LEFT].data[BlockCoord{ChunkBase - 1, pos.Y, pos.Z}]; ok {
-					skip = true
-				}
-			}
-
-			if !skip {
-				occFactor := [6]float64{
-					chunk.data[pos].occlusion[LEFT],
-					chunk.data[pos].occlusion[LEFT],
-					chunk.data[pos].occlusion[LEFT],
-					chunk.data[pos].occlusion[LEFT],
-					chunk.data[pos].occlusion[LEFT],
-					chunk.data[pos].occlusion[LEFT],
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X, pos.Y + 1, pos.Z}]; ok {
-					occFactor[TOP] = neighbor.occlusion[LEFT]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X, pos.Y - 1, pos.Z}]; ok {
-					occFactor[BOTTOM] = neighbor.occlusion[LEFT]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X, pos.Y, pos.Z - 1}]; ok {
-					occFactor[BACK] = neighbor.occlusion[LEFT]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X, pos.Y, pos.Z + 1}]; ok {
-					occFactor[FRONT] = neighbor.occlusion[LEFT]
-				}
-
-				sides++
-				appendChunkFace(&vertexBuffers[LEFT], &indexBuffers[LEFT], &occBuffers[LEFT], occFactor, x, y, z, LEFT)
-			}
+		height := 1.0
+		if GetBlockTypeInfo(blk.blockType).Fluid != nil {
+			height = fluidHeight(neighbors, pos, blk)
 		}
-		if _, ok := chunk.data[BlockCoord{pos.X + 1, pos.Y, pos.Z}]; !ok {
-			skip := false
-			if pos.X == ChunkBase-1 && chunks[RIGHT] != nil {
-				if _, ok := chunks[RIGHT].data[BlockCoord{0, pos.Y, pos.Z}]; ok {
-					skip = true
-				}
-			}
 
-			if !skip {
-				occFactor := [6]float64{
-					chunk.data[pos].occlusion[RIGHT],
-					chunk.data[pos].occlusion[RIGHT],
-					chunk.data[pos].occlusion[RIGHT],
-					chunk.data[pos].occlusion[RIGHT],
-					chunk.data[pos].occlusion[RIGHT],
-					chunk.data[pos].occlusion[RIGHT],
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X, pos.Y + 1, pos.Z}]; ok {
-					occFactor[TOP] = neighbor.occlusion[RIGHT]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X, pos.Y - 1, pos.Z}]; ok {
-					occFactor[BOTTOM] = neighbor.occlusion[RIGHT]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X, pos.Y, pos.Z - 1}]; ok {
-					occFactor[BACK] = neighbor.occlusion[RIGHT]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X, pos.Y, pos.Z + 1}]; ok {
-					occFactor[FRONT] = neighbor.occlusion[RIGHT]
-				}
-
-				sides++
-				appendChunkFace(&vertexBuffers[RIGHT], &indexBuffers[RIGHT], &occBuffers[RIGHT], occFactor, x, y, z, RIGHT)
+		sides := 0
+		for face := 0; face < 6; face++ {
+			offset := faceOffsets[face]
+			neighborPos := BlockCoord{pos.X + offset[0], pos.Y + offset[1], pos.Z + offset[2]}
+			neighbor := neighbors.block(neighborPos.X, neighborPos.Y, neighborPos.Z)
+			// Only the sides can stick out above a lower fluid, fluid
+			// above or below always covers the whole face
+			neighborHeight := 1.0
+			if face < 4 && neighbor != nil && GetBlockTypeInfo(neighbor.blockType).Fluid != nil {
+				neighborHeight = fluidHeight(neighbors, neighborPos, neighbor)
 			}
-		}
-		if _, ok := chunk.data[BlockCoord{pos.X, pos.Y + 1, pos.Z}]; !ok {
-			skip := false
-			if pos.Y == ChunkBase-1 && chunks[TOP] != nil {
-				if _, ok := chunks[TOP].data[BlockCoord{pos.X, 0, pos.Z}]; ok {
-					skip = true
-				}
+			if hidesFace(blk, neighbor, height, neighborHeight) {
+				continue
 			}
 
-			if !skip {
-				occFactor := [6]float64{
-					chunk.data[pos].occlusion[TOP],
-					chunk.data[pos].occlusion[TOP],
-					chunk.data[pos].occlusion[TOP],
-					chunk.data[pos].occlusion[TOP],
-					chunk.data[pos].occlusion[TOP],
-					chunk.data[pos].occlusion[TOP],
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X, pos.Y, pos.Z + 1}]; ok {
-					occFactor[FRONT] = neighbor.occlusion[TOP]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X, pos.Y, pos.Z - 1}]; ok {
-					occFactor[BACK] = neighbor.occlusion[TOP]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X - 1, pos.Y, pos.Z}]; ok {
-					occFactor[LEFT] = neighbor.occlusion[TOP]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X + 1, pos.Y, pos.Z}]; ok {
-					occFactor[RIGHT] = neighbor.occlusion[TOP]
-				}
-
-				sides++
-				appendChunkFace(&vertexBuffers[TOP], &indexBuffers[TOP], &occBuffers[TOP], occFactor, x, y, z, TOP)
-			}
-		}
-		if _, ok := chunk.data[BlockCoord{pos.X, pos.Y - 1, pos.Z}]; !ok {
-			skip := false
-			if pos.Y == 0 && chunks[BOTTOM] != nil {
-				if _, ok := chunks[BOTTOM].data[BlockCoord{pos.X, ChunkBase - 1, pos.Z}]; ok {
-					skip = true
-				}
+			vertOcc := [4]float64{}
+			vertShadow := [4]float64{}
+			for index, corner := range renderer.FaceCorners[face] {
+				vertex := [3]int{pos.X + corner[0], pos.Y + corner[1], pos.Z + corner[2]}
+				vertOcc[index], vertShadow[index] = vertexOcclusion(neighbors, vertex, face)
 			}
 
-			if !skip {
-				occFactor := [6]float64{
-					chunk.data[pos].occlusion[BOTTOM],
-					chunk.data[pos].occlusion[BOTTOM],
-					chunk.data[pos].occlusion[BOTTOM],
-					chunk.data[pos].occlusion[BOTTOM],
-					chunk.data[pos].occlusion[BOTTOM],
-					chunk.data[pos].occlusion[BOTTOM],
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X, pos.Y, pos.Z + 1}]; ok {
-					occFactor[FRONT] = neighbor.occlusion[TOP]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X, pos.Y, pos.Z - 1}]; ok {
-					occFactor[BACK] = neighbor.occlusion[TOP]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X - 1, pos.Y, pos.Z}]; ok {
-					occFactor[LEFT] = neighbor.occlusion[TOP]
-				}
-				if neighbor, ok := chunk.data[BlockCoord{pos.X + 1, pos.Y, pos.Z}]; ok {
-					occFactor[RIGHT] = neighbor.occlusion[TOP]
-				}
-
-				sides++
-				appendChunkFace(&vertexBuffers[BOTTOM], &indexBuffers[BOTTOM], &occBuffers[BOTTOM], occFactor, x, y, z, BOTTOM)
-			}
+			sides++
+			appendChunkFace(mesh, vertOcc, vertShadow, pos, height, face, faceTile(blk.blockType, face))
 		}
 
 		if sides > 0 {
-			blk := chunk.data[pos]
 			blk.visible = true
-			chunk.data[pos] = blk
 		}
 	}
 
 	rebuildData := RebuildData{
-		vertexBuffers: vertexBuffers,
-		indexBuffers:  indexBuffers,
-		occBuffers:    occBuffers,
-		chunk:         chunk,
+		opaque:      opaque,
+		translucent: translucent,
+		chunk:       chunk,
 	}
 	rebuildCh <- rebuildData
 }
 
-func (chunk *Chunk) SetChunkMesh(rebuildData RebuildData) {
-	vertexBuffers := rebuildData.vertexBuffers
-	indexBuffers := rebuildData.indexBuffers
-	occBuffers := rebuildData.occBuffers
+type MeshTiming struct {
+	NumChunks   int
+	NumVertices int
+	VertexBytes int
+	// The shared index buffer, grown the way the GL backend grows it
+	IndexBytes int
+	Build      time.Duration
+	Upload     time.Duration
+}
 
-	for t := 0; t < 6; t++ {
-		chunk.mesh.numVertices[t] = gl.Sizei(len(vertexBuffers[t]))
-		chunk.mesh.numIndices[t] = gl.Sizei(len(indexBuffers[t]))
-		if chunk.mesh.numVertices[t] > 0 && chunk.mesh.numIndices[t] > 0 {
-			if chunk.mesh.vao[t] == 0 {
-				gl.GenVertexArrays(1, &chunk.mesh.vao[t])
-				gl.BindVertexArray(chunk.mesh.vao[t])
-				gl.EnableVertexAttribArray(0)
-				gl.EnableVertexAttribArray(1)
-			}
+// Builds the mesh of every finished chunk and uploads it to the current
+// backend, timing both.
+func MeasureMeshes() MeshTiming {
+	timing := MeshTiming{}
+	reservedQuads := 0
+	for _, chnk := range chunkMap {
+		if chnk.Stage < STAGE_OCCLUSION {
+			continue
+		}
 
-			gl.BindVertexArray(chunk.mesh.vao[t])
-			if chunk.mesh.vertexBufferIds[t] > 0 {
-				// Refactor this shit!
-				sizeFloat := int(unsafe.Sizeof([1]float32{}))
-				sizeInt := int(unsafe.Sizeof([1]uint32{}))
-				size := gl.Sizeiptr(sizeFloat * len(vertexBuffers[t]))
-				gl.BindBuffer(gl.ARRAY_BUFFER, chunk.mesh.vertexBufferIds[t])
-				gl.VertexAttribPointer(0, 3, gl.FLOAT, gl.FALSE, 0, nil)
-				gl.BufferData(gl.ARRAY_BUFFER, size, gl.Pointer(&vertexBuffers[t][0]), gl.STATIC_DRAW)
-
-				size = gl.Sizeiptr(sizeFloat * len(occBuffers[t]))
-				gl.BindBuffer(gl.ARRAY_BUFFER, chunk.mesh.occBufferIds[t])
-				gl.VertexAttribPointer(1, 1, gl.FLOAT, gl.FALSE, 0, nil)
-				gl.BufferData(gl.ARRAY_BUFFER, size, gl.Pointer(&occBuffers[t][0]), gl.STATIC_DRAW)
-
-				size = gl.Sizeiptr(sizeInt * len(indexBuffers[t]))
-				gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, chunk.mesh.indexBufferIds[t])
-				gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, size, gl.Pointer(&indexBuffers[t][0]), gl.STATIC_DRAW)
-			} else {
-				chunk.mesh.vertexBufferIds[t] = createMeshBuffer(&vertexBuffers[t], len(vertexBuffers[t]))
-				chunk.mesh.indexBufferIds[t] = createIndexBuffer(&indexBuffers[t], len(indexBuffers[t]))
-				chunk.mesh.occBufferIds[t] = createMeshBuffer(&occBuffers[t], len(occBuffers[t]))
+		start := time.Now()
+		ch := make(chan RebuildData, 1)
+		chnk.CreateVertexData(ch)
+		data := <-ch
+		timing.Build += time.Since(start)
+
+		start = time.Now()
+		chnk.mesh.update(data.opaque)
+		chnk.translucentMesh.update(data.translucent)
+		timing.Upload += time.Since(start)
+
+		timing.NumChunks++
+		for _, mesh := range []*renderer.ChunkMeshData{data.opaque, data.translucent} {
+			timing.VertexBytes += mesh.Bytes()
+			numQuads := 0
+			for face := 0; face < 6; face++ {
+				timing.NumVertices += mesh.NumVertices(face)
+				numQuads += mesh.NumVertices(face) / 4
 			}
+			// A whole mesh can be drawn as one range, the buffer is grown
+			// to twice a mesh that doesn't fit
+			if numQuads > reservedQuads {
+				reservedQuads = numQuads * 2
+			}
+		}
+	}
+	timing.IndexBytes = reservedQuads * 6 * 4
+
+	return timing
+}
 
-			// Vertices
-			gl.BindBuffer(gl.ARRAY_BUFFER, chunk.mesh.vertexBufferIds[t])
-			gl.VertexAttribPointer(0, 3, gl.FLOAT, gl.FALSE, 0, nil)
-			// Occlusion factor
-			gl.BindBuffer(gl.ARRAY_BUFFER, chunk.mesh.occBufferIds[t])
-			gl.VertexAttribPointer(1, 1, gl.FLOAT, gl.FALSE, 0, nil)
-			// Indices
-			gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, chunk.mesh.indexBufferIds[t])
+func (mesh *ChunkMesh) update(data *renderer.ChunkMeshData) {
+	mesh.id = renderer.Current().UpdateChunkMesh(mesh.id, data)
+
+	for t := 0; t < 6; t++ {
+		mesh.numVertices[t] = data.NumVertices(t)
+		mesh.numIndices[t] = (mesh.numVertices[t] / 4) * 6
+	}
+}
+
+// Hands the chunk's meshes back to the backend, for chunks being dropped
+func (chunk *Chunk) deleteMeshes() {
+	for _, mesh := range []*ChunkMesh{&chunk.mesh, &chunk.translucentMesh} {
+		if mesh.id != 0 {
+			renderer.Current().DeleteChunkMesh(mesh.id)
+		}
+		*mesh = ChunkMesh{}
+	}
+}
+
+func (mesh *ChunkMesh) isEmpty() bool {
+	for t := 0; t < 6; t++ {
+		if mesh.numIndices[t] > 0 {
+			return false
 		}
 	}
+	return true
+}
+
+func (chunk *Chunk) SetChunkMesh(rebuildData RebuildData) {
+	chunk.mesh.update(rebuildData.opaque)
+	chunk.translucentMesh.update(rebuildData.translucent)
 
 	numVertices := 0
 	numIndices := 0
 	numFaces := 0
-	for t := 0; t < 6; t++ {
-		numVertices += int(chunk.mesh.numVertices[t])
-		numIndices += int(chunk.mesh.numIndices[t])
-		numFaces += int(chunk.mesh.numVertices[t] / 9.0)
+	for _, mesh := range []*ChunkMesh{&chunk.mesh, &chunk.translucentMesh} {
+		for t := 0; t < 6; t++ {
+			numVertices += int(mesh.numVertices[t])
+			numIndices += int(mesh.numIndices[t])
+			numFaces += mesh.numVertices[t] / 4
+		}
 	}
 	worstCaseFaces := len(chunk.data) * 12
 	fmt.Printf("%d vertices, %d indices, %d faces vs %d total, saved %d\n", numVertices, numIndices, numFaces, worstCaseFaces, worstCaseFaces-numFaces)
@@ -475,12 +313,15 @@ var facePos = [6]vector.Vector3f{
 	{0.0, 0.0 + float64(ChunkBase), 0.0},
 }
 
-func (chunk *Chunk) RenderChunk(chunkShader *shader.ShaderProgram, cam vector.Vector3f, world *matrix.Matrix, wireframe bool) {
+// Draws the face groups that can face the camera in one go
+func (mesh *ChunkMesh) render(cam vector.Vector3f, world *matrix.Matrix, mouseHit, wireframe bool) {
 	invModel, _ := matrix.InvertMatrix(world)
 	invModel = invModel.Transpose()
 
+	faces := [6]bool{}
+	anyFaces := false
 	for t := 0; t < 6; t++ {
-		if chunk.mesh.numVertices[t] > 0 && chunk.mesh.numIndices[t] > 0 {
+		if mesh.numVertices[t] > 0 && mesh.numIndices[t] > 0 {
 			normal := chunkNormals[t]
 			normal = matrix.MultiplyVector3f(invModel, normal)
 			face := matrix.MultiplyVector3f(world, facePos[t])
@@ -488,26 +329,23 @@ func (chunk *Chunk) RenderChunk(chunkShader *shader.ShaderProgram, cam vector.Ve
 			dot := vector.DotProduct(camDir, normal)
 
 			if dot > 0.0 {
-				mouseHit := 0
-				if chunk.MouseHit {
-					mouseHit = 1
-				}
-				chunkShader.SetUniformVector3f("normal", normal)
-				chunkShader.SetUniformInt("mouseHit", mouseHit)
-				chunk.renderMeshBuffer(t, wireframe)
+				faces[t] = true
+				anyFaces = true
 			}
 		}
 	}
-}
-
-func (chunk *Chunk) renderMeshBuffer(side int, wireframe bool) {
-	gl.BindVertexArray(chunk.mesh.vao[side])
 
-	if wireframe {
-		gl.DrawElements(gl.LINES, chunk.mesh.numIndices[side], gl.UNSIGNED_INT, nil)
-	} else {
-		gl.DrawElements(gl.TRIANGLES, chunk.mesh.numIndices[side], gl.UNSIGNED_INT, nil)
+	if anyFaces {
+		renderer.Current().DrawChunk(mesh.id, faces, world, mouseHit, wireframe)
 	}
+}
+
+func (chunk *Chunk) RenderChunk(cam vector.Vector3f, world *matrix.Matrix, wireframe bool) {
+	chunk.mesh.render(cam, world, chunk.MouseHit, wireframe)
+}
 
-	gl.BindVertexArray(0)
+// Draws the blended faces, call after every chunk's RenderChunk and inside
+// a translucent pass.
+func (chunk *Chunk) RenderTranslucentChunk(cam vector.Vector3f, world *matrix.Matrix) {
+	chunk.translucentMesh.render(cam, world, chunk.MouseHit, false)
 }
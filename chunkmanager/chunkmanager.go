@@ -1,16 +1,18 @@
 package chunkmanager
 
 import (
-	"bedrock"
 	"bedrock/math/matrix"
 	"bedrock/math/vector"
 	"dwelling/camera"
+	"dwelling/renderer"
 	"fmt"
 	"math"
 	"math/rand"
 	"time"
 )
 
+// Blocks per chunk axis, packed vertices allow up to
+// renderer.MAX_CHUNK_BLOCKS
 const ChunkBase int = 16
 
 type ChunkCoord struct {
@@ -21,20 +23,30 @@ type BlockCoord struct {
 	X, Y, Z int
 }
 
+// Block position in the world rather than within a chunk
+type WorldCoord struct {
+	X, Y, Z int
+}
+
 type Chunk struct {
-	data         map[BlockCoord]*Block
-	mesh         ChunkMesh
-	IsLoaded     bool
-	IsSetup      bool
-	IsRebuilding bool
-	MouseHit     bool
-	position     ChunkCoord
+	data            map[BlockCoord]*Block
+	mesh            ChunkMesh
+	translucentMesh ChunkMesh
+	Stage           int
+	IsRebuilding    bool
+	MouseHit        bool
+	position        ChunkCoord
 }
 
 type Block struct {
 	visible   bool
 	position  BlockCoord
+	blockType BlockType
 	occlusion [6]float64
+	// Share of each face the sun reaches
+	shadow [6]float64
+	// Fluids only, steps away from the source, 0 is a source block
+	flow int
 }
 
 var chunkMap = map[ChunkCoord]*Chunk{}
@@ -47,57 +59,174 @@ var camView = vector.Vector3f{0.0, 0.0, -1.0}
 
 var debugMode = false
 
+// Chunks per axis of the demo world
+var worldSize = 4
+
+// Chunks further than this many chunks from the camera aren't drawn, fog
+// hides them before they'd pop out of view
+var viewDistance = 12
+
+var worldSeed int64
+
+// Sets the seed used for generation, call before Start. Unset, Start picks
+// one from the clock.
+func SetSeed(seed int64) {
+	worldSeed = seed
+}
+
 func Start() error {
-	rand.Seed(time.Now().Unix())
-
-	cubed := 4
-	for x := 0; x < cubed; x++ {
-		for z := 0; z < cubed; z++ {
-			for y := 0; y < cubed; y++ {
-				val := rand.Intn(6)
-
-				var chunk *Chunk
-				switch val {
-				case 0:
-					chunk = newCubeChunk(false)
-				case 1:
-					chunk = newCubeChunk(true)
-				case 2:
-					chunk = newPyramidChunk(false)
-				case 3:
-					chunk = newPyramidChunk(true)
-				case 4:
-					chunk = newSphereChunk()
-				case 5:
-					chunk = newWireCubeChunk()
-				default:
-					chunk = newCubeChunk(false)
-				}
-				//chunk := newFloatingRockChunk(ChunkCoord{x, y, z}, cubed)
-				//chunk := newSimplexChunk(ChunkCoord{x, y, z}, cubed)
-				chunk.position = ChunkCoord{x, y, z}
-				chunkMap[chunk.position] = chunk
+	if worldSeed == 0 {
+		worldSeed = time.Now().Unix()
+	}
+	rand.Seed(worldSeed)
+
+	for x := 0; x < worldSize; x++ {
+		for z := 0; z < worldSize; z++ {
+			for y := 0; y < worldSize; y++ {
+				requestChunk(ChunkCoord{x, y, z})
 			}
 		}
 	}
+	for updatePipeline(STAGE_OCCLUSION) > 0 {
+	}
+
+	return nil
+}
+
+// Drops the world and everything waiting to change it: chunks and their
+// meshes, structure writes and placers, fluids, edits and their history and
+// shadow rebakes. The generator, seed and sun are kept.
+func resetWorld() {
+	for _, chnk := range chunkMap {
+		chnk.deleteMeshes()
+	}
+	chunkMap = map[ChunkCoord]*Chunk{}
+	rebuildChunks = map[ChunkCoord]*Chunk{}
+	visibleChunks = map[ChunkCoord]*Chunk{}
+	renderChunks = map[ChunkCoord]*Chunk{}
 
-	for chnkPos, chunk := range chunkMap {
-		for blkPos, block := range chunk.data {
-			block.occlusion = occlusion(chnkPos, blkPos, cubed)
+	pendingWrites = map[ChunkCoord][]pendingWrite{}
+	worldStructurePlacers = []StructurePlacer{}
+
+	activeFluids = map[WorldCoord]bool{}
+	fluidTick = 0
+	undoHistory = []*Transaction{}
+	redoHistory = []*Transaction{}
+	pendingEdits = []editRequest{}
+	for pending := true; pending; {
+		select {
+		case <-fluidRequests:
+		case <-editRequests:
+		default:
+			pending = false
 		}
 	}
 
-	if err := setUpRenderer(); err != nil {
-		return err
+	shadowQueue = []ChunkCoord{}
+}
+
+// Sets how far chunks are drawn, in chunks. Call before Start.
+func SetViewDistance(chunks int) error {
+	if chunks < 1 {
+		return fmt.Errorf("chunkmanager: view distance must be at least 1 chunk, got %d", chunks)
 	}
 
+	viewDistance = chunks
 	return nil
 }
 
+// Returns: the view distance in blocks
+func ViewDistance() float64 {
+	return float64(viewDistance * ChunkBase)
+}
+
 func SetDebug(mode bool) {
 	debugMode = mode
 }
 
+// Returns a seed for a chunk derived from the world seed, salt keeps
+// different generation passes from sharing random sequences.
+func chunkSeed(pos ChunkCoord, salt int64) int64 {
+	seed := worldSeed ^ (salt * 0x5851f42d4c957f2d)
+	seed = (seed * 31) + int64(pos.X)*73856093
+	seed = (seed * 31) + int64(pos.Y)*19349663
+	seed = (seed * 31) + int64(pos.Z)*83492791
+	return seed
+}
+
+func floorDiv(a, b int) int {
+	if a < 0 && a%b != 0 {
+		return (a / b) - 1
+	}
+	return a / b
+}
+
+// Splits a world block position into the chunk it belongs to and its
+// position within that chunk.
+func WorldToChunkBlock(x, y, z int) (ChunkCoord, BlockCoord) {
+	chnkPos := ChunkCoord{floorDiv(x, ChunkBase), floorDiv(y, ChunkBase), floorDiv(z, ChunkBase)}
+	blkPos := BlockCoord{x - (chnkPos.X * ChunkBase), y - (chnkPos.Y * ChunkBase), z - (chnkPos.Z * ChunkBase)}
+	return chnkPos, blkPos
+}
+
+func newEmptyChunk(pos ChunkCoord) *Chunk {
+	chunk := &Chunk{}
+
+	chunk.data = map[BlockCoord]*Block{}
+	chunk.position = pos
+	chunk.MouseHit = false
+
+	return chunk
+}
+
+// Returns the block at a world position, nil if there is none.
+func getWorldBlock(x, y, z int) *Block {
+	chnkPos, blkPos := WorldToChunkBlock(x, y, z)
+	if chnk, ok := chunkMap[chnkPos]; ok {
+		if blk, ok := chnk.data[blkPos]; ok {
+			return blk
+		}
+	}
+
+	return nil
+}
+
+func GetWorldBlockType(x, y, z int) (BlockType, bool) {
+	if blk := getWorldBlock(x, y, z); blk != nil {
+		return blk.blockType, true
+	}
+
+	return 0, false
+}
+
+// Places a block at a world position, creating the chunk if needed. Chunks
+// the pipeline hasn't generated yet get it as a pending write, so terrain
+// doesn't replace it.
+// Returns the position of the modified chunk.
+func setWorldBlock(x, y, z int, blockType BlockType) ChunkCoord {
+	chnkPos, blkPos := WorldToChunkBlock(x, y, z)
+	chnk, ok := chunkMap[chnkPos]
+	if !ok {
+		// Nothing to generate, the pipeline only has to bake occlusion.
+		// Structures of neighbours placed later write into it directly.
+		chnk = newEmptyChunk(chnkPos)
+		chnk.Stage = STAGE_LIGHTING
+		chunkMap[chnkPos] = chnk
+		applyPendingWrites(chnk)
+	} else if chnk.Stage < STAGE_CAVES {
+		pendingWrites[chnkPos] = append(pendingWrites[chnkPos], pendingWrite{blkPos, blockType})
+		return chnkPos
+	}
+
+	chnk.data[blkPos] = &Block{
+		visible:   false,
+		position:  blkPos,
+		blockType: blockType,
+	}
+
+	return chnkPos
+}
+
 func GetChunksAroundChunk(chunkPos ChunkCoord) [6]*Chunk {
 	chunks := [6]*Chunk{nil, nil, nil, nil, nil, nil}
 
@@ -123,138 +252,6 @@ func GetChunksAroundChunk(chunkPos ChunkCoord) [6]*Chunk {
 	return chunks
 }
 
-// Port of Golden Section Spiral python code
-// from http://www.softimageblog.com/archives/115
-func goldenSectionSpiralRays(numRays int) []vector.Vector3f {
-	rays := []vector.Vector3f{}
-
-	increment := math.Pi * (3.0 - math.Sqrt(5.0))
-	offset := 2.0 / float64(numRays)
-	for t := 0; t < numRays; t++ {
-		y := (float64(t) * offset) - 1.0 + (offset / 2.0)
-		r := math.Sqrt(1 - (y * y))
-		phi := float64(t) * increment
-
-		rays = append(rays, vector.Vector3f{math.Cos(phi) * r, y, math.Sin(phi) * r})
-	}
-
-	return rays
-}
-
-func occlusion(chnkPos ChunkCoord, blkPos BlockCoord, size int) [6]float64 {
-	occFactor := [6]float64{0.0, 0.0, 0.0, 0.0, 0.0, 0.0}
-	numRays := 16
-	rays := goldenSectionSpiralRays(numRays)
-	for t := 0; t < 6; t++ {
-		for _, ray := range rays {
-			currentStep := vector.Vector3f{}
-			switch t {
-			case FRONT:
-				if ray.Z < 0.0 {
-					continue
-				}
-				currentStep.X = float64((chnkPos.X*ChunkBase)+blkPos.X) + 0.5
-				currentStep.Y = float64((chnkPos.Y*ChunkBase)+blkPos.Y) + 0.5
-				currentStep.Z = float64((chnkPos.Z*ChunkBase)+blkPos.Z) + 1.0
-			case BACK:
-				if ray.Z > 0.0 {
-					continue
-				}
-				currentStep.X = float64((chnkPos.X*ChunkBase)+blkPos.X) + 0.5
-				currentStep.Y = float64((chnkPos.Y*ChunkBase)+blkPos.Y) + 0.5
-				currentStep.Z = float64((chnkPos.Z*ChunkBase)+blkPos.Z) + 0.0
-			case LEFT:
-				if ray.X > 0.0 {
-					continue
-				}
-				currentStep.X = float64((chnkPos.X*ChunkBase)+blkPos.X) + 0.0
-				currentStep.Y = float64((chnkPos.Y*ChunkBase)+blkPos.Y) + 0.5
-				currentStep.Z = float64((chnkPos.Z*ChunkBase)+blkPos.Z) + 0.5
-			case RIGHT:
-				if ray.X < 0.0 {
-					continue
-				}
-				currentStep.X = float64((chnkPos.X*ChunkBase)+blkPos.X) + 1.0
-				currentStep.Y = float64((chnkPos.Y*ChunkBase)+blkPos.Y) + 0.5
-				currentStep.Z = float64((chnkPos.Z*ChunkBase)+blkPos.Z) + 0.5
-			case TOP:
-				if ray.Y < 0.0 {
-					continue
-				}
-				currentStep.X = float64((chnkPos.X*ChunkBase)+blkPos.X) + 0.5
-				currentStep.Y = float64((chnkPos.Y*ChunkBase)+blkPos.Y) + 1.0
-				currentStep.Z = float64((chnkPos.Z*ChunkBase)+blkPos.Z) + 0.5
-			case BOTTOM:
-				if ray.Y > 0.0 {
-					continue
-				}
-				currentStep.X = float64((chnkPos.X*ChunkBase)+blkPos.X) + 0.5
-				currentStep.Y = float64((chnkPos.Y*ChunkBase)+blkPos.Y) + 0.0
-				currentStep.Z = float64((chnkPos.Z*ChunkBase)+blkPos.Z) + 0.5
-			default:
-				continue
-			}
-
-			rayStep := ray.MulScalar(0.2)
-			lastBlock := blkPos
-			currentChnkPos := chnkPos
-			currentChunk, _ := chunkMap[currentChnkPos]
-			for {
-				if currentStep.X < 0.0 || currentStep.X >= float64(ChunkBase*size) || currentStep.Y < 0.0 || currentStep.Y >= float64(ChunkBase*size) || currentStep.Z < 0.0 || currentStep.Z >= float64(ChunkBase*size) {
-					occFactor[t] += 1.0
-					break
-				}
-
-				recalc := false
-				currBlock := BlockCoord{int(currentStep.X) - (currentChnkPos.X * ChunkBase), int(currentStep.Y) - (currentChnkPos.Y * ChunkBase), int(currentStep.Z) - (currentChnkPos.Z * ChunkBase)}
-				if currBlock.X < 0 {
-					currentChnkPos.X -= 1
-					recalc = true
-				}
-				if currBlock.X >= ChunkBase {
-					currentChnkPos.X += 1
-					recalc = true
-				}
-				if currBlock.Y < 0 {
-					currentChnkPos.Y -= 1
-					recalc = true
-				}
-				if currBlock.Y >= ChunkBase {
-					currentChnkPos.Y += 1
-					recalc = true
-				}
-				if currBlock.Z < 0 {
-					currentChnkPos.Z -= 1
-					recalc = true
-				}
-				if currBlock.Z >= ChunkBase {
-					currentChnkPos.Z += 1
-					recalc = true
-				}
-				if recalc {
-					currentChunk, _ = chunkMap[currentChnkPos]
-					currBlock = BlockCoord{int(currentStep.X) - (currentChnkPos.X * ChunkBase), int(currentStep.Y) - (currentChnkPos.Y * ChunkBase), int(currentStep.Z) - (currentChnkPos.Z * ChunkBase)}
-				}
-
-				if currBlock.X != lastBlock.X || currBlock.Y != lastBlock.Y || currBlock.Z != lastBlock.Z {
-					lastBlock = currBlock
-					if _, ok := currentChunk.data[currBlock]; ok {
-						break
-					}
-				}
-
-				currentStep = currentStep.Add(rayStep)
-			}
-		}
-	}
-
-	for t := range occFactor {
-		occFactor[t] = occFactor[t] / float64(numRays/2)
-	}
-
-	return occFactor
-}
-
 // Traces a ray against a box.
 // Returns:
 // bool Intersected?
@@ -338,10 +335,13 @@ func PointInBox(point, boxPos vector.Vector3f, boxSize float64) bool {
 }
 
 // TODO: Optmise chunk lookup by adding checked chunks to map copy and check against to avoid double and triple checking
-// TODO: Rename func
-func ClickedInChunk(mx, my int, cam *camera.Camera) {
-	sWidth := bedrock.ScreenWidth
-	sHeight := bedrock.ScreenHeight
+// Finds the block under the mouse.
+// Returns:
+// WorldCoord Position of the block
+// bool Hit anything?
+func PickBlock(mx, my int, cam *camera.Camera) (WorldCoord, bool) {
+	sWidth := cam.Width
+	sHeight := cam.Height
 	mouseNear, _ := matrix.Unproject(vector.Vector3f{float64(mx), float64(sHeight - my), 0.0}, cam.ViewMatrix, cam.ProjectionMatrix, sWidth, sHeight)
 	mouseFar, _ := matrix.Unproject(vector.Vector3f{float64(mx), float64(sHeight - my), 1.0}, cam.ViewMatrix, cam.ProjectionMatrix, sWidth, sHeight)
 	cam.MousePos = cam.Pos
@@ -392,33 +392,224 @@ func ClickedInChunk(mx, my int, cam *camera.Camera) {
 								float64(pos.Z*ChunkBase + blkPos.Z),
 							}
 							if PointInBox(rayStep, boxPos, 1.0) {
-								fmt.Println("hit!\n")
+								fmt.Println("hit!")
 
-								if !chnk.IsRebuilding {
-									delete(chnk.data, blkPos)
-									rebuildChunks[pos] = chnk
-									recalcOcclusion(chnk, blkPos)
-									rebuildNeighborsCheck(chnk.position, blkPos)
-								}
-
-								return
+								return WorldCoord{pos.X*ChunkBase + blkPos.X, pos.Y*ChunkBase + blkPos.Y, pos.Z*ChunkBase + blkPos.Z}, true
 							} else {
-								fmt.Println("nope...\n")
+								fmt.Println("nope...")
 							}
 						}
 					}
 				}
 			} else {
-				fmt.Println("nope...\n")
+				fmt.Println("nope...")
+			}
+		}
+	}
+
+	return WorldCoord{}, false
+}
+
+// Deletes the block under the mouse.
+func ClickedInChunk(mx, my int, cam *camera.Camera) {
+	pos, ok := PickBlock(mx, my, cam)
+	if !ok {
+		return
+	}
+
+	tx := NewTransaction("delete block")
+	tx.ClearBlock(pos.X, pos.Y, pos.Z)
+	if err := SubmitTransaction(tx); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Rebuilding chunks are read by another goroutine, neither they nor the
+// neighbours whose borders they read, diagonals included, can be touched.
+func chunkBusy(chnkPos ChunkCoord) bool {
+	chnk, ok := chunkMap[chnkPos]
+	if !ok || chnk.Stage < STAGE_OCCLUSION {
+		return false
+	}
+
+	return rebuildingNear(chnkPos, 1)
+}
+
+// Whether a chunk up to reach chunks away, diagonals included, is being
+// rebuilt. Changes writing into chunks further than a chunk from where they
+// happen check the reach of their writes plus one.
+func rebuildingNear(chnkPos ChunkCoord, reach int) bool {
+	for x := -reach; x <= reach; x++ {
+		for y := -reach; y <= reach; y++ {
+			for z := -reach; z <= reach; z++ {
+				if chnk, ok := chunkMap[ChunkCoord{chnkPos.X + x, chnkPos.Y + y, chnkPos.Z + z}]; ok && chnk.IsRebuilding {
+					return true
+				}
 			}
 		}
 	}
+
+	return false
+}
+
+// Chunks away from a change that refreshOcclusion can write into, plus one
+// for the neighbours whose borders their meshes read.
+var occlusionChunkReach = int(math.Ceil(maxOcclusionDistance/float64(ChunkBase))) + 1
+
+// Edits and fluid changes refresh the occlusion of blocks up to
+// maxOcclusionDistance away, none of the chunks that reaches may be rebuilding.
+func blockBusy(pos WorldCoord) bool {
+	chnkPos, _ := WorldToChunkBlock(pos.X, pos.Y, pos.Z)
+	chnk, ok := chunkMap[chnkPos]
+	if !ok || chnk.Stage < STAGE_OCCLUSION {
+		return false
+	}
+
+	return rebuildingNear(chnkPos, occlusionChunkReach)
 }
 
-func recalcOcclusion(chunk *Chunk, blkPos BlockCoord) {
-	for index, block := range chunk.data {
-		if block.visible {
-			block.occlusion = occlusion(chunk.position, index, 4)
+// Only finished chunks are changed after generation.
+func blockEditable(pos WorldCoord) bool {
+	chnkPos, _ := WorldToChunkBlock(pos.X, pos.Y, pos.Z)
+	chnk, ok := chunkMap[chnkPos]
+	return ok && chnk.Stage >= STAGE_OCCLUSION
+}
+
+// Recalculates occlusion for, and rebuilds, the changed chunks and their
+// neighbours.
+func refreshChunks(changed map[ChunkCoord]bool) {
+	dirty := map[ChunkCoord]*Chunk{}
+	for pos := range changed {
+		if chnk, ok := chunkMap[pos]; ok {
+			dirty[pos] = chnk
+		}
+		for _, neighbor := range GetChunksAroundChunk(pos) {
+			if neighbor != nil {
+				dirty[neighbor.position] = neighbor
+			}
+		}
+	}
+
+	chunks := []*Chunk{}
+	for _, chnk := range dirty {
+		chunks = append(chunks, chnk)
+	}
+	bakeShadows(chunks)
+	bakeOcclusion(chunks)
+
+	for pos, chnk := range dirty {
+		if chnk.Stage >= STAGE_OCCLUSION {
+			rebuildChunks[pos] = chnk
+		}
+	}
+}
+
+// Whether any ray leaving face of the block at pos can reach into the box
+// of cells between min and max, inclusive.
+func faceReachesBox(pos WorldCoord, face int, min, max WorldCoord) bool {
+	normal := chunkNormals[face]
+	origin := vector.Vector3f{
+		float64(pos.X) + 0.5 + (0.5 * normal.X),
+		float64(pos.Y) + 0.5 + (0.5 * normal.Y),
+		float64(pos.Z) + 0.5 + (0.5 * normal.Z),
+	}
+	boxMin := vector.Vector3f{float64(min.X), float64(min.Y), float64(min.Z)}
+	boxMax := vector.Vector3f{float64(max.X + 1), float64(max.Y + 1), float64(max.Z + 1)}
+
+	// Rays only leave on the outside of the face
+	if vector.DotProduct(boxMax.Sub(origin), normal) <= 0.0 && vector.DotProduct(boxMin.Sub(origin), normal) <= 0.0 {
+		return false
+	}
+
+	delta := vector.Vector3f{
+		math.Max(math.Max(boxMin.X-origin.X, origin.X-boxMax.X), 0.0),
+		math.Max(math.Max(boxMin.Y-origin.Y, origin.Y-boxMax.Y), 0.0),
+		math.Max(math.Max(boxMin.Z-origin.Z, origin.Z-boxMax.Z), 0.0),
+	}
+	// Rays start a hair outside the face
+	reach := maxOcclusionDistance + 0.001
+	return vector.DotProduct(delta, delta) <= reach*reach
+}
+
+// Recalculates occlusion for the faces whose rays can reach the changed
+// cells, in whatever chunk they are, and rebuilds the chunks involved.
+// Cells are grouped per chunk and tested by the group's bounding box, so
+// big edits stay cheap for the price of recomputing a few faces needlessly.
+func refreshOcclusion(cells []WorldCoord) {
+	type cellBox struct {
+		min, max WorldCoord
+	}
+	boxes := map[ChunkCoord]*cellBox{}
+	for _, cell := range cells {
+		chnkPos, _ := WorldToChunkBlock(cell.X, cell.Y, cell.Z)
+		if box, ok := boxes[chnkPos]; ok {
+			box.min = WorldCoord{imin(box.min.X, cell.X), imin(box.min.Y, cell.Y), imin(box.min.Z, cell.Z)}
+			box.max = WorldCoord{imax(box.max.X, cell.X), imax(box.max.Y, cell.Y), imax(box.max.Z, cell.Z)}
+		} else {
+			boxes[chnkPos] = &cellBox{cell, cell}
+		}
+	}
+
+	reach := int(math.Ceil(maxOcclusionDistance))
+	faces := map[WorldCoord][6]bool{}
+	for _, box := range boxes {
+		for x := box.min.X - reach; x <= box.max.X+reach; x++ {
+			for y := box.min.Y - reach; y <= box.max.Y+reach; y++ {
+				for z := box.min.Z - reach; z <= box.max.Z+reach; z++ {
+					if getWorldBlock(x, y, z) == nil {
+						continue
+					}
+
+					pos := WorldCoord{x, y, z}
+					for face := 0; face < 6; face++ {
+						if faceReachesBox(pos, face, box.min, box.max) {
+							affected := faces[pos]
+							affected[face] = true
+							faces[pos] = affected
+						}
+					}
+				}
+			}
+		}
+	}
+	// Blocks placed by the change need all of their faces
+	for _, cell := range cells {
+		if getWorldBlock(cell.X, cell.Y, cell.Z) != nil {
+			faces[cell] = [6]bool{true, true, true, true, true, true}
+		}
+	}
+
+	for pos, affected := range faces {
+		chnkPos, blkPos := WorldToChunkBlock(pos.X, pos.Y, pos.Z)
+		chnk := chunkMap[chnkPos]
+		blk := chnk.data[blkPos]
+		for face := range affected {
+			if affected[face] {
+				blk.occlusion[face] = faceOcclusion(chnkPos, blkPos, face)
+			}
+		}
+		rebuildChunksSharing(pos)
+	}
+
+	for _, cell := range cells {
+		rebuildChunksSharing(cell)
+	}
+}
+
+// Queues the chunks with faces whose corners take the occlusion and shadow
+// of the block at pos into account, or whether it's there at all: its own
+// and those of the blocks around it.
+func rebuildChunksSharing(pos WorldCoord) {
+	minChnk, _ := WorldToChunkBlock(pos.X-1, pos.Y-1, pos.Z-1)
+	maxChnk, _ := WorldToChunkBlock(pos.X+1, pos.Y+1, pos.Z+1)
+	for x := minChnk.X; x <= maxChnk.X; x++ {
+		for y := minChnk.Y; y <= maxChnk.Y; y++ {
+			for z := minChnk.Z; z <= maxChnk.Z; z++ {
+				chnkPos := ChunkCoord{x, y, z}
+				if chnk, ok := chunkMap[chnkPos]; ok && chnk.Stage >= STAGE_OCCLUSION {
+					rebuildChunks[chnkPos] = chnk
+				}
+			}
 		}
 	}
 }
@@ -472,7 +663,10 @@ func rebuildNeighborsCheck(chnkPos ChunkCoord, blkPos BlockCoord) {
 }
 
 func Update(cam *camera.Camera) {
-	updateSetupList()
+	updatePipeline(STAGE_OCCLUSION)
+	updateEdits()
+	UpdateFluids()
+	updateShadows()
 	updateRebuildList()
 	updateVisibilityList(cam)
 
@@ -484,20 +678,27 @@ func Update(cam *camera.Camera) {
 	}
 }
 
-func updateSetupList() {
-	for pos, chnk := range chunkMap {
-		if chnk.IsLoaded && !chnk.IsSetup {
-			rebuildChunks[pos] = chnk
-			chnk.IsSetup = true
-		}
+// Builds every queued chunk mesh on the calling goroutine and refreshes the
+// render list, for rendering a single frame without the update loop.
+func Flush(cam *camera.Camera) {
+	updatePipeline(STAGE_OCCLUSION)
+	for index, chnk := range rebuildChunks {
+		ch := make(chan RebuildData, 1)
+		chnk.CreateVertexData(ch)
+		chnk.SetChunkMesh(<-ch)
+		delete(rebuildChunks, index)
 	}
+
+	updateVisibilityList(cam)
+	updateRenderList(cam)
+	camPos = cam.Pos
+	camView = cam.Rot
 }
 
 type RebuildData struct {
-	vertexBuffers [6][]float32
-	indexBuffers  [6][]uint32
-	occBuffers    [6][]float32
-	chunk         *Chunk
+	opaque      *renderer.ChunkMeshData
+	translucent *renderer.ChunkMeshData
+	chunk       *Chunk
 }
 
 var rebuildCh = make(chan RebuildData)
@@ -533,7 +734,7 @@ func updateRebuildList() {
 func updateVisibilityList(cam *camera.Camera) {
 	// TODO: Add chunk range limit
 	for t, chnk := range chunkMap {
-		if chnk.IsLoaded && chnk.IsSetup {
+		if chnk.Stage >= STAGE_OCCLUSION {
 			if _, ok := visibleChunks[t]; !ok {
 				fmt.Printf("Added chunk at %v to visible list.\n", t)
 				visibleChunks[t] = chunkMap[t]
@@ -546,10 +747,26 @@ func updateVisibilityList(cam *camera.Camera) {
 	}
 }
 
+// Distance from a point to the closest point of a chunk, 0 inside it
+func chunkDistance(pos ChunkCoord, point vector.Vector3f) float64 {
+	min := vector.Vector3f{float64(pos.X * ChunkBase), float64(pos.Y * ChunkBase), float64(pos.Z * ChunkBase)}
+	size := float64(ChunkBase)
+	offset := vector.Vector3f{
+		math.Max(math.Max(min.X-point.X, point.X-(min.X+size)), 0.0),
+		math.Max(math.Max(min.Y-point.Y, point.Y-(min.Y+size)), 0.0),
+		math.Max(math.Max(min.Z-point.Z, point.Z-(min.Z+size)), 0.0),
+	}
+	return math.Sqrt(vector.DotProduct(offset, offset))
+}
+
 func updateRenderList(cam *camera.Camera) {
 	renderChunks = map[ChunkCoord]*Chunk{}
 
 	for pos := range visibleChunks {
+		if chunkDistance(pos, cam.Pos) > ViewDistance() {
+			continue
+		}
+
 		posx := float64(pos.X * ChunkBase)
 		posy := float64(pos.Y * ChunkBase)
 		posz := float64(pos.Z * ChunkBase)
@@ -2,39 +2,61 @@ package chunkmanager
 
 import (
 	"bedrock/math/matrix"
-	"bedrock/shader"
+	"bedrock/math/vector"
 	"dwelling/camera"
+	"dwelling/renderer"
+	"sort"
 )
 
-var chunkShader *shader.ShaderProgram
-
-func setUpRenderer() error {
-	var err error
-	chunkShader, err = shader.LoadShaderProgram("chunk", []shader.AttribLocation{
-		{
-			Position: 0,
-			Location: "vertexPos",
-		},
-		{
-			Position: 1,
-			Location: "occFactor",
-		},
-	})
-	if err != nil {
-		return err
+// Sorts chunks farthest first from a point
+type byDistance struct {
+	chunks []ChunkCoord
+	from   vector.Vector3f
+}
+
+func (list byDistance) Len() int {
+	return len(list.chunks)
+}
+
+func (list byDistance) Swap(a, b int) {
+	list.chunks[a], list.chunks[b] = list.chunks[b], list.chunks[a]
+}
+
+// Squared, only used for ordering
+func (list byDistance) distance(index int) float64 {
+	half := float64(ChunkBase) / 2.0
+	pos := list.chunks[index]
+	center := vector.Vector3f{
+		X: float64(pos.X*ChunkBase) + half,
+		Y: float64(pos.Y*ChunkBase) + half,
+		Z: float64(pos.Z*ChunkBase) + half,
+	}
+	offset := center.Sub(list.from)
+	return vector.DotProduct(offset, offset)
+}
+
+func (list byDistance) Less(a, b int) bool {
+	distA := list.distance(a)
+	distB := list.distance(b)
+	if distA != distB {
+		return distA > distB
 	}
 
-	return nil
+	// Same order every frame when distances tie
+	posA := list.chunks[a]
+	posB := list.chunks[b]
+	if posA.X != posB.X {
+		return posA.X < posB.X
+	}
+	if posA.Y != posB.Y {
+		return posA.Y < posB.Y
+	}
+	return posA.Z < posB.Z
 }
 
 func Render(cam *camera.Camera) {
-	chunkShader.Use()
-	chunkShader.SetUniformMatrix("pv", cam.PVMatrix)
-	if debugMode {
-		chunkShader.SetUniformInt("onlyOccFac", 1)
-	} else {
-		chunkShader.SetUniformInt("onlyOccFac", 0)
-	}
+	renderer.Current().SetFogDistance(ViewDistance())
+	renderer.Current().BeginChunks(cam.PVMatrix, cam.Pos, debugMode)
 
 	for pos, chnk := range renderChunks {
 		posx := float64(pos.X * ChunkBase)
@@ -43,9 +65,26 @@ func Render(cam *camera.Camera) {
 
 		modelMatrix := matrix.NewIdentityMatrix()
 		modelMatrix.Translate(posx, posy, posz)
-		chunkShader.SetUniformMatrix("model", modelMatrix)
 
-		chnk.RenderChunk(chunkShader, cam.CullPos, modelMatrix, false)
+		chnk.RenderChunk(cam.CullPos, modelMatrix, false)
+	}
+
+	// Back to front so blended faces cover what's behind them
+	translucent := []ChunkCoord{}
+	for pos, chnk := range renderChunks {
+		if !chnk.translucentMesh.isEmpty() {
+			translucent = append(translucent, pos)
+		}
+	}
+	if len(translucent) > 0 {
+		sort.Sort(byDistance{translucent, cam.Pos})
+		renderer.Current().BeginTranslucentChunks()
+		for _, pos := range translucent {
+			modelMatrix := matrix.NewIdentityMatrix()
+			modelMatrix.Translate(float64(pos.X*ChunkBase), float64(pos.Y*ChunkBase), float64(pos.Z*ChunkBase))
+
+			renderChunks[pos].RenderTranslucentChunk(cam.CullPos, modelMatrix)
+		}
 	}
 
 	if debugMode {
@@ -56,9 +95,8 @@ func Render(cam *camera.Camera) {
 
 			modelMatrix := matrix.NewIdentityMatrix()
 			modelMatrix.Translate(posx, posy, posz)
-			chunkShader.SetUniformMatrix("model", modelMatrix)
 
-			chnk.RenderChunk(chunkShader, cam.CullPos, modelMatrix, true)
+			chnk.RenderChunk(cam.CullPos, modelMatrix, true)
 		}
 	}
 }
@@ -0,0 +1,274 @@
+package chunkmanager
+
+import (
+	"fmt"
+)
+
+// World edits go through transactions so they can be undone. A transaction
+// is queued by whichever goroutine makes it and applied in Update, once none
+// of the chunks it touches are being rebuilt. Applying records what each
+// cell held before, undo puts that back and redo reapplies the new states.
+
+// Contents of a cell, Present false is an empty cell
+type BlockState struct {
+	Present bool
+	Type    BlockType
+	flow    int
+}
+
+type BlockEdit struct {
+	Pos WorldCoord
+	Old BlockState
+	New BlockState
+}
+
+type Transaction struct {
+	Name  string
+	Edits []BlockEdit
+
+	// Index into Edits by position, a cell is only edited once
+	positions map[WorldCoord]int
+	// Fills in Edits when the transaction is applied, for edits that depend
+	// on what's in the world
+	expand func(tx *Transaction)
+}
+
+const (
+	EDIT_APPLY int = iota
+	EDIT_UNDO
+	EDIT_REDO
+	// Places blocks without history, creating missing chunks
+	EDIT_STAMP
+)
+
+type editRequest struct {
+	kind int
+	tx   *Transaction
+}
+
+// Undoable transactions kept, the oldest are dropped first
+const maxHistory = 100
+
+var undoHistory = []*Transaction{}
+var redoHistory = []*Transaction{}
+
+var editRequests = make(chan editRequest, 64)
+var pendingEdits = []editRequest{}
+
+func NewTransaction(name string) *Transaction {
+	return &Transaction{
+		Name:      name,
+		Edits:     []BlockEdit{},
+		positions: map[WorldCoord]int{},
+	}
+}
+
+func (tx *Transaction) set(pos WorldCoord, state BlockState) {
+	if index, ok := tx.positions[pos]; ok {
+		tx.Edits[index].New = state
+		return
+	}
+
+	tx.positions[pos] = len(tx.Edits)
+	tx.Edits = append(tx.Edits, BlockEdit{Pos: pos, New: state})
+}
+
+func (tx *Transaction) SetBlock(x, y, z int, blockType BlockType) {
+	tx.set(WorldCoord{x, y, z}, BlockState{Present: true, Type: blockType})
+}
+
+func (tx *Transaction) ClearBlock(x, y, z int) {
+	tx.set(WorldCoord{x, y, z}, BlockState{})
+}
+
+func queueEditRequest(request editRequest) error {
+	select {
+	case editRequests <- request:
+		return nil
+	default:
+		return fmt.Errorf("edits: too many edits queued")
+	}
+}
+
+// Queues a transaction, safe to call from any goroutine. Edits outside
+// finished chunks are dropped when it's applied.
+func SubmitTransaction(tx *Transaction) error {
+	return queueEditRequest(editRequest{EDIT_APPLY, tx})
+}
+
+// Queues undoing the last applied transaction.
+func Undo() error {
+	return queueEditRequest(editRequest{EDIT_UNDO, nil})
+}
+
+// Queues reapplying the last undone transaction.
+func Redo() error {
+	return queueEditRequest(editRequest{EDIT_REDO, nil})
+}
+
+func getBlockState(pos WorldCoord) BlockState {
+	if blk := getWorldBlock(pos.X, pos.Y, pos.Z); blk != nil {
+		return BlockState{Present: true, Type: blk.blockType, flow: blk.flow}
+	}
+
+	return BlockState{}
+}
+
+func setBlockState(pos WorldCoord, state BlockState) {
+	chnkPos, blkPos := WorldToChunkBlock(pos.X, pos.Y, pos.Z)
+	chnk := chunkMap[chnkPos]
+
+	if state.Present {
+		chnk.data[blkPos] = &Block{
+			visible:   false,
+			position:  blkPos,
+			blockType: state.Type,
+			flow:      state.flow,
+		}
+	} else {
+		delete(chnk.data, blkPos)
+	}
+
+	// Fluids around the cell may have somewhere new to go
+	activeFluids[pos] = true
+	for _, offset := range neighborOffsets {
+		activeFluids[pos.add(offset)] = true
+	}
+}
+
+func transactionBusy(tx *Transaction) bool {
+	for _, edit := range tx.Edits {
+		if blockBusy(edit.Pos) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Sets every cell to the new or, undoing, the old state, then refreshes the
+// occlusion and shadows around them and rebuilds each affected chunk once.
+func runTransaction(tx *Transaction, undo bool) {
+	cells := []WorldCoord{}
+	for t := range tx.Edits {
+		if undo {
+			edit := tx.Edits[len(tx.Edits)-1-t]
+			setBlockState(edit.Pos, edit.Old)
+		} else {
+			edit := tx.Edits[t]
+			setBlockState(edit.Pos, edit.New)
+		}
+		cells = append(cells, tx.Edits[t].Pos)
+	}
+
+	refreshOcclusion(cells)
+	refreshShadows(cells)
+}
+
+// Fills in the old states and drops edits that can't or don't change
+// anything, so undo restores exactly what was there.
+func prepareTransaction(tx *Transaction) {
+	edits := []BlockEdit{}
+	for _, edit := range tx.Edits {
+		if !blockEditable(edit.Pos) {
+			continue
+		}
+		edit.Old = getBlockState(edit.Pos)
+		if edit.Old == edit.New {
+			continue
+		}
+		edits = append(edits, edit)
+	}
+
+	tx.Edits = edits
+	tx.positions = nil
+	tx.expand = nil
+}
+
+// Fills in the edits of a transaction that depend on the world, again on
+// every try since the world may change while it waits.
+func expandTransaction(tx *Transaction) {
+	if tx.expand != nil {
+		tx.Edits = []BlockEdit{}
+		tx.positions = map[WorldCoord]int{}
+		tx.expand(tx)
+	}
+}
+
+// Applies a queued request.
+// Returns false if its chunks are busy and it has to wait
+func runEditRequest(request editRequest) bool {
+	switch request.kind {
+	case EDIT_APPLY:
+		expandTransaction(request.tx)
+		if transactionBusy(request.tx) {
+			return false
+		}
+		prepareTransaction(request.tx)
+		if len(request.tx.Edits) == 0 {
+			return true
+		}
+
+		runTransaction(request.tx, false)
+		undoHistory = append(undoHistory, request.tx)
+		if len(undoHistory) > maxHistory {
+			undoHistory = undoHistory[1:]
+		}
+		redoHistory = []*Transaction{}
+
+	case EDIT_UNDO:
+		if len(undoHistory) == 0 {
+			fmt.Println("edits: Nothing to undo")
+			return true
+		}
+		tx := undoHistory[len(undoHistory)-1]
+		if transactionBusy(tx) {
+			return false
+		}
+
+		runTransaction(tx, true)
+		undoHistory = undoHistory[:len(undoHistory)-1]
+		redoHistory = append(redoHistory, tx)
+		fmt.Printf("edits: Undid %q\n", tx.Name)
+
+	case EDIT_REDO:
+		if len(redoHistory) == 0 {
+			fmt.Println("edits: Nothing to redo")
+			return true
+		}
+		tx := redoHistory[len(redoHistory)-1]
+		if transactionBusy(tx) {
+			return false
+		}
+
+		runTransaction(tx, false)
+		redoHistory = redoHistory[:len(redoHistory)-1]
+		undoHistory = append(undoHistory, tx)
+		fmt.Printf("edits: Redid %q\n", tx.Name)
+
+	case EDIT_STAMP:
+		expandTransaction(request.tx)
+		if stampBusy(request.tx) {
+			return false
+		}
+		runStamp(request.tx)
+	}
+
+	return true
+}
+
+// Runs queued edits in order, stopping at the first one that has to wait.
+func updateEdits() {
+	for pending := true; pending; {
+		select {
+		case request := <-editRequests:
+			pendingEdits = append(pendingEdits, request)
+		default:
+			pending = false
+		}
+	}
+
+	for len(pendingEdits) > 0 && runEditRequest(pendingEdits[0]) {
+		pendingEdits = pendingEdits[1:]
+	}
+}
@@ -0,0 +1,122 @@
+package chunkmanager
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Returns: a description of the first cell the two worlds differ in, empty
+// when they're the same
+func diffWorlds(got, want map[WorldCoord]BlockType) string {
+	for pos, blockType := range want {
+		if gotType, ok := got[pos]; !ok {
+			return fmt.Sprintf("%v is empty, want %v", pos, blockType)
+		} else if gotType != blockType {
+			return fmt.Sprintf("%v is %v, want %v", pos, gotType, blockType)
+		}
+	}
+	for pos, blockType := range got {
+		if _, ok := want[pos]; !ok {
+			return fmt.Sprintf("%v is %v, want it empty", pos, blockType)
+		}
+	}
+
+	return ""
+}
+
+// Returns: the world blocks after tx is applied to them
+func applyToBlocks(blocks map[WorldCoord]BlockType, tx *Transaction) map[WorldCoord]BlockType {
+	result := map[WorldCoord]BlockType{}
+	for pos, blockType := range blocks {
+		result[pos] = blockType
+	}
+	for _, edit := range tx.Edits {
+		if edit.New.Present {
+			result[edit.Pos] = edit.New.Type
+		} else {
+			delete(result, edit.Pos)
+		}
+	}
+
+	return result
+}
+
+func runEdit(t *testing.T, queue func() error) {
+	t.Helper()
+	if err := queue(); err != nil {
+		t.Fatal(err)
+	}
+	updateEdits()
+	if len(pendingEdits) > 0 {
+		t.Fatalf("%d edits still waiting", len(pendingEdits))
+	}
+}
+
+// Applies two transactions, one across the corner all eight chunks share
+// and one clearing blocks on both sides of a border, then undoes and redoes
+// them, checking the whole world after every step.
+func TestUndoRedoRoundTrip(t *testing.T) {
+	buildSeamWorld()
+	defer resetWorld()
+
+	original := worldBlocks()
+	fill := BoxBrush(WorldCoord{14, 14, 14}, WorldCoord{17, 17, 17}, SolidBlock(BLOCK_DIRT))
+	clear := BoxBrush(WorldCoord{12, 4, 15}, WorldCoord{19, 5, 16}, BlockState{})
+	afterFill := applyToBlocks(original, fill)
+	afterClear := applyToBlocks(afterFill, clear)
+
+	steps := []struct {
+		name  string
+		queue func() error
+		want  map[WorldCoord]BlockType
+	}{
+		{"fill", func() error { return SubmitTransaction(fill) }, afterFill},
+		{"clear", func() error { return SubmitTransaction(clear) }, afterClear},
+		{"undo clear", Undo, afterFill},
+		{"undo fill", Undo, original},
+		{"undo with nothing left", Undo, original},
+		{"redo fill", Redo, afterFill},
+		{"redo clear", Redo, afterClear},
+		{"redo with nothing left", Redo, afterClear},
+		{"undo clear again", Undo, afterFill},
+	}
+	for _, step := range steps {
+		runEdit(t, step.queue)
+		if diff := diffWorlds(worldBlocks(), step.want); diff != "" {
+			t.Fatalf("%s: %s", step.name, diff)
+		}
+	}
+
+	// A new transaction drops what could be redone
+	sand := BoxBrush(WorldCoord{0, 0, 0}, WorldCoord{0, 0, 0}, SolidBlock(BLOCK_SAND))
+	runEdit(t, func() error { return SubmitTransaction(sand) })
+	if len(redoHistory) != 0 {
+		t.Errorf("%d transactions left to redo after a new one", len(redoHistory))
+	}
+	runEdit(t, Undo)
+	runEdit(t, Undo)
+	if diff := diffWorlds(worldBlocks(), original); diff != "" {
+		t.Errorf("undoing everything: %s", diff)
+	}
+}
+
+// Cells outside finished chunks and ones that wouldn't change are left out,
+// so undo has nothing to restore there.
+func TestTransactionDropsNoOpEdits(t *testing.T) {
+	buildSeamWorld()
+	defer resetWorld()
+
+	tx := NewTransaction("test")
+	tx.SetBlock(15, 4, 15, BLOCK_STONE)
+	tx.SetBlock(15, 3, 15, BLOCK_STONE)
+	tx.SetBlock(40, 4, 15, BLOCK_STONE)
+	tx.ClearBlock(15, 2, 15)
+	runEdit(t, func() error { return SubmitTransaction(tx) })
+
+	if len(tx.Edits) != 1 || tx.Edits[0].Pos != (WorldCoord{15, 3, 15}) {
+		t.Errorf("edits %v, want only the one placing a block at 15, 3, 15", tx.Edits)
+	}
+	if tx.Edits[0].Old != (BlockState{}) {
+		t.Errorf("old state %v, want the empty cell", tx.Edits[0].Old)
+	}
+}
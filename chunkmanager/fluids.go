@@ -0,0 +1,296 @@
+package chunkmanager
+
+import (
+	"fmt"
+)
+
+// Fluids are a cellular automaton: every fluid tick each active cell works
+// out what it should hold from its neighbours, then all changes are applied
+// at once. Fluid falls into empty cells below it and, once it rests on
+// something, spreads sideways with its flow growing by the fluid's Decay
+// per block until it passes FLUID_MAX_FLOW. Flowing cells cut off from
+// their source drain away. Only cells next to a change are active, a still
+// lake costs nothing.
+
+const FLUID_MAX_FLOW int = 7
+
+// Flow of a cell with the same fluid above it, it falls as if fed by a
+// source directly
+const FLUID_FALLING_FLOW int = 1
+
+// Cells looked at per tick, the rest wait for the next one
+const maxFluidUpdates = 2048
+
+var activeFluids = map[WorldCoord]bool{}
+var fluidTick = 0
+
+type fluidChange struct {
+	pos       WorldCoord
+	blockType BlockType
+	flow      int
+	// Empties the cell, blockType and flow are ignored
+	remove bool
+}
+
+var fluidRequests = make(chan fluidChange, 16)
+
+var horizontalOffsets = [4]WorldCoord{
+	{0, 0, 1},
+	{0, 0, -1},
+	{-1, 0, 0},
+	{1, 0, 0},
+}
+
+var neighborOffsets = [6]WorldCoord{
+	{0, 0, 1},
+	{0, 0, -1},
+	{-1, 0, 0},
+	{1, 0, 0},
+	{0, 1, 0},
+	{0, -1, 0},
+}
+
+func (pos WorldCoord) add(offset WorldCoord) WorldCoord {
+	return WorldCoord{pos.X + offset.X, pos.Y + offset.Y, pos.Z + offset.Z}
+}
+
+func isFluid(blk *Block) bool {
+	return blk != nil && GetBlockTypeInfo(blk.blockType).Fluid != nil
+}
+
+// Places a source block of a fluid type at a world position. Safe to call
+// from the logic goroutine, it's applied on the next UpdateFluids.
+func PlaceFluid(x, y, z int, blockType BlockType) error {
+	if GetBlockTypeInfo(blockType).Fluid == nil {
+		return fmt.Errorf("fluids: %q isn't a fluid", GetBlockTypeInfo(blockType).Name)
+	}
+
+	select {
+	case fluidRequests <- fluidChange{pos: WorldCoord{x, y, z}, blockType: blockType}:
+		return nil
+	default:
+		return fmt.Errorf("fluids: too many placements queued")
+	}
+}
+
+// A cell spreads sideways when it can't fall, resting on a solid block, on
+// a different fluid or on still fluid of its own type.
+func fluidSpreadsSideways(pos WorldCoord, blk *Block) bool {
+	below := getWorldBlock(pos.X, pos.Y-1, pos.Z)
+	if below == nil {
+		// Fluids stay inside finished chunks instead of pouring out of the
+		// world
+		return !blockEditable(pos.add(WorldCoord{0, -1, 0}))
+	}
+	return below.blockType != blk.blockType || below.flow == 0
+}
+
+// Returns: flow the cell at pos gets from its neighbours for a fluid type,
+// false if none of them feed it
+func fluidFlowInto(pos WorldCoord, blockType BlockType) (int, bool) {
+	if above := getWorldBlock(pos.X, pos.Y+1, pos.Z); above != nil && above.blockType == blockType {
+		return FLUID_FALLING_FLOW, true
+	}
+
+	decay := GetBlockTypeInfo(blockType).Fluid.Decay
+	best := -1
+	for _, offset := range horizontalOffsets {
+		neighborPos := pos.add(offset)
+		neighbor := getWorldBlock(neighborPos.X, neighborPos.Y, neighborPos.Z)
+		if neighbor == nil || neighbor.blockType != blockType || !fluidSpreadsSideways(neighborPos, neighbor) {
+			continue
+		}
+		if flow := neighbor.flow + decay; flow <= FLUID_MAX_FLOW && (best < 0 || flow < best) {
+			best = flow
+		}
+	}
+
+	return best, best >= 0
+}
+
+func fluidDue(blockType BlockType) bool {
+	return fluidTick%GetBlockTypeInfo(blockType).Fluid.TickRate == 0
+}
+
+// Returns: the change for an active cell, false if it stays as it is.
+// waiting is set when the cell has to be looked at again on a later tick.
+func updateFluidCell(pos WorldCoord) (change fluidChange, changed bool, waiting bool) {
+	blk := getWorldBlock(pos.X, pos.Y, pos.Z)
+
+	if blk == nil {
+		if !blockEditable(pos) {
+			return fluidChange{}, false, false
+		}
+
+		// Lowest flow wins, ties go to the lower type so the result doesn't
+		// depend on map order
+		for _, offset := range [5]WorldCoord{{0, 1, 0}, {0, 0, 1}, {0, 0, -1}, {-1, 0, 0}, {1, 0, 0}} {
+			neighborPos := pos.add(offset)
+			neighbor := getWorldBlock(neighborPos.X, neighborPos.Y, neighborPos.Z)
+			if !isFluid(neighbor) {
+				continue
+			}
+			if !fluidDue(neighbor.blockType) {
+				waiting = true
+				continue
+			}
+
+			flow, ok := fluidFlowInto(pos, neighbor.blockType)
+			if ok && (!changed || flow < change.flow || (flow == change.flow && neighbor.blockType < change.blockType)) {
+				change = fluidChange{pos: pos, blockType: neighbor.blockType, flow: flow}
+				changed = true
+			}
+		}
+		return change, changed, waiting && !changed
+	}
+
+	if !isFluid(blk) {
+		return fluidChange{}, false, false
+	}
+
+	// Lava cooled by water
+	if blk.blockType == BLOCK_LAVA {
+		for _, offset := range neighborOffsets {
+			neighborPos := pos.add(offset)
+			if neighbor := getWorldBlock(neighborPos.X, neighborPos.Y, neighborPos.Z); neighbor != nil && neighbor.blockType == BLOCK_WATER {
+				return fluidChange{pos: pos, blockType: BLOCK_STONE}, true, false
+			}
+		}
+	}
+
+	if blk.flow == 0 {
+		return fluidChange{}, false, false
+	}
+	if !fluidDue(blk.blockType) {
+		return fluidChange{}, false, true
+	}
+
+	flow, ok := fluidFlowInto(pos, blk.blockType)
+	if !ok {
+		return fluidChange{pos: pos, remove: true}, true, false
+	}
+	if flow != blk.flow {
+		return fluidChange{pos: pos, blockType: blk.blockType, flow: flow}, true, false
+	}
+
+	return fluidChange{}, false, false
+}
+
+// Returns: whether the block type at the cell changed, fluid appearing or
+// draining away occludes and shadows the blocks around it like any other
+// edit so those cells need refreshOcclusion and refreshShadows
+func applyFluidChange(change fluidChange) bool {
+	chnkPos, blkPos := WorldToChunkBlock(change.pos.X, change.pos.Y, change.pos.Z)
+	chnk, ok := chunkMap[chnkPos]
+	if !ok {
+		return false
+	}
+
+	typeChanged := true
+	if change.remove {
+		delete(chnk.data, blkPos)
+	} else {
+		blk := &Block{
+			visible:   false,
+			position:  blkPos,
+			blockType: change.blockType,
+			flow:      change.flow,
+		}
+		// Only the flow changed, the surroundings didn't
+		if old, ok := chnk.data[blkPos]; ok && old.blockType == change.blockType {
+			blk.occlusion = old.occlusion
+			blk.shadow = old.shadow
+			typeChanged = false
+		}
+		chnk.data[blkPos] = blk
+	}
+
+	rebuildChunks[chnkPos] = chnk
+	rebuildNeighborsCheck(chnkPos, blkPos)
+
+	activeFluids[change.pos] = true
+	for _, offset := range neighborOffsets {
+		activeFluids[change.pos.add(offset)] = true
+	}
+
+	return typeChanged
+}
+
+// Runs one fluid tick, called by Update on every logic tick. Occlusion and
+// shadows are refreshed once for every cell the tick changed.
+func UpdateFluids() {
+	refresh := placeRequestedFluids()
+	fluidTick++
+	refresh = append(refresh, flowFluids()...)
+
+	if len(refresh) > 0 {
+		refreshOcclusion(refresh)
+		refreshShadows(refresh)
+	}
+}
+
+// Returns: the cells whose block type changed
+func placeRequestedFluids() []WorldCoord {
+	changed := []WorldCoord{}
+	for {
+		select {
+		case request := <-fluidRequests:
+			if !blockEditable(request.pos) || blockBusy(request.pos) {
+				fmt.Printf("fluids: can't place at %v\n", request.pos)
+			} else if blk := getWorldBlock(request.pos.X, request.pos.Y, request.pos.Z); blk != nil && !isFluid(blk) {
+				fmt.Printf("fluids: %v is taken\n", request.pos)
+			} else if applyFluidChange(request) {
+				changed = append(changed, request.pos)
+			}
+		default:
+			return changed
+		}
+	}
+}
+
+// Returns: the cells whose block type changed
+func flowFluids() []WorldCoord {
+	changed := []WorldCoord{}
+	if len(activeFluids) == 0 {
+		return changed
+	}
+
+	active := activeFluids
+	activeFluids = map[WorldCoord]bool{}
+
+	changes := []fluidChange{}
+	numUpdated := 0
+	for pos := range active {
+		if numUpdated >= maxFluidUpdates || blockBusy(pos) {
+			activeFluids[pos] = true
+			continue
+		}
+		numUpdated++
+
+		change, cellChanged, waiting := updateFluidCell(pos)
+		if cellChanged {
+			changes = append(changes, change)
+		} else if waiting {
+			activeFluids[pos] = true
+		}
+	}
+
+	for _, change := range changes {
+		if applyFluidChange(change) {
+			changed = append(changed, change.pos)
+		}
+	}
+
+	return changed
+}
+
+// Returns: top of a fluid block relative to its bottom, full when the same
+// fluid is above it
+func fluidHeight(neighbors *chunkNeighborhood, pos BlockCoord, blk *Block) float64 {
+	above := neighbors.block(pos.X, pos.Y+1, pos.Z)
+	if above != nil && above.blockType == blk.blockType {
+		return 1.0
+	}
+
+	return float64(FLUID_MAX_FLOW+1-blk.flow) / float64(FLUID_MAX_FLOW+2)
+}
@@ -0,0 +1,331 @@
+package chunkmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Generators can be described in JSON files so terrain can be tuned
+// without recompiling. See resources/generators for examples.
+
+type generatorConfig struct {
+	Name         string              `json:"name"`
+	Type         string              `json:"type"`
+	Terrain      *terrainConfig      `json:"terrain"`
+	Simplex      *simplexConfig      `json:"simplex"`
+	FloatingRock *floatingRockConfig `json:"floatingrock"`
+	Caves        *CaveCarver         `json:"caves"`
+	Ores         *oresConfig         `json:"ores"`
+	Structures   []string            `json:"structures"`
+}
+
+type terrainConfig struct {
+	BaseHeight       float64        `json:"baseHeight"`
+	Layers           []TerrainLayer `json:"layers"`
+	TemperatureScale float64        `json:"temperatureScale"`
+	HumidityScale    float64        `json:"humidityScale"`
+	BiomeBlend       float64        `json:"biomeBlend"`
+	Underground      string         `json:"underground"`
+	Biomes           []struct {
+		Name            string  `json:"name"`
+		Temperature     float64 `json:"temperature"`
+		Humidity        float64 `json:"humidity"`
+		Surface         string  `json:"surface"`
+		Subsurface      string  `json:"subsurface"`
+		SubsurfaceDepth int     `json:"subsurfaceDepth"`
+		HeightScale     float64 `json:"heightScale"`
+	} `json:"biomes"`
+}
+
+type simplexConfig struct {
+	Scale     float64 `json:"scale"`
+	Threshold float64 `json:"threshold"`
+	Block     string  `json:"block"`
+}
+
+type floatingRockConfig struct {
+	FloatingRockParams
+	Block string `json:"block"`
+}
+
+type oresConfig struct {
+	Host      string `json:"host"`
+	Resources []struct {
+		Block         string  `json:"block"`
+		MinY          int     `json:"minY"`
+		MaxY          int     `json:"maxY"`
+		VeinsPerChunk float64 `json:"veinsPerChunk"`
+		ClusterSize   int     `json:"clusterSize"`
+	} `json:"resources"`
+}
+
+var structurePlacersByName = map[string]StructurePlacer{
+	"trees": treePlacer,
+}
+
+// Collects every problem in a config so they can be fixed in one go.
+type configErrors struct {
+	filename string
+	errs     []string
+}
+
+func (e *configErrors) add(path, format string, args ...interface{}) {
+	e.errs = append(e.errs, fmt.Sprintf("%s: %s: %s", e.filename, path, fmt.Sprintf(format, args...)))
+}
+
+func (e *configErrors) positive(path string, value float64) {
+	if value <= 0.0 {
+		e.add(path, "must be greater than 0, got %v", value)
+	}
+}
+
+func (e *configErrors) blockType(path, name string) BlockType {
+	if name == "" {
+		e.add(path, "missing block type")
+		return BLOCK_DEFAULT
+	}
+	blockType, ok := BlockTypeByName(name)
+	if !ok {
+		e.add(path, "unknown block type %q", name)
+	}
+	return blockType
+}
+
+func (e *configErrors) err() error {
+	if len(e.errs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(e.errs, "\n"))
+}
+
+// Turns the byte offset the json errors give, just after the byte they
+// failed at, into the line and column of that byte for error messages.
+func offsetToLineCol(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset > 0 {
+		offset--
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte{'\n'}) + 1
+	col := int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+// Loads a generator description and registers it under its name, it can
+// then be picked with SetGenerator.
+func LoadGeneratorFile(filename string) (*WorldGenerator, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	config := generatorConfig{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		switch err := err.(type) {
+		case *json.SyntaxError:
+			line, col := offsetToLineCol(data, err.Offset)
+			return nil, fmt.Errorf("%s:%d:%d: %v", filename, line, col, err)
+		case *json.UnmarshalTypeError:
+			line, col := offsetToLineCol(data, err.Offset)
+			return nil, fmt.Errorf("%s:%d:%d: %s: expected %v, got %s", filename, line, col, err.Field, err.Type, err.Value)
+		default:
+			// Unknown fields come without an offset
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+
+	gen, err := config.generator(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	worldGenerators[gen.Name] = gen
+	return gen, nil
+}
+
+func (config *generatorConfig) generator(filename string) (*WorldGenerator, error) {
+	errs := &configErrors{filename: filename}
+
+	gen := &WorldGenerator{
+		Name: config.Name,
+	}
+	if config.Name == "" {
+		errs.add("name", "missing")
+	} else if _, ok := worldGenerators[config.Name]; ok {
+		errs.add("name", "a generator named %q already exists", config.Name)
+	}
+
+	switch config.Type {
+	case "terrain":
+		if config.Terrain == nil {
+			errs.add("terrain", "required by type %q", config.Type)
+			break
+		}
+		terrain := config.Terrain.terrainGenerator(errs)
+		gen.Terrain = terrain.NewChunk
+		gen.Decoration = terrain.Decorate
+	case "simplex":
+		if config.Simplex == nil {
+			errs.add("simplex", "required by type %q", config.Type)
+			break
+		}
+		params := &SimplexParams{
+			Scale:     config.Simplex.Scale,
+			Threshold: config.Simplex.Threshold,
+			Block:     errs.blockType("simplex.block", config.Simplex.Block),
+		}
+		errs.positive("simplex.scale", params.Scale)
+		gen.Terrain = func(pos ChunkCoord) *Chunk {
+			return newSimplexChunk(pos, worldSize, params)
+		}
+	case "floatingrock":
+		if config.FloatingRock == nil {
+			errs.add("floatingrock", "required by type %q", config.Type)
+			break
+		}
+		params := config.FloatingRock.FloatingRockParams
+		params.Block = errs.blockType("floatingrock.block", config.FloatingRock.Block)
+		if params.PlateauEnd <= params.PlateauStart {
+			errs.add("floatingrock.plateauEnd", "must be above plateauStart (%v), got %v", params.PlateauStart, params.PlateauEnd)
+		}
+		errs.positive("floatingrock.caveScale", params.CaveScale)
+		errs.positive("floatingrock.detailScale", params.DetailScale)
+		if params.DensityOctaves < 1 {
+			errs.add("floatingrock.densityOctaves", "must be at least 1, got %d", params.DensityOctaves)
+		}
+		gen.Terrain = func(pos ChunkCoord) *Chunk {
+			return newFloatingRockChunk(pos, worldSize, &params)
+		}
+	case "":
+		errs.add("type", "missing, expected one of terrain, simplex or floatingrock")
+	default:
+		errs.add("type", "unknown type %q, expected one of terrain, simplex or floatingrock", config.Type)
+	}
+
+	if config.Caves != nil {
+		caves := *config.Caves
+		if caves.RegionSize < 1 {
+			errs.add("caves.regionSize", "must be at least 1, got %d", caves.RegionSize)
+		}
+		if caves.WormsPerRegion < 0 {
+			errs.add("caves.wormsPerRegion", "can't be negative, got %d", caves.WormsPerRegion)
+		}
+		if caves.WormLength < 1 {
+			errs.add("caves.wormLength", "must be at least 1, got %d", caves.WormLength)
+		}
+		errs.positive("caves.wormStep", caves.WormStep)
+		errs.positive("caves.wormTurnScale", caves.WormTurnScale)
+		errs.positive("caves.minRadius", caves.MinRadius)
+		if caves.MaxRadius < caves.MinRadius {
+			errs.add("caves.maxRadius", "must be at least minRadius (%v), got %v", caves.MinRadius, caves.MaxRadius)
+		}
+		errs.positive("caves.cavernScale", caves.CavernScale)
+		gen.Caves = &caves
+	}
+
+	if config.Ores != nil {
+		ores := &OreDistribution{
+			Host: errs.blockType("ores.host", config.Ores.Host),
+		}
+		for t, resource := range config.Ores.Resources {
+			path := fmt.Sprintf("ores.resources[%d]", t)
+			if resource.MaxY < resource.MinY {
+				errs.add(path+".maxY", "must be at least minY (%d), got %d", resource.MinY, resource.MaxY)
+			}
+			if resource.VeinsPerChunk < 0.0 {
+				errs.add(path+".veinsPerChunk", "can't be negative, got %v", resource.VeinsPerChunk)
+			}
+			if resource.ClusterSize < 1 {
+				errs.add(path+".clusterSize", "must be at least 1, got %d", resource.ClusterSize)
+			}
+			ores.Resources = append(ores.Resources, OreResource{
+				BlockType:     errs.blockType(path+".block", resource.Block),
+				MinY:          resource.MinY,
+				MaxY:          resource.MaxY,
+				VeinsPerChunk: resource.VeinsPerChunk,
+				ClusterSize:   resource.ClusterSize,
+			})
+		}
+		gen.Ores = ores
+	}
+
+	for t, name := range config.Structures {
+		placer, ok := structurePlacersByName[name]
+		if !ok {
+			errs.add(fmt.Sprintf("structures[%d]", t), "unknown structure placer %q", name)
+			continue
+		}
+		gen.Structures = append(gen.Structures, placer)
+	}
+
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+
+	return gen, nil
+}
+
+func (config *terrainConfig) terrainGenerator(errs *configErrors) *TerrainGenerator {
+	terrain := &TerrainGenerator{
+		BaseHeight:       config.BaseHeight,
+		Layers:           config.Layers,
+		TemperatureScale: config.TemperatureScale,
+		HumidityScale:    config.HumidityScale,
+		BiomeBlend:       config.BiomeBlend,
+		Underground:      errs.blockType("terrain.underground", config.Underground),
+	}
+
+	if len(config.Layers) == 0 {
+		errs.add("terrain.layers", "needs at least one layer")
+	}
+	for t, layer := range config.Layers {
+		path := fmt.Sprintf("terrain.layers[%d]", t)
+		if layer.Octaves < 1 {
+			errs.add(path+".octaves", "must be at least 1, got %d", layer.Octaves)
+		}
+		errs.positive(path+".scale", layer.Scale)
+	}
+	errs.positive("terrain.temperatureScale", config.TemperatureScale)
+	errs.positive("terrain.humidityScale", config.HumidityScale)
+	if config.BiomeBlend < 0.0 {
+		errs.add("terrain.biomeBlend", "can't be negative, got %v", config.BiomeBlend)
+	}
+
+	if len(config.Biomes) == 0 {
+		errs.add("terrain.biomes", "needs at least one biome")
+	}
+	for t, biome := range config.Biomes {
+		path := fmt.Sprintf("terrain.biomes[%d]", t)
+		if biome.Name == "" {
+			errs.add(path+".name", "missing")
+		}
+		if biome.Temperature < -1.0 || biome.Temperature > 1.0 {
+			errs.add(path+".temperature", "must be within -1..1, got %v", biome.Temperature)
+		}
+		if biome.Humidity < -1.0 || biome.Humidity > 1.0 {
+			errs.add(path+".humidity", "must be within -1..1, got %v", biome.Humidity)
+		}
+		if biome.SubsurfaceDepth < 0 {
+			errs.add(path+".subsurfaceDepth", "can't be negative, got %d", biome.SubsurfaceDepth)
+		}
+		terrain.Biomes = append(terrain.Biomes, Biome{
+			Name:            biome.Name,
+			Temperature:     biome.Temperature,
+			Humidity:        biome.Humidity,
+			Surface:         errs.blockType(path+".surface", biome.Surface),
+			Subsurface:      errs.blockType(path+".subsurface", biome.Subsurface),
+			SubsurfaceDepth: biome.SubsurfaceDepth,
+			HeightScale:     biome.HeightScale,
+		})
+	}
+
+	return terrain
+}
@@ -0,0 +1,277 @@
+package chunkmanager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A terrain generator using every optional pass, valid as it is
+const testGeneratorConfig = `{
+	"name": "test",
+	"type": "terrain",
+	"terrain": {
+		"baseHeight": 30,
+		"layers": [{"octaves": 4, "scale": 80, "amplitude": 18}],
+		"temperatureScale": 256,
+		"humidityScale": 192,
+		"biomeBlend": 0.15,
+		"underground": "stone",
+		"biomes": [
+			{"name": "plains", "temperature": 0.2, "humidity": 0.0, "surface": "grass", "subsurface": "dirt", "subsurfaceDepth": 3, "heightScale": 0.6}
+		]
+	},
+	"caves": {
+		"regionSize": 4,
+		"wormsPerRegion": 3,
+		"wormLength": 80,
+		"wormStep": 0.75,
+		"wormTurnScale": 0.08,
+		"wormTurnStrength": 0.35,
+		"minRadius": 1.2,
+		"maxRadius": 2.8,
+		"cavernScale": 32,
+		"cavernThreshold": 0.55,
+		"maxY": 40
+	},
+	"ores": {
+		"host": "stone",
+		"resources": [
+			{"block": "coal_ore", "minY": 0, "maxY": 48, "veinsPerChunk": 2.5, "clusterSize": 10}
+		]
+	},
+	"structures": ["trees"]
+}`
+
+func testConfig(t *testing.T) *generatorConfig {
+	config := &generatorConfig{}
+	if err := json.Unmarshal([]byte(testGeneratorConfig), config); err != nil {
+		t.Fatal(err)
+	}
+
+	return config
+}
+
+func TestGeneratorConfigValid(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(config *generatorConfig)
+	}{
+		{"as is", func(config *generatorConfig) {}},
+		{"caves at the bottom of the world", func(config *generatorConfig) { config.Caves.MaxY = 0 }},
+		{"caves below the bottom of the world", func(config *generatorConfig) { config.Caves.MaxY = -16 }},
+		{"ores at one height", func(config *generatorConfig) { config.Ores.Resources[0].MaxY = 0 }},
+		{"no ores", func(config *generatorConfig) { config.Ores.Resources[0].VeinsPerChunk = 0.0 }},
+	}
+
+	for _, test := range tests {
+		config := testConfig(t)
+		test.modify(config)
+		if _, err := config.generator("test.json"); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+}
+
+// Every check gets one broken field, the error has to name it.
+func TestGeneratorConfigErrors(t *testing.T) {
+	simplex := func(config *generatorConfig) {
+		config.Type = "simplex"
+		config.Simplex = &simplexConfig{Scale: 16.0, Threshold: 0.5, Block: "stone"}
+	}
+	floatingRock := func(config *generatorConfig) {
+		config.Type = "floatingrock"
+		config.FloatingRock = &floatingRockConfig{Block: "stone"}
+		config.FloatingRock.PlateauStart = 0.85
+		config.FloatingRock.PlateauEnd = 0.95
+		config.FloatingRock.CaveScale = 5.0
+		config.FloatingRock.DetailScale = 3.0
+		config.FloatingRock.DensityOctaves = 5
+	}
+
+	tests := []struct {
+		modify func(config *generatorConfig)
+		want   string
+	}{
+		{func(config *generatorConfig) { config.Name = "" },
+			"test.json: name: missing"},
+		{func(config *generatorConfig) { config.Name = "shapes" },
+			`test.json: name: a generator named "shapes" already exists`},
+		{func(config *generatorConfig) { config.Type = "" },
+			"test.json: type: missing, expected one of terrain, simplex or floatingrock"},
+		{func(config *generatorConfig) { config.Type = "flat" },
+			`test.json: type: unknown type "flat", expected one of terrain, simplex or floatingrock`},
+
+		{func(config *generatorConfig) { config.Terrain = nil },
+			`test.json: terrain: required by type "terrain"`},
+		{func(config *generatorConfig) { config.Terrain.Layers = nil },
+			"test.json: terrain.layers: needs at least one layer"},
+		{func(config *generatorConfig) { config.Terrain.Layers[0].Octaves = 0 },
+			"test.json: terrain.layers[0].octaves: must be at least 1, got 0"},
+		{func(config *generatorConfig) { config.Terrain.Layers[0].Scale = 0.0 },
+			"test.json: terrain.layers[0].scale: must be greater than 0, got 0"},
+		{func(config *generatorConfig) { config.Terrain.TemperatureScale = -1.0 },
+			"test.json: terrain.temperatureScale: must be greater than 0, got -1"},
+		{func(config *generatorConfig) { config.Terrain.HumidityScale = 0.0 },
+			"test.json: terrain.humidityScale: must be greater than 0, got 0"},
+		{func(config *generatorConfig) { config.Terrain.BiomeBlend = -0.5 },
+			"test.json: terrain.biomeBlend: can't be negative, got -0.5"},
+		{func(config *generatorConfig) { config.Terrain.Underground = "" },
+			"test.json: terrain.underground: missing block type"},
+		{func(config *generatorConfig) { config.Terrain.Underground = "granite" },
+			`test.json: terrain.underground: unknown block type "granite"`},
+		{func(config *generatorConfig) { config.Terrain.Biomes = nil },
+			"test.json: terrain.biomes: needs at least one biome"},
+		{func(config *generatorConfig) { config.Terrain.Biomes[0].Name = "" },
+			"test.json: terrain.biomes[0].name: missing"},
+		{func(config *generatorConfig) { config.Terrain.Biomes[0].Temperature = 1.5 },
+			"test.json: terrain.biomes[0].temperature: must be within -1..1, got 1.5"},
+		{func(config *generatorConfig) { config.Terrain.Biomes[0].Humidity = -2.0 },
+			"test.json: terrain.biomes[0].humidity: must be within -1..1, got -2"},
+		{func(config *generatorConfig) { config.Terrain.Biomes[0].SubsurfaceDepth = -1 },
+			"test.json: terrain.biomes[0].subsurfaceDepth: can't be negative, got -1"},
+		{func(config *generatorConfig) { config.Terrain.Biomes[0].Surface = "moss" },
+			`test.json: terrain.biomes[0].surface: unknown block type "moss"`},
+		{func(config *generatorConfig) { config.Terrain.Biomes[0].Subsurface = "" },
+			"test.json: terrain.biomes[0].subsurface: missing block type"},
+
+		{func(config *generatorConfig) { config.Type = "simplex" },
+			`test.json: simplex: required by type "simplex"`},
+		{func(config *generatorConfig) { simplex(config); config.Simplex.Scale = 0.0 },
+			"test.json: simplex.scale: must be greater than 0, got 0"},
+		{func(config *generatorConfig) { simplex(config); config.Simplex.Block = "" },
+			"test.json: simplex.block: missing block type"},
+
+		{func(config *generatorConfig) { config.Type = "floatingrock" },
+			`test.json: floatingrock: required by type "floatingrock"`},
+		{func(config *generatorConfig) { floatingRock(config); config.FloatingRock.Block = "basalt" },
+			`test.json: floatingrock.block: unknown block type "basalt"`},
+		{func(config *generatorConfig) { floatingRock(config); config.FloatingRock.PlateauEnd = 0.85 },
+			"test.json: floatingrock.plateauEnd: must be above plateauStart (0.85), got 0.85"},
+		{func(config *generatorConfig) { floatingRock(config); config.FloatingRock.CaveScale = 0.0 },
+			"test.json: floatingrock.caveScale: must be greater than 0, got 0"},
+		{func(config *generatorConfig) { floatingRock(config); config.FloatingRock.DetailScale = -3.0 },
+			"test.json: floatingrock.detailScale: must be greater than 0, got -3"},
+		{func(config *generatorConfig) { floatingRock(config); config.FloatingRock.DensityOctaves = 0 },
+			"test.json: floatingrock.densityOctaves: must be at least 1, got 0"},
+
+		{func(config *generatorConfig) { config.Caves.RegionSize = 0 },
+			"test.json: caves.regionSize: must be at least 1, got 0"},
+		{func(config *generatorConfig) { config.Caves.WormsPerRegion = -1 },
+			"test.json: caves.wormsPerRegion: can't be negative, got -1"},
+		{func(config *generatorConfig) { config.Caves.WormLength = 0 },
+			"test.json: caves.wormLength: must be at least 1, got 0"},
+		{func(config *generatorConfig) { config.Caves.WormStep = 0.0 },
+			"test.json: caves.wormStep: must be greater than 0, got 0"},
+		{func(config *generatorConfig) { config.Caves.WormTurnScale = 0.0 },
+			"test.json: caves.wormTurnScale: must be greater than 0, got 0"},
+		{func(config *generatorConfig) { config.Caves.MinRadius = 0.0; config.Caves.MaxRadius = 0.0 },
+			"test.json: caves.minRadius: must be greater than 0, got 0"},
+		{func(config *generatorConfig) { config.Caves.MaxRadius = 1.0 },
+			"test.json: caves.maxRadius: must be at least minRadius (1.2), got 1"},
+		{func(config *generatorConfig) { config.Caves.CavernScale = 0.0 },
+			"test.json: caves.cavernScale: must be greater than 0, got 0"},
+
+		{func(config *generatorConfig) { config.Ores.Host = "" },
+			"test.json: ores.host: missing block type"},
+		{func(config *generatorConfig) { config.Ores.Resources[0].MaxY = -1 },
+			"test.json: ores.resources[0].maxY: must be at least minY (0), got -1"},
+		{func(config *generatorConfig) { config.Ores.Resources[0].VeinsPerChunk = -2.5 },
+			"test.json: ores.resources[0].veinsPerChunk: can't be negative, got -2.5"},
+		{func(config *generatorConfig) { config.Ores.Resources[0].ClusterSize = 0 },
+			"test.json: ores.resources[0].clusterSize: must be at least 1, got 0"},
+		{func(config *generatorConfig) { config.Ores.Resources[0].Block = "mithril" },
+			`test.json: ores.resources[0].block: unknown block type "mithril"`},
+
+		{func(config *generatorConfig) { config.Structures = []string{"trees", "ruins"} },
+			`test.json: structures[1]: unknown structure placer "ruins"`},
+	}
+
+	for _, test := range tests {
+		config := testConfig(t)
+		test.modify(config)
+		_, err := config.generator("test.json")
+		if err == nil {
+			t.Errorf("no error, want %q", test.want)
+		} else if err.Error() != test.want {
+			t.Errorf("error %q, want %q", err, test.want)
+		}
+	}
+}
+
+// Every problem is reported at once, one per line.
+func TestGeneratorConfigCollectsErrors(t *testing.T) {
+	config := testConfig(t)
+	config.Name = ""
+	config.Caves.RegionSize = 0
+	config.Structures = []string{"ruins"}
+
+	want := "test.json: name: missing\n" +
+		"test.json: caves.regionSize: must be at least 1, got 0\n" +
+		`test.json: structures[0]: unknown structure placer "ruins"`
+	if _, err := config.generator("test.json"); err == nil || err.Error() != want {
+		t.Errorf("error %q, want %q", err, want)
+	}
+}
+
+// Files that don't decode are reported with the line and column of the
+// problem where json knows it.
+func TestLoadGeneratorFileErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generators")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"{\n\t\"name\": \"test\",\n\t\"type\" \"terrain\"\n}",
+			"test.json:3:9: invalid character '\"' after object key"},
+		{"{\n\t\"name\": 7\n}",
+			"test.json:2:10: name: expected string, got number"},
+		{"{\n\t\"name\": \"test\",\n\t\"seed\": 7\n}",
+			`test.json: json: unknown field "seed"`},
+	}
+
+	filename := filepath.Join(dir, "test.json")
+	for _, test := range tests {
+		if err := ioutil.WriteFile(filename, []byte(test.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadGeneratorFile(filename)
+		want := filepath.Join(dir, test.want)
+		if err == nil {
+			t.Errorf("no error, want %q", want)
+		} else if err.Error() != want {
+			t.Errorf("error %q, want %q", err, want)
+		}
+	}
+}
+
+func TestLoadGeneratorFileRegisters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generators")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer delete(worldGenerators, "test")
+
+	filename := filepath.Join(dir, "test.json")
+	if err := ioutil.WriteFile(filename, []byte(testGeneratorConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gen, err := LoadGeneratorFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if worldGenerators["test"] != gen {
+		t.Error("generator isn't registered under its name")
+	}
+	if gen.Caves == nil || gen.Ores == nil || len(gen.Structures) != 1 || gen.Decoration == nil {
+		t.Errorf("optional passes missing: %+v", gen)
+	}
+}
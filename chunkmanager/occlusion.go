@@ -0,0 +1,254 @@
+package chunkmanager
+
+import (
+	"bedrock/math/vector"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Ambient occlusion is the share of rays leaving a face that get
+// maxOcclusionDistance away without hitting a block. Rays walk the voxel
+// grid cell by cell, so they can't step over a corner, and chunks are baked
+// in parallel.
+
+// Rays that get this far count as open, so editing a block only changes the
+// occlusion of faces within this distance
+const maxOcclusionDistance = 16.0
+
+// Goroutines baking occlusion and shadows
+var occlusionWorkers = runtime.NumCPU()
+
+// Rays per face, the half of a full sphere of rays pointing out of it
+var occlusionRays = hemisphereRays(16)
+
+// Port of Golden Section Spiral python code
+// from http://www.softimageblog.com/archives/115
+func goldenSectionSpiralRays(numRays int) []vector.Vector3f {
+	rays := []vector.Vector3f{}
+
+	increment := math.Pi * (3.0 - math.Sqrt(5.0))
+	offset := 2.0 / float64(numRays)
+	for t := 0; t < numRays; t++ {
+		y := (float64(t) * offset) - 1.0 + (offset / 2.0)
+		r := math.Sqrt(1 - (y * y))
+		phi := float64(t) * increment
+
+		rays = append(rays, vector.Vector3f{math.Cos(phi) * r, y, math.Sin(phi) * r})
+	}
+
+	return rays
+}
+
+func hemisphereRays(numRays int) [6][]vector.Vector3f {
+	rays := [6][]vector.Vector3f{}
+	for _, ray := range goldenSectionSpiralRays(numRays) {
+		for face := 0; face < 6; face++ {
+			if vector.DotProduct(ray, chunkNormals[face]) > 0.0 {
+				rays[face] = append(rays[face], ray)
+			}
+		}
+	}
+
+	return rays
+}
+
+// Sets the number of rays over the full sphere, half of them leave each
+// face. Call before Start.
+func SetOcclusionRays(numRays int) error {
+	if numRays < 2 {
+		return fmt.Errorf("occlusion: need at least 2 rays, got %d", numRays)
+	}
+
+	occlusionRays = hemisphereRays(numRays)
+	return nil
+}
+
+// Sets how many goroutines bake occlusion and shadows, call before Start.
+func SetOcclusionWorkers(workers int) error {
+	if workers < 1 {
+		return fmt.Errorf("occlusion: need at least 1 worker, got %d", workers)
+	}
+
+	occlusionWorkers = workers
+	return nil
+}
+
+// Walks the cells a ray passes through, starting with the one origin is in.
+// Returns true if the ray gets maxDistance away without a block
+func castVoxelRay(origin, dir vector.Vector3f, maxDistance float64) bool {
+	originAxes := [3]float64{origin.X, origin.Y, origin.Z}
+	dirAxes := [3]float64{dir.X, dir.Y, dir.Z}
+
+	cell := [3]int{}
+	step := [3]int{}
+	// Ray distance to the next cell border per axis, and between borders
+	next := [3]float64{}
+	delta := [3]float64{}
+	for axis := 0; axis < 3; axis++ {
+		cell[axis] = int(math.Floor(originAxes[axis]))
+		switch {
+		case dirAxes[axis] > 0.0:
+			step[axis] = 1
+			delta[axis] = 1.0 / dirAxes[axis]
+			next[axis] = (float64(cell[axis]+1) - originAxes[axis]) * delta[axis]
+		case dirAxes[axis] < 0.0:
+			step[axis] = -1
+			delta[axis] = -1.0 / dirAxes[axis]
+			next[axis] = (originAxes[axis] - float64(cell[axis])) * delta[axis]
+		default:
+			delta[axis] = math.Inf(1)
+			next[axis] = math.Inf(1)
+		}
+	}
+
+	// Chunk lookups only when the ray crosses into another chunk
+	chnkPos, blkPos := WorldToChunkBlock(cell[0], cell[1], cell[2])
+	chnk := chunkMap[chnkPos]
+	for {
+		if chnk != nil {
+			if _, ok := chnk.data[blkPos]; ok {
+				return false
+			}
+		}
+
+		axis := 0
+		if next[1] < next[axis] {
+			axis = 1
+		}
+		if next[2] < next[axis] {
+			axis = 2
+		}
+		if next[axis] > maxDistance {
+			return true
+		}
+
+		cell[axis] += step[axis]
+		next[axis] += delta[axis]
+
+		newChnkPos, newBlkPos := WorldToChunkBlock(cell[0], cell[1], cell[2])
+		if newChnkPos != chnkPos {
+			chnkPos = newChnkPos
+			chnk = chunkMap[chnkPos]
+		}
+		blkPos = newBlkPos
+	}
+}
+
+// Occlusion of every face of a block.
+func occlusion(chnkPos ChunkCoord, blkPos BlockCoord) [6]float64 {
+	occFactor := [6]float64{0.0, 0.0, 0.0, 0.0, 0.0, 0.0}
+	for t := 0; t < 6; t++ {
+		occFactor[t] = faceOcclusion(chnkPos, blkPos, t)
+	}
+
+	return occFactor
+}
+
+// Share of the rays leaving a face that get further than
+// maxOcclusionDistance without hitting a block.
+func faceOcclusion(chnkPos ChunkCoord, blkPos BlockCoord, t int) float64 {
+	rays := occlusionRays[t]
+	if len(rays) == 0 {
+		return 1.0
+	}
+
+	// Centre of the face, nudged out so the first cell is the neighbour
+	normal := chunkNormals[t]
+	origin := vector.Vector3f{
+		float64((chnkPos.X*ChunkBase)+blkPos.X) + 0.5 + (normal.X * 0.50001),
+		float64((chnkPos.Y*ChunkBase)+blkPos.Y) + 0.5 + (normal.Y * 0.50001),
+		float64((chnkPos.Z*ChunkBase)+blkPos.Z) + 0.5 + (normal.Z * 0.50001),
+	}
+
+	open := 0
+	for _, ray := range rays {
+		if castVoxelRay(origin, ray, maxOcclusionDistance) {
+			open++
+		}
+	}
+
+	return float64(open) / float64(len(rays))
+}
+
+// Runs bake on every chunk on occlusionWorkers goroutines. Workers only
+// read the world and write their own chunk's blocks, nothing else may
+// change the world meanwhile.
+func bakeInParallel(chunks []*Chunk, bake func(chunk *Chunk)) {
+	jobs := make(chan *Chunk)
+	var wg sync.WaitGroup
+	for w := 0; w < occlusionWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range jobs {
+				bake(chunk)
+			}
+		}()
+	}
+
+	for _, chunk := range chunks {
+		jobs <- chunk
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// Bakes the occlusion of every block in the chunks in parallel.
+func bakeOcclusion(chunks []*Chunk) {
+	bakeInParallel(chunks, func(chunk *Chunk) {
+		for blkPos, block := range chunk.data {
+			block.occlusion = occlusion(chunk.position, blkPos)
+		}
+	})
+}
+
+type StartupTiming struct {
+	NumChunks  int
+	NumBlocks  int
+	Generation time.Duration
+	Shadows    time.Duration
+	Occlusion  time.Duration
+}
+
+// Builds a fresh world of size chunks per axis with the current generator
+// and seed, the way Start does, and times generation and the shadow and
+// occlusion bakes. Meant for benchmarking tools, it replaces the current
+// world, see resetWorld.
+func MeasureStartup(size int) StartupTiming {
+	resetWorld()
+	worldSize = size
+
+	for x := 0; x < size; x++ {
+		for z := 0; z < size; z++ {
+			for y := 0; y < size; y++ {
+				requestChunk(ChunkCoord{x, y, z})
+			}
+		}
+	}
+
+	timing := StartupTiming{}
+	start := time.Now()
+	for updatePipeline(STAGE_DECORATION) > 0 {
+	}
+	timing.Generation = time.Since(start)
+
+	start = time.Now()
+	for updatePipeline(STAGE_LIGHTING) > 0 {
+	}
+	timing.Shadows = time.Since(start)
+
+	start = time.Now()
+	for updatePipeline(STAGE_OCCLUSION) > 0 {
+	}
+	timing.Occlusion = time.Since(start)
+
+	timing.NumChunks = len(chunkMap)
+	for _, chnk := range chunkMap {
+		timing.NumBlocks += len(chnk.data)
+	}
+
+	return timing
+}
@@ -0,0 +1,464 @@
+package chunkmanager
+
+import (
+	"dwelling/renderer"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// Builds a 2x2x2 chunk world with blocks on both sides of every chunk
+// border: scattered around the middle, a stair of blocks around the corner
+// where all eight chunks meet, a row along an edge and a wall across a face.
+// Shadows and occlusion are baked for a low morning sun.
+func buildSeamWorld() {
+	resetWorld()
+	SetSunDirection(renderer.DaylightAt(8.0).SunDir)
+
+	cells := map[WorldCoord]bool{}
+	rnd := rand.New(rand.NewSource(1))
+	for x := 12; x < 20; x++ {
+		for y := 12; y < 20; y++ {
+			for z := 12; z < 20; z++ {
+				if rnd.Intn(10) < 3 {
+					cells[WorldCoord{x, y, z}] = true
+				}
+			}
+		}
+	}
+	for x := 15; x <= 16; x++ {
+		for y := 15; y <= 16; y++ {
+			for z := 15; z <= 16; z++ {
+				cells[WorldCoord{x, y, z}] = x+y+z < 48
+			}
+		}
+	}
+	for x := 8; x < 24; x++ {
+		cells[WorldCoord{x, 4, 15}] = true
+		cells[WorldCoord{x, 5, 16}] = true
+	}
+	for y := 6; y < 10; y++ {
+		for z := 2; z < 8; z++ {
+			cells[WorldCoord{15 + (z % 2), y, z}] = true
+		}
+	}
+
+	chunks := []*Chunk{}
+	for x := 0; x < 2; x++ {
+		for y := 0; y < 2; y++ {
+			for z := 0; z < 2; z++ {
+				chunk := &Chunk{
+					data:     map[BlockCoord]*Block{},
+					Stage:    STAGE_OCCLUSION,
+					position: ChunkCoord{x, y, z},
+				}
+				chunkMap[chunk.position] = chunk
+				chunks = append(chunks, chunk)
+			}
+		}
+	}
+	for cell, filled := range cells {
+		if !filled {
+			continue
+		}
+		chnkPos, blkPos := WorldToChunkBlock(cell.X, cell.Y, cell.Z)
+		chunkMap[chnkPos].data[blkPos] = &Block{position: blkPos, blockType: BLOCK_STONE}
+	}
+
+	bakeShadows(chunks)
+	bakeOcclusion(chunks)
+}
+
+// Every face corner at the same world position and facing the same way has
+// to get the same occlusion and shadow, whichever block and chunk it
+// belongs to, or the lighting shows seams.
+func TestVertexOcclusionSeams(t *testing.T) {
+	buildSeamWorld()
+	defer resetWorld()
+
+	type seamKey struct {
+		vertex WorldCoord
+		face   int
+	}
+	type seamValue struct {
+		occlusion, shadow float64
+		chunk             ChunkCoord
+	}
+	values := map[seamKey][]seamValue{}
+
+	for chnkPos, chunk := range chunkMap {
+		neighbors := getChunkNeighborhood(chnkPos)
+		for pos := range chunk.data {
+			for face := 0; face < 6; face++ {
+				offset := faceOffsets[face]
+				if neighbors.block(pos.X+offset[0], pos.Y+offset[1], pos.Z+offset[2]) != nil {
+					continue
+				}
+
+				for _, corner := range renderer.FaceCorners[face] {
+					vertex := [3]int{pos.X + corner[0], pos.Y + corner[1], pos.Z + corner[2]}
+					occ, shadow := vertexOcclusion(neighbors, vertex, face)
+					key := seamKey{WorldCoord{
+						(chnkPos.X * ChunkBase) + vertex[0],
+						(chnkPos.Y * ChunkBase) + vertex[1],
+						(chnkPos.Z * ChunkBase) + vertex[2],
+					}, face}
+					values[key] = append(values[key], seamValue{occ, shadow, chnkPos})
+				}
+			}
+		}
+	}
+
+	numShared := 0
+	numAcrossChunks := 0
+	for key, shared := range values {
+		if len(shared) < 2 {
+			continue
+		}
+		numShared++
+
+		acrossChunks := false
+		for _, value := range shared[1:] {
+			if value.chunk != shared[0].chunk {
+				acrossChunks = true
+			}
+			if value.occlusion != shared[0].occlusion || value.shadow != shared[0].shadow {
+				t.Errorf("vertex %v facing %d has occlusion and shadow %v, %v in chunk %v and %v, %v in chunk %v",
+					key.vertex, key.face, shared[0].occlusion, shared[0].shadow, shared[0].chunk,
+					value.occlusion, value.shadow, value.chunk)
+			}
+		}
+		if acrossChunks {
+			numAcrossChunks++
+		}
+	}
+
+	if numAcrossChunks == 0 {
+		t.Fatalf("none of the %d shared vertices are shared across chunks", numShared)
+	}
+}
+
+// Builds a 3x1x3 chunk world with a floor, a pillar on the corner in the
+// middle of the floor and a wall along a border, baked like the seam world.
+// It's sparse enough that a change only reaches the chunks it has to.
+func buildFloorWorld() {
+	resetWorld()
+	SetSunDirection(renderer.DaylightAt(8.0).SunDir)
+
+	chunks := []*Chunk{}
+	for x := 0; x < 3; x++ {
+		for z := 0; z < 3; z++ {
+			chunk := newEmptyChunk(ChunkCoord{x, 0, z})
+			chunk.Stage = STAGE_OCCLUSION
+			chunkMap[chunk.position] = chunk
+			chunks = append(chunks, chunk)
+		}
+	}
+	cells := []WorldCoord{}
+	for x := 0; x < 48; x++ {
+		for z := 0; z < 48; z++ {
+			cells = append(cells, WorldCoord{x, 0, z})
+		}
+	}
+	for y := 1; y < 6; y++ {
+		cells = append(cells, WorldCoord{31, y, 31})
+	}
+	for z := 4; z < 12; z++ {
+		cells = append(cells, WorldCoord{32, 1, z}, WorldCoord{32, 2, z})
+	}
+	for _, cell := range cells {
+		chnkPos, blkPos := WorldToChunkBlock(cell.X, cell.Y, cell.Z)
+		chunkMap[chnkPos].data[blkPos] = &Block{position: blkPos, blockType: BLOCK_STONE}
+	}
+
+	bakeShadows(chunks)
+	bakeOcclusion(chunks)
+}
+
+type floorEdit struct {
+	cells  []WorldCoord
+	filled bool
+}
+
+// Edits made to the floor world one after another. Rays only just get from
+// the floor next to a chunk border to the pillar, so the chunk on the far
+// side of the border only changes through the corners it shares with that
+// floor. A hole in the floor at a corner does the same to the chunk
+// diagonal to it.
+var floorEdits = []floorEdit{
+	{pillarCells(16, 22, 1, 16), true},
+	{[]WorldCoord{{15, 0, 15}}, false},
+	{[]WorldCoord{{32, 0, 16}}, false},
+	{[]WorldCoord{{31, 6, 31}}, true},
+	{[]WorldCoord{{31, 3, 31}}, false},
+	{[]WorldCoord{{31, 1, 8}}, true},
+	{[]WorldCoord{{32, 2, 11}}, false},
+}
+
+// Returns: the cells of a column from minY up to maxY, exclusive
+func pillarCells(x, z, minY, maxY int) []WorldCoord {
+	cells := []WorldCoord{}
+	for y := minY; y < maxY; y++ {
+		cells = append(cells, WorldCoord{x, y, z})
+	}
+
+	return cells
+}
+
+func (edit floorEdit) apply() {
+	for _, cell := range edit.cells {
+		chnkPos, blkPos := WorldToChunkBlock(cell.X, cell.Y, cell.Z)
+		if edit.filled {
+			chunkMap[chnkPos].data[blkPos] = &Block{position: blkPos, blockType: BLOCK_STONE}
+		} else {
+			delete(chunkMap[chnkPos].data, blkPos)
+		}
+	}
+}
+
+type cornerKey struct {
+	pos          BlockCoord
+	face, corner int
+}
+
+// Returns: occlusion and shadow of the corners of every visible face of a
+// chunk, what its mesh is built from
+func chunkCorners(chnkPos ChunkCoord) map[cornerKey][2]float64 {
+	corners := map[cornerKey][2]float64{}
+	neighbors := getChunkNeighborhood(chnkPos)
+	for pos := range chunkMap[chnkPos].data {
+		for face := 0; face < 6; face++ {
+			offset := faceOffsets[face]
+			if neighbors.block(pos.X+offset[0], pos.Y+offset[1], pos.Z+offset[2]) != nil {
+				continue
+			}
+
+			for c, corner := range renderer.FaceCorners[face] {
+				vertex := [3]int{pos.X + corner[0], pos.Y + corner[1], pos.Z + corner[2]}
+				occ, shadow := vertexOcclusion(neighbors, vertex, face)
+				corners[cornerKey{pos, face, c}] = [2]float64{occ, shadow}
+			}
+		}
+	}
+
+	return corners
+}
+
+// Returns: whether the visible faces of a chunk differ
+func cornersDiffer(a, b map[cornerKey][2]float64) bool {
+	if len(a) != len(b) {
+		return true
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns: the corners of every chunk of the world
+func worldCorners() map[ChunkCoord]map[cornerKey][2]float64 {
+	corners := map[ChunkCoord]map[cornerKey][2]float64{}
+	for chnkPos := range chunkMap {
+		corners[chnkPos] = chunkCorners(chnkPos)
+	}
+
+	return corners
+}
+
+// Fails for every chunk whose faces differ between the two bakes but that
+// isn't queued for a rebuild.
+func checkRebuildsQueued(t *testing.T, edit floorEdit, before, after map[ChunkCoord]map[cornerKey][2]float64) {
+	t.Helper()
+	for chnkPos := range after {
+		if _, ok := rebuildChunks[chnkPos]; !ok && cornersDiffer(before[chnkPos], after[chnkPos]) {
+			t.Errorf("edit at %v: chunk %v changed but isn't rebuilt", edit.cells[0], chnkPos)
+		}
+	}
+}
+
+// A refresh after an edit has to leave every visible face with the
+// occlusion a full rebake gives it, and queue every chunk whose faces
+// changed, including those that only share corners with changed blocks.
+func TestRefreshOcclusionMatchesRebake(t *testing.T) {
+	buildFloorWorld()
+	defer resetWorld()
+
+	for _, edit := range floorEdits {
+		before := worldCorners()
+		edit.apply()
+		rebuildChunks = map[ChunkCoord]*Chunk{}
+		refreshOcclusion(edit.cells)
+
+		for chnkPos, chunk := range chunkMap {
+			neighbors := getChunkNeighborhood(chnkPos)
+			for pos, blk := range chunk.data {
+				want := occlusion(chnkPos, pos)
+				for face := 0; face < 6; face++ {
+					offset := faceOffsets[face]
+					if neighbors.block(pos.X+offset[0], pos.Y+offset[1], pos.Z+offset[2]) != nil {
+						continue
+					}
+					if blk.occlusion[face] != want[face] {
+						t.Errorf("edit at %v: block %v in chunk %v facing %d has occlusion %v after the refresh, %v rebaked",
+							edit.cells[0], pos, chnkPos, face, blk.occlusion[face], want[face])
+					}
+				}
+				blk.occlusion = want
+			}
+		}
+
+		checkRebuildsQueued(t, edit, before, worldCorners())
+	}
+}
+
+// Returns: the shadows of every block of a chunk
+func chunkShadows(chnkPos ChunkCoord) map[BlockCoord][6]float64 {
+	shadows := map[BlockCoord][6]float64{}
+	for pos, blk := range chunkMap[chnkPos].data {
+		shadows[pos] = blk.shadow
+	}
+
+	return shadows
+}
+
+// Returns: the visible faces of a chunk whose shadow isn't what a full
+// rebake gives them, described for error messages
+func staleShadows(chnkPos ChunkCoord) []string {
+	stale := []string{}
+	neighbors := getChunkNeighborhood(chnkPos)
+	for pos, blk := range chunkMap[chnkPos].data {
+		for face := 0; face < 6; face++ {
+			offset := faceOffsets[face]
+			if neighbors.block(pos.X+offset[0], pos.Y+offset[1], pos.Z+offset[2]) != nil {
+				continue
+			}
+			if want := faceShadow(chnkPos, pos, face); blk.shadow[face] != want {
+				stale = append(stale, fmt.Sprintf("block %v facing %d has shadow %v, %v rebaked", pos, face, blk.shadow[face], want))
+			}
+		}
+	}
+
+	return stale
+}
+
+// Like occlusion, a shadow refresh after an edit has to leave every visible
+// face as a full rebake would, and queue every chunk whose faces changed.
+func TestRefreshShadowsMatchesRebake(t *testing.T) {
+	buildFloorWorld()
+	defer resetWorld()
+
+	for _, edit := range floorEdits {
+		before := worldCorners()
+		edit.apply()
+		rebuildChunks = map[ChunkCoord]*Chunk{}
+		refreshShadows(edit.cells)
+
+		for chnkPos, chunk := range chunkMap {
+			for _, stale := range staleShadows(chnkPos) {
+				t.Errorf("edit at %v: chunk %v: %s", edit.cells[0], chnkPos, stale)
+			}
+			bakeChunkShadows(chunk)
+		}
+		if len(shadowQueue) != 0 {
+			t.Errorf("edit at %v: %v queued for a rebake with nothing rebuilding", edit.cells[0], shadowQueue)
+		}
+
+		checkRebuildsQueued(t, edit, before, worldCorners())
+	}
+}
+
+// Chunks next to one being rebuilt can't be written, a refresh reaching them
+// has to leave them as they were and queue them for a rebake instead.
+func TestRefreshShadowsDefersBusyChunks(t *testing.T) {
+	buildFloorWorld()
+	defer resetWorld()
+
+	rebuilding := chunkMap[ChunkCoord{0, 0, 0}]
+	rebuilding.IsRebuilding = true
+	before := map[ChunkCoord]map[BlockCoord][6]float64{}
+	for chnkPos := range chunkMap {
+		before[chnkPos] = chunkShadows(chnkPos)
+	}
+
+	edit := floorEdits[0]
+	edit.apply()
+	refreshShadows(edit.cells)
+
+	numDeferred := 0
+	for chnkPos := range chunkMap {
+		queued := false
+		for _, pos := range shadowQueue {
+			queued = queued || pos == chnkPos
+		}
+
+		if !chunkBusy(chnkPos) {
+			if queued {
+				t.Errorf("chunk %v is queued, but nothing near it is rebuilding", chnkPos)
+			}
+			for _, stale := range staleShadows(chnkPos) {
+				t.Errorf("chunk %v: %s", chnkPos, stale)
+			}
+			continue
+		}
+
+		shadows := chunkShadows(chnkPos)
+		for pos, shadow := range before[chnkPos] {
+			if shadows[pos] != shadow {
+				t.Errorf("chunk %v is busy, but block %v had its shadow changed from %v to %v", chnkPos, pos, shadow, shadows[pos])
+			}
+		}
+		if len(staleShadows(chnkPos)) > 0 {
+			if !queued {
+				t.Errorf("chunk %v is busy and stale, but isn't queued for a rebake", chnkPos)
+			}
+			numDeferred++
+		}
+	}
+	if numDeferred == 0 {
+		t.Fatalf("the edit at %v changed no shadows in busy chunks", edit.cells[0])
+	}
+
+	// Once the rebuild is done the queue catches up
+	rebuilding.IsRebuilding = false
+	for n := 0; len(shadowQueue) > 0; n++ {
+		if n > len(chunkMap) {
+			t.Fatalf("%v still queued", shadowQueue)
+		}
+		updateShadows()
+	}
+	for chnkPos := range chunkMap {
+		for _, stale := range staleShadows(chnkPos) {
+			t.Errorf("after the rebake: chunk %v: %s", chnkPos, stale)
+		}
+	}
+}
+
+func benchmarkStartup(b *testing.B, generator string, size int) {
+	defer func(gen *WorldGenerator) {
+		worldGenerator = gen
+	}(worldGenerator)
+	SetSeed(1)
+	if err := SetGenerator(generator); err != nil {
+		b.Fatal(err)
+	}
+
+	for n := 0; n < b.N; n++ {
+		MeasureStartup(size)
+	}
+}
+
+// The demo world
+func BenchmarkStartup4x4x4(b *testing.B) {
+	benchmarkStartup(b, "shapes", 4)
+}
+
+func BenchmarkStartupTerrain4x4x4(b *testing.B) {
+	benchmarkStartup(b, "terrain", 4)
+}
+
+func BenchmarkStartupLarge(b *testing.B) {
+	benchmarkStartup(b, "terrain", 8)
+}
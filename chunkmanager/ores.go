@@ -0,0 +1,119 @@
+package chunkmanager
+
+import (
+	"math/rand"
+)
+
+type OreResource struct {
+	BlockType BlockType
+	// World Y band the veins start in
+	MinY, MaxY int
+	// Average veins per chunk, the fraction is a probability of one more
+	VeinsPerChunk float64
+	ClusterSize   int
+}
+
+type OreDistribution struct {
+	// Only blocks of this type are replaced
+	Host      BlockType
+	Resources []OreResource
+}
+
+var defaultOres = OreDistribution{
+	Host: BLOCK_STONE,
+	Resources: []OreResource{
+		{BlockType: BLOCK_COAL_ORE, MinY: 0, MaxY: 48, VeinsPerChunk: 2.5, ClusterSize: 10},
+		{BlockType: BLOCK_IRON_ORE, MinY: -32, MaxY: 24, VeinsPerChunk: 1.5, ClusterSize: 6},
+		{BlockType: BLOCK_GOLD_ORE, MinY: -64, MaxY: 8, VeinsPerChunk: 0.4, ClusterSize: 4},
+	},
+}
+
+var veinSteps = [6]BlockCoord{
+	{0, 0, 1},
+	{0, 0, -1},
+	{-1, 0, 0},
+	{1, 0, 0},
+	{0, 1, 0},
+	{0, -1, 0},
+}
+
+// Scatters ore veins in the chunk. Veins stay within the chunk, so the
+// result only depends on the world seed and the chunk position.
+func (ores *OreDistribution) Scatter(chunk *Chunk) {
+	chunkMinY := chunk.position.Y * ChunkBase
+	chunkMaxY := chunkMinY + ChunkBase - 1
+
+	for t, resource := range ores.Resources {
+		rnd := rand.New(rand.NewSource(chunkSeed(chunk.position, 0x4f52+int64(t))))
+
+		numVeins := int(resource.VeinsPerChunk)
+		if rnd.Float64() < resource.VeinsPerChunk-float64(numVeins) {
+			numVeins++
+		}
+
+		// Draw even when the band misses the chunk to keep the sequence stable
+		for vein := 0; vein < numVeins; vein++ {
+			x := rnd.Intn(ChunkBase)
+			z := rnd.Intn(ChunkBase)
+			worldY := resource.MinY + rnd.Intn(resource.MaxY-resource.MinY+1)
+			if worldY < chunkMinY || worldY > chunkMaxY {
+				continue
+			}
+
+			pos := BlockCoord{x, worldY - chunkMinY, z}
+			for step := 0; step < resource.ClusterSize; step++ {
+				if blk, ok := chunk.data[pos]; ok && blk.blockType == ores.Host {
+					blk.blockType = resource.BlockType
+				}
+
+				next := veinSteps[rnd.Intn(len(veinSteps))]
+				pos = BlockCoord{pos.X + next.X, pos.Y + next.Y, pos.Z + next.Z}
+				if pos.X < 0 || pos.X >= ChunkBase || pos.Y < 0 || pos.Y >= ChunkBase || pos.Z < 0 || pos.Z >= ChunkBase {
+					break
+				}
+			}
+		}
+	}
+}
+
+type OreStatistics struct {
+	NumChunks int
+	// Blocks of the host type left after scattering
+	HostBlocks int
+	Counts     map[BlockType]int
+}
+
+// Generates the chunks between min and max (inclusive) with the current
+// generator, without adding them to the world, and counts the resource
+// blocks in them.
+func SampleOreStatistics(min, max ChunkCoord) OreStatistics {
+	stats := OreStatistics{
+		Counts: map[BlockType]int{},
+	}
+	ores := worldGenerator.Ores
+	if ores == nil {
+		return stats
+	}
+	for _, resource := range ores.Resources {
+		stats.Counts[resource.BlockType] = 0
+	}
+
+	for x := min.X; x <= max.X; x++ {
+		for y := min.Y; y <= max.Y; y++ {
+			for z := min.Z; z <= max.Z; z++ {
+				chunk := generateIsolatedChunk(ChunkCoord{x, y, z})
+				stats.NumChunks++
+
+				for _, blk := range chunk.data {
+					if blk.blockType == ores.Host {
+						stats.HostBlocks++
+					} else if _, ok := stats.Counts[blk.blockType]; ok {
+						stats.Counts[blk.blockType]++
+					}
+				}
+			}
+		}
+	}
+
+	return stats
+}
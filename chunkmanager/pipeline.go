@@ -0,0 +1,244 @@
+package chunkmanager
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Chunks are generated in stages. A chunk only runs a stage once every
+// existing neighbour (including diagonals) has completed the stage's
+// prerequisite, so passes that read or write across chunk borders see
+// finished data. Chunk.Stage is the last stage the chunk completed.
+const (
+	STAGE_NONE int = iota
+	STAGE_TERRAIN
+	STAGE_CAVES
+	STAGE_STRUCTURES
+	STAGE_DECORATION
+	STAGE_LIGHTING
+	STAGE_OCCLUSION
+)
+
+type generationStage struct {
+	name string
+	// Stage the neighbours must have completed first
+	neighborsNeed int
+	run           func(chunk *Chunk)
+	// Used instead of run by stages that handle every ready chunk at once
+	runAll func(chunks []*Chunk)
+}
+
+var pipelineStages = [...]generationStage{
+	STAGE_TERRAIN:    {name: "terrain", neighborsNeed: STAGE_NONE, run: runTerrainStage},
+	STAGE_CAVES:      {name: "caves", neighborsNeed: STAGE_NONE, run: runCavesStage},
+	STAGE_STRUCTURES: {name: "structures", neighborsNeed: STAGE_CAVES, run: runStructuresStage},
+	STAGE_DECORATION: {name: "decoration", neighborsNeed: STAGE_STRUCTURES, run: runDecorationStage},
+	STAGE_LIGHTING:   {name: "lighting", neighborsNeed: STAGE_DECORATION, runAll: runLightingStage},
+	STAGE_OCCLUSION:  {name: "occlusion", neighborsNeed: STAGE_LIGHTING, runAll: runOcclusionStage},
+}
+
+// Makes a chunk with the given position part of the world, it'll be
+// generated by the pipeline.
+func requestChunk(pos ChunkCoord) *Chunk {
+	if chnk, ok := chunkMap[pos]; ok {
+		return chnk
+	}
+
+	chunk := newEmptyChunk(pos)
+	chunk.Stage = STAGE_NONE
+	chunkMap[pos] = chunk
+	return chunk
+}
+
+// Positions that aren't part of the world don't hold anything back.
+func neighborsReached(pos ChunkCoord, stage int) bool {
+	for x := -1; x <= 1; x++ {
+		for y := -1; y <= 1; y++ {
+			for z := -1; z <= 1; z++ {
+				if x == 0 && y == 0 && z == 0 {
+					continue
+				}
+				if chnk, ok := chunkMap[ChunkCoord{pos.X + x, pos.Y + y, pos.Z + z}]; ok && chnk.Stage < stage {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// Runs every stage up to maxStage that is ready, stage by stage so one call
+// brings a complete set of requested chunks as far as it can go.
+// Returns the number of stages run.
+func updatePipeline(maxStage int) int {
+	advanced := 0
+
+	for stage := STAGE_TERRAIN; stage <= maxStage && stage < len(pipelineStages); stage++ {
+		ready := []*Chunk{}
+		for pos, chnk := range chunkMap {
+			if chnk.Stage != stage-1 || !neighborsReached(pos, pipelineStages[stage].neighborsNeed) {
+				continue
+			}
+
+			if pipelineStages[stage].runAll != nil {
+				ready = append(ready, chnk)
+				continue
+			}
+			pipelineStages[stage].run(chnk)
+			chnk.Stage = stage
+			advanced++
+		}
+
+		if len(ready) > 0 {
+			pipelineStages[stage].runAll(ready)
+			for _, chnk := range ready {
+				chnk.Stage = stage
+			}
+			advanced += len(ready)
+		}
+	}
+
+	return advanced
+}
+
+func runTerrainStage(chunk *Chunk) {
+	generated := worldGenerator.Terrain(chunk.position)
+	chunk.data = generated.data
+	if worldGenerator.Ores != nil {
+		worldGenerator.Ores.Scatter(chunk)
+	}
+}
+
+func runCavesStage(chunk *Chunk) {
+	if worldGenerator.Caves != nil {
+		worldGenerator.Caves.Carve(chunk)
+	}
+}
+
+func runStructuresStage(chunk *Chunk) {
+	changed := map[ChunkCoord]bool{}
+	for pos := range placeStructures(chunk) {
+		if chnk, ok := chunkMap[pos]; ok && chnk.Stage >= STAGE_OCCLUSION {
+			changed[pos] = true
+		}
+	}
+	refreshChunks(changed)
+}
+
+func runDecorationStage(chunk *Chunk) {
+	if worldGenerator.Decoration != nil {
+		worldGenerator.Decoration(chunk)
+	}
+}
+
+// Sun shadows, ambient light comes from the occlusion stage.
+func runLightingStage(chunks []*Chunk) {
+	bakeShadows(chunks)
+}
+
+func runOcclusionStage(chunks []*Chunk) {
+	bakeOcclusion(chunks)
+	for _, chunk := range chunks {
+		rebuildChunks[chunk.position] = chunk
+		queueShadowsAround(chunk.position)
+	}
+}
+
+// A set of generation passes making up a kind of world.
+type WorldGenerator struct {
+	Name    string
+	Terrain func(pos ChunkCoord) *Chunk
+	// Optional passes, nil skips them
+	Caves      *CaveCarver
+	Ores       *OreDistribution
+	Structures []StructurePlacer
+	// Runs once the chunk's neighbours have their structures
+	Decoration func(chunk *Chunk)
+}
+
+var worldGenerators = map[string]*WorldGenerator{
+	"shapes": {
+		Name:       "shapes",
+		Terrain:    newShapeChunk,
+		Structures: []StructurePlacer{treePlacer},
+	},
+	"terrain": {
+		Name:       "terrain",
+		Terrain:    newHeightmapChunk,
+		Caves:      &defaultCaves,
+		Ores:       &defaultOres,
+		Structures: []StructurePlacer{treePlacer},
+		Decoration: defaultTerrain.Decorate,
+	},
+	"simplex": {
+		Name: "simplex",
+		Terrain: func(pos ChunkCoord) *Chunk {
+			return newSimplexChunk(pos, worldSize, &defaultSimplex)
+		},
+	},
+	"floatingrock": {
+		Name: "floatingrock",
+		Terrain: func(pos ChunkCoord) *Chunk {
+			return newFloatingRockChunk(pos, worldSize, &defaultFloatingRock)
+		},
+	},
+}
+
+var worldGenerator = worldGenerators["shapes"]
+
+// Picks the generator used for new chunks, call before Start.
+func SetGenerator(name string) error {
+	gen, ok := worldGenerators[name]
+	if !ok {
+		return fmt.Errorf("no generator named %q", name)
+	}
+
+	worldGenerator = gen
+	return nil
+}
+
+// Generates the chunks between min and max (inclusive) up to the decoration
+// stage, without touching the renderer. Blocks can then be read with
+// GetWorldBlockType.
+func GenerateRegion(min, max ChunkCoord) {
+	for x := min.X; x <= max.X; x++ {
+		for y := min.Y; y <= max.Y; y++ {
+			for z := min.Z; z <= max.Z; z++ {
+				requestChunk(ChunkCoord{x, y, z})
+			}
+		}
+	}
+	for updatePipeline(STAGE_DECORATION) > 0 {
+	}
+}
+
+// Runs the passes that only depend on the chunk itself, for tools that
+// look at generation without building a world.
+func generateIsolatedChunk(pos ChunkCoord) *Chunk {
+	chunk := newEmptyChunk(pos)
+	runTerrainStage(chunk)
+	runCavesStage(chunk)
+	return chunk
+}
+
+func newShapeChunk(pos ChunkCoord) *Chunk {
+	rnd := rand.New(rand.NewSource(chunkSeed(pos, 0x5348)))
+
+	switch rnd.Intn(6) {
+	case 0:
+		return newCubeChunk(false, rnd)
+	case 1:
+		return newCubeChunk(true, rnd)
+	case 2:
+		return newPyramidChunk(false)
+	case 3:
+		return newPyramidChunk(true)
+	case 4:
+		return newSphereChunk()
+	case 5:
+		return newWireCubeChunk()
+	}
+
+	return newCubeChunk(false, rnd)
+}
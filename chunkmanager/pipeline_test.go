@@ -0,0 +1,98 @@
+package chunkmanager
+
+import "testing"
+
+// Returns: every block of the generated world by world position
+func worldBlocks() map[WorldCoord]BlockType {
+	blocks := map[WorldCoord]BlockType{}
+	for chnkPos, chunk := range chunkMap {
+		for pos, blk := range chunk.data {
+			blocks[WorldCoord{
+				(chnkPos.X * ChunkBase) + pos.X,
+				(chnkPos.Y * ChunkBase) + pos.Y,
+				(chnkPos.Z * ChunkBase) + pos.Z,
+			}] = blk.blockType
+		}
+	}
+
+	return blocks
+}
+
+// Generating a region at once or in two halves has to give the same world,
+// structures straddling the seam included.
+func TestGenerateRegionSplit(t *testing.T) {
+	defer func(gen *WorldGenerator) {
+		worldGenerator = gen
+		resetWorld()
+		SetSeed(0)
+	}(worldGenerator)
+	SetSeed(7)
+
+	for _, name := range []string{"terrain", "shapes"} {
+		if err := SetGenerator(name); err != nil {
+			t.Fatal(err)
+		}
+
+		resetWorld()
+		GenerateRegion(ChunkCoord{0, 0, 0}, ChunkCoord{3, 3, 3})
+		whole := worldBlocks()
+
+		resetWorld()
+		GenerateRegion(ChunkCoord{1, 0, 0}, ChunkCoord{3, 3, 3})
+		GenerateRegion(ChunkCoord{0, 0, 0}, ChunkCoord{0, 3, 3})
+		split := worldBlocks()
+
+		numDiffering := 0
+		for pos, blockType := range whole {
+			if splitType, ok := split[pos]; !ok || splitType != blockType {
+				numDiffering++
+			}
+		}
+		for pos := range split {
+			if _, ok := whole[pos]; !ok {
+				numDiffering++
+			}
+		}
+		if numDiffering > 0 {
+			t.Errorf("%s: %d of %d blocks differ when generated in two halves", name, numDiffering, len(whole))
+		}
+	}
+}
+
+// A chunk may only run a stage once all 26 neighbours, diagonals included,
+// completed the stage's prerequisite.
+func TestStageWaitsForAllNeighbors(t *testing.T) {
+	defer resetWorld()
+
+	center := ChunkCoord{1, 1, 1}
+	for stage := STAGE_TERRAIN; stage < len(pipelineStages); stage++ {
+		need := pipelineStages[stage].neighborsNeed
+		if need == STAGE_NONE {
+			continue
+		}
+
+		resetWorld()
+		for x := 0; x < 3; x++ {
+			for y := 0; y < 3; y++ {
+				for z := 0; z < 3; z++ {
+					requestChunk(ChunkCoord{x, y, z}).Stage = need
+				}
+			}
+		}
+		chunkMap[center].Stage = stage - 1
+
+		if !neighborsReached(center, need) {
+			t.Errorf("%s: held back with every neighbour done", pipelineStages[stage].name)
+		}
+		for pos, chnk := range chunkMap {
+			if pos == center {
+				continue
+			}
+			chnk.Stage = need - 1
+			if neighborsReached(center, need) {
+				t.Errorf("%s: not held back by neighbour %v", pipelineStages[stage].name, pos)
+			}
+			chnk.Stage = need
+		}
+	}
+}
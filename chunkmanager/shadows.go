@@ -0,0 +1,315 @@
+package chunkmanager
+
+import (
+	"bedrock/math/vector"
+	"math"
+	"sort"
+)
+
+// Sun shadows are the share of points on a face with a clear line to the
+// sun, traced through the voxel grid like occlusion rays. They're baked for
+// the direction in sunDir. Once the sun has moved more than
+// SHADOW_REBAKE_ANGLE from it, every chunk is rebaked for the new direction,
+// a few per Update so the sweep doesn't stall a frame.
+
+// Blocks further than this towards the sun don't cast shadows
+const maxShadowDistance = 48.0
+
+// Radians the sun may move before shadows are rebaked
+const SHADOW_REBAKE_ANGLE = 0.035
+
+// Chunks rebaked per Update while the sun moves
+const shadowRebakesPerUpdate = 2
+
+// Direction shadows are baked for and the one the sun is in now, unit
+// vectors pointing at the sun
+var sunDir = vector.Vector3f{0.0, 1.0, 0.0}
+var sunTarget = vector.Vector3f{0.0, 1.0, 0.0}
+
+// Chunks waiting for a full rebake: those still baked for an old sun
+// direction, nearest the camera first, and after them those a refresh
+// couldn't write into or a newly generated chunk may shadow
+var shadowQueue = []ChunkCoord{}
+
+// Chunks whose shadows can reach a block, rounded up
+var shadowChunkReach = int(math.Ceil(maxShadowDistance / float64(ChunkBase)))
+
+func queueShadowRebake(pos ChunkCoord) {
+	for _, queued := range shadowQueue {
+		if queued == pos {
+			return
+		}
+	}
+	shadowQueue = append(shadowQueue, pos)
+}
+
+// Queues the finished chunks a newly generated one may cast shadows on,
+// their bakes didn't see it.
+func queueShadowsAround(chnkPos ChunkCoord) {
+	for x := -shadowChunkReach; x <= shadowChunkReach; x++ {
+		for y := -shadowChunkReach; y <= shadowChunkReach; y++ {
+			for z := -shadowChunkReach; z <= shadowChunkReach; z++ {
+				pos := ChunkCoord{chnkPos.X + x, chnkPos.Y + y, chnkPos.Z + z}
+				if chnk, ok := chunkMap[pos]; ok && chnk.Stage >= STAGE_OCCLUSION {
+					queueShadowRebake(pos)
+				}
+			}
+		}
+	}
+}
+
+// Sets the direction towards the sun. Before Start it's the direction the
+// world is baked for, later shadows follow once it has moved far enough.
+// Call from the goroutine running Update.
+func SetSunDirection(dir vector.Vector3f) {
+	sunTarget = dir
+	if len(chunkMap) == 0 {
+		sunDir = dir
+	}
+}
+
+func angleBetween(a, b vector.Vector3f) float64 {
+	return math.Acos(math.Max(math.Min(vector.DotProduct(a, b), 1.0), -1.0))
+}
+
+// Sample points on a face, a quarter block from its centre along both of
+// its axes, relative to the centre
+func faceShadowSamples(face int) [4]vector.Vector3f {
+	normal := faceOffsets[face]
+	axis := 0
+	for normal[axis] == 0 {
+		axis++
+	}
+
+	samples := [4]vector.Vector3f{}
+	for index, offsets := range [4][2]float64{{-0.25, -0.25}, {0.25, -0.25}, {0.25, 0.25}, {-0.25, 0.25}} {
+		sample := [3]float64{}
+		sample[(axis+1)%3] = offsets[0]
+		sample[(axis+2)%3] = offsets[1]
+		samples[index] = vector.Vector3f{sample[0], sample[1], sample[2]}
+	}
+
+	return samples
+}
+
+// Share of the face's sample points the sun reaches, 0 for faces turned
+// away from it.
+func faceShadow(chnkPos ChunkCoord, blkPos BlockCoord, face int) float64 {
+	normal := chunkNormals[face]
+	if vector.DotProduct(normal, sunDir) <= 0.0 {
+		return 0.0
+	}
+
+	// Centre of the face, nudged out so the first cell is the neighbour
+	center := vector.Vector3f{
+		float64((chnkPos.X*ChunkBase)+blkPos.X) + 0.5 + (normal.X * 0.50001),
+		float64((chnkPos.Y*ChunkBase)+blkPos.Y) + 0.5 + (normal.Y * 0.50001),
+		float64((chnkPos.Z*ChunkBase)+blkPos.Z) + 0.5 + (normal.Z * 0.50001),
+	}
+
+	lit := 0
+	samples := faceShadowSamples(face)
+	for _, sample := range samples {
+		if castVoxelRay(center.Add(sample), sunDir, maxShadowDistance) {
+			lit++
+		}
+	}
+
+	return float64(lit) / float64(len(samples))
+}
+
+func bakeChunkShadows(chunk *Chunk) {
+	for blkPos, block := range chunk.data {
+		for face := 0; face < 6; face++ {
+			block.shadow[face] = faceShadow(chunk.position, blkPos, face)
+		}
+	}
+}
+
+// Bakes the shadows of every block in the chunks on occlusionWorkers
+// goroutines, with the same restrictions as bakeOcclusion.
+func bakeShadows(chunks []*Chunk) {
+	bakeInParallel(chunks, bakeChunkShadows)
+}
+
+// Whether a ray from origin towards the sun, no longer than
+// maxShadowDistance, passes through the box between boxMin and boxMax.
+func sunRayHitsBox(origin, boxMin, boxMax vector.Vector3f) bool {
+	originAxes := [3]float64{origin.X, origin.Y, origin.Z}
+	dirAxes := [3]float64{sunDir.X, sunDir.Y, sunDir.Z}
+	minAxes := [3]float64{boxMin.X, boxMin.Y, boxMin.Z}
+	maxAxes := [3]float64{boxMax.X, boxMax.Y, boxMax.Z}
+
+	near := 0.0
+	far := maxShadowDistance
+	for axis := 0; axis < 3; axis++ {
+		if dirAxes[axis] == 0.0 {
+			if originAxes[axis] < minAxes[axis] || originAxes[axis] > maxAxes[axis] {
+				return false
+			}
+			continue
+		}
+
+		enter := (minAxes[axis] - originAxes[axis]) / dirAxes[axis]
+		exit := (maxAxes[axis] - originAxes[axis]) / dirAxes[axis]
+		if enter > exit {
+			enter, exit = exit, enter
+		}
+		near = math.Max(near, enter)
+		far = math.Min(far, exit)
+		if near > far {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Recalculates the shadows of faces whose sun rays can pass through the
+// changed cells, grouped per chunk like refreshOcclusion, and queues the
+// chunks sharing corners with them for rebuilding.
+func refreshShadows(cells []WorldCoord) {
+	type cellBox struct {
+		min, max WorldCoord
+	}
+	boxes := map[ChunkCoord]*cellBox{}
+	for _, cell := range cells {
+		chnkPos, _ := WorldToChunkBlock(cell.X, cell.Y, cell.Z)
+		if box, ok := boxes[chnkPos]; ok {
+			box.min = WorldCoord{imin(box.min.X, cell.X), imin(box.min.Y, cell.Y), imin(box.min.Z, cell.Z)}
+			box.max = WorldCoord{imax(box.max.X, cell.X), imax(box.max.Y, cell.Y), imax(box.max.Z, cell.Z)}
+		} else {
+			boxes[chnkPos] = &cellBox{cell, cell}
+		}
+	}
+
+	faces := map[WorldCoord][6]bool{}
+	for _, box := range boxes {
+		// Sample points sit up to half a block off the centre of the
+		// face's cell, grow the box so rays passing its edge count
+		boxMin := vector.Vector3f{float64(box.min.X) - 0.5, float64(box.min.Y) - 0.5, float64(box.min.Z) - 0.5}
+		boxMax := vector.Vector3f{float64(box.max.X) + 1.5, float64(box.max.Y) + 1.5, float64(box.max.Z) + 1.5}
+
+		// Faces that can be shadowed by the box are at most
+		// maxShadowDistance away from it, away from the sun
+		sweep := sunDir.MulScalar(-maxShadowDistance)
+		sweepMin := WorldCoord{
+			int(math.Floor(math.Min(boxMin.X, boxMin.X+sweep.X))) - 1,
+			int(math.Floor(math.Min(boxMin.Y, boxMin.Y+sweep.Y))) - 1,
+			int(math.Floor(math.Min(boxMin.Z, boxMin.Z+sweep.Z))) - 1,
+		}
+		sweepMax := WorldCoord{
+			int(math.Ceil(math.Max(boxMax.X, boxMax.X+sweep.X))) + 1,
+			int(math.Ceil(math.Max(boxMax.Y, boxMax.Y+sweep.Y))) + 1,
+			int(math.Ceil(math.Max(boxMax.Z, boxMax.Z+sweep.Z))) + 1,
+		}
+		minChnk, _ := WorldToChunkBlock(sweepMin.X, sweepMin.Y, sweepMin.Z)
+		maxChnk, _ := WorldToChunkBlock(sweepMax.X, sweepMax.Y, sweepMax.Z)
+
+		for cx := minChnk.X; cx <= maxChnk.X; cx++ {
+			for cy := minChnk.Y; cy <= maxChnk.Y; cy++ {
+				for cz := minChnk.Z; cz <= maxChnk.Z; cz++ {
+					chnk, ok := chunkMap[ChunkCoord{cx, cy, cz}]
+					if !ok {
+						continue
+					}
+
+					for blkPos := range chnk.data {
+						pos := WorldCoord{(cx * ChunkBase) + blkPos.X, (cy * ChunkBase) + blkPos.Y, (cz * ChunkBase) + blkPos.Z}
+						if pos.X < sweepMin.X || pos.Y < sweepMin.Y || pos.Z < sweepMin.Z ||
+							pos.X > sweepMax.X || pos.Y > sweepMax.Y || pos.Z > sweepMax.Z {
+							continue
+						}
+
+						for face := 0; face < 6; face++ {
+							normal := chunkNormals[face]
+							if vector.DotProduct(normal, sunDir) <= 0.0 {
+								continue
+							}
+							center := vector.Vector3f{
+								float64(pos.X) + 0.5 + (normal.X * 0.5),
+								float64(pos.Y) + 0.5 + (normal.Y * 0.5),
+								float64(pos.Z) + 0.5 + (normal.Z * 0.5),
+							}
+							if sunRayHitsBox(center, boxMin, boxMax) {
+								affected := faces[pos]
+								affected[face] = true
+								faces[pos] = affected
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	// Blocks placed by the change need all of their faces
+	for _, cell := range cells {
+		if getWorldBlock(cell.X, cell.Y, cell.Z) != nil {
+			faces[cell] = [6]bool{true, true, true, true, true, true}
+		}
+	}
+
+	// Faces can be far outside the chunks the change was checked against,
+	// ones near a rebuild get a full rebake once it's done
+	busy := map[ChunkCoord]bool{}
+	for pos, affected := range faces {
+		chnkPos, blkPos := WorldToChunkBlock(pos.X, pos.Y, pos.Z)
+		chnkBusy, ok := busy[chnkPos]
+		if !ok {
+			chnkBusy = chunkBusy(chnkPos)
+			busy[chnkPos] = chnkBusy
+			if chnkBusy {
+				queueShadowRebake(chnkPos)
+			}
+		}
+		if chnkBusy {
+			continue
+		}
+
+		chnk := chunkMap[chnkPos]
+		blk := chnk.data[blkPos]
+		for face := range affected {
+			if affected[face] {
+				blk.shadow[face] = faceShadow(chnkPos, blkPos, face)
+			}
+		}
+		rebuildChunksSharing(pos)
+	}
+}
+
+// Starts a rebake once the sun has moved far enough, and rebakes the next
+// few queued chunks that aren't being rebuilt. Below the horizon the sun
+// casts nothing, shadows stay as they were until it's up again.
+func updateShadows() {
+	if len(shadowQueue) == 0 {
+		if sunTarget.Y <= 0.0 || angleBetween(sunDir, sunTarget) <= SHADOW_REBAKE_ANGLE {
+			return
+		}
+
+		sunDir = sunTarget
+		for pos, chnk := range chunkMap {
+			if chnk.Stage >= STAGE_OCCLUSION {
+				shadowQueue = append(shadowQueue, pos)
+			}
+		}
+		sort.Sort(sort.Reverse(byDistance{shadowQueue, camPos}))
+	}
+
+	waiting := []ChunkCoord{}
+	numBaked := 0
+	for _, pos := range shadowQueue {
+		chnk, ok := chunkMap[pos]
+		if !ok {
+			continue
+		}
+		if numBaked >= shadowRebakesPerUpdate || chunkBusy(pos) {
+			waiting = append(waiting, pos)
+			continue
+		}
+
+		bakeChunkShadows(chnk)
+		rebuildChunks[pos] = chnk
+		numBaked++
+	}
+	shadowQueue = waiting
+}
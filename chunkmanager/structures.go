@@ -0,0 +1,265 @@
+package chunkmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+)
+
+const (
+	ROTATE_0 int = iota
+	ROTATE_90
+	ROTATE_180
+	ROTATE_270
+)
+
+type StructureBlock struct {
+	Position  BlockCoord
+	BlockType BlockType
+}
+
+// A block template, block positions are relative to the structure origin
+// which is the point placed at the target position.
+type Structure struct {
+	Name   string
+	Origin BlockCoord
+	Blocks []StructureBlock
+}
+
+type StructurePlacement struct {
+	Structure *Structure
+	// World block position of the origin
+	X, Y, Z int
+	// Rotation around Y, one of the ROTATE_* values
+	Rotation int
+	MirrorX  bool
+	MirrorZ  bool
+}
+
+// Picks structures to place in a freshly generated chunk. Placements may
+// reach into neighbouring chunks.
+type StructurePlacer func(chunk *Chunk, rnd *rand.Rand) []StructurePlacement
+
+type pendingWrite struct {
+	position  BlockCoord
+	blockType BlockType
+}
+
+// Blocks written by structures into chunks that weren't generated yet
+var pendingWrites = map[ChunkCoord][]pendingWrite{}
+
+// Placers added to this world on top of those of its generator
+var worldStructurePlacers = []StructurePlacer{}
+
+// Adds a placer to the world, it runs after the generator's own. Call
+// before Start.
+func RegisterStructurePlacer(placer StructurePlacer) {
+	worldStructurePlacers = append(worldStructurePlacers, placer)
+}
+
+type structureFile struct {
+	Name   string
+	Origin [3]int
+	Blocks []struct {
+		Pos  [3]int
+		Type string
+	}
+}
+
+// Loads a structure from a JSON file of the form
+// {"name": "hut", "origin": [x, y, z], "blocks": [{"pos": [x, y, z], "type": "wood"}, ...]}
+func LoadStructureFile(filename string) (*Structure, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data := structureFile{}
+	if err := json.NewDecoder(file).Decode(&data); err != nil {
+		return nil, fmt.Errorf("structure %s: %v", filename, err)
+	}
+
+	structure := &Structure{
+		Name:   data.Name,
+		Origin: BlockCoord{data.Origin[0], data.Origin[1], data.Origin[2]},
+	}
+	for t, blk := range data.Blocks {
+		blockType, ok := BlockTypeByName(blk.Type)
+		if !ok {
+			return nil, fmt.Errorf("structure %s: block %d has unknown type %q", filename, t, blk.Type)
+		}
+		structure.Blocks = append(structure.Blocks, StructureBlock{
+			Position:  BlockCoord{blk.Pos[0], blk.Pos[1], blk.Pos[2]},
+			BlockType: blockType,
+		})
+	}
+
+	return structure, nil
+}
+
+// Turns a .vox model into a structure, voxel colours become block types.
+func NewStructureFromVox(name string, model *VoxModel, origin BlockCoord) (*Structure, error) {
+	structure := &Structure{
+		Name:   name,
+		Origin: origin,
+	}
+	for _, voxel := range model.Voxels {
+		blockType, err := blockTypeForColor(model.Palette[voxel.ColorIndex])
+		if err != nil {
+			return nil, err
+		}
+		structure.Blocks = append(structure.Blocks, StructureBlock{
+			Position:  BlockCoord{int(voxel.X), int(voxel.Z), int(voxel.Y)},
+			BlockType: blockType,
+		})
+	}
+
+	return structure, nil
+}
+
+// Returns the world position of a structure block after mirroring and rotation.
+func (placement *StructurePlacement) blockPosition(pos BlockCoord) (int, int, int) {
+	x := pos.X - placement.Structure.Origin.X
+	y := pos.Y - placement.Structure.Origin.Y
+	z := pos.Z - placement.Structure.Origin.Z
+
+	if placement.MirrorX {
+		x = -x
+	}
+	if placement.MirrorZ {
+		z = -z
+	}
+
+	switch placement.Rotation {
+	case ROTATE_90:
+		x, z = -z, x
+	case ROTATE_180:
+		x, z = -x, -z
+	case ROTATE_270:
+		x, z = z, -x
+	}
+
+	return placement.X + x, placement.Y + y, placement.Z + z
+}
+
+// Leaves only fill empty space or other leaves, so overlapping trees come
+// out the same whichever was placed first and leaves never take away the
+// ground another tree would stand on.
+func structureCanReplace(existing *Block, blockType BlockType) bool {
+	return existing == nil || blockType != BLOCK_LEAVES || existing.blockType == BLOCK_LEAVES
+}
+
+// Writes the structure into the world. Blocks landing in chunks that haven't
+// got past the cave stage, or haven't been requested yet, are kept as
+// pending writes until they do.
+// Returns the chunks that were modified.
+func placeStructure(placement StructurePlacement) map[ChunkCoord]bool {
+	changed := map[ChunkCoord]bool{}
+
+	for _, blk := range placement.Structure.Blocks {
+		chnkPos, blkPos := WorldToChunkBlock(placement.blockPosition(blk.Position))
+		if chnk, ok := chunkMap[chnkPos]; ok && chnk.Stage >= STAGE_CAVES {
+			if !structureCanReplace(chnk.data[blkPos], blk.BlockType) {
+				continue
+			}
+			chnk.data[blkPos] = &Block{
+				visible:   false,
+				position:  blkPos,
+				blockType: blk.BlockType,
+			}
+			changed[chnkPos] = true
+		} else {
+			pendingWrites[chnkPos] = append(pendingWrites[chnkPos], pendingWrite{blkPos, blk.BlockType})
+		}
+	}
+
+	return changed
+}
+
+func applyPendingWrites(chunk *Chunk) {
+	for _, write := range pendingWrites[chunk.position] {
+		if !structureCanReplace(chunk.data[write.position], write.blockType) {
+			continue
+		}
+		chunk.data[write.position] = &Block{
+			visible:   false,
+			position:  write.position,
+			blockType: write.blockType,
+		}
+	}
+	delete(pendingWrites, chunk.position)
+}
+
+// Applies the writes waiting for the chunk and runs the structure placers
+// of the current generator and the world on it.
+// Returns the chunks that were modified.
+func placeStructures(chunk *Chunk) map[ChunkCoord]bool {
+	applyPendingWrites(chunk)
+
+	placers := append(append([]StructurePlacer{}, worldGenerator.Structures...), worldStructurePlacers...)
+	changed := map[ChunkCoord]bool{}
+	rnd := rand.New(rand.NewSource(chunkSeed(chunk.position, 0x5354)))
+	for _, placer := range placers {
+		for _, placement := range placer(chunk, rnd) {
+			for pos := range placeStructure(placement) {
+				changed[pos] = true
+			}
+		}
+	}
+
+	return changed
+}
+
+var treeStructure = &Structure{
+	Name:   "tree",
+	Origin: BlockCoord{2, 0, 2},
+	Blocks: func() []StructureBlock {
+		blocks := []StructureBlock{}
+		for y := 3; y < 6; y++ {
+			for x := 0; x < 5; x++ {
+				for z := 0; z < 5; z++ {
+					if y == 5 && (x == 0 || x == 4 || z == 0 || z == 4) {
+						continue
+					}
+					blocks = append(blocks, StructureBlock{BlockCoord{x, y, z}, BLOCK_LEAVES})
+				}
+			}
+		}
+		for y := 0; y < 5; y++ {
+			blocks = append(blocks, StructureBlock{BlockCoord{2, y, 2}, BLOCK_WOOD})
+		}
+		return blocks
+	}(),
+}
+
+// Plants a few trees on top of open surfaces in the chunk.
+func treePlacer(chunk *Chunk, rnd *rand.Rand) []StructurePlacement {
+	placements := []StructurePlacement{}
+
+	numTrees := rnd.Intn(3)
+	for t := 0; t < numTrees; t++ {
+		x := rnd.Intn(ChunkBase)
+		z := rnd.Intn(ChunkBase)
+		// Trees of neighbouring chunks may or may not be here yet, look
+		// through them for the ground
+		for y := ChunkBase - 1; y >= 0; y-- {
+			if blk, ok := chunk.data[BlockCoord{x, y, z}]; ok {
+				if blk.blockType == BLOCK_LEAVES || blk.blockType == BLOCK_WOOD {
+					continue
+				}
+				placements = append(placements, StructurePlacement{
+					Structure: treeStructure,
+					X:         (chunk.position.X * ChunkBase) + x,
+					Y:         (chunk.position.Y * ChunkBase) + y + 1,
+					Z:         (chunk.position.Z * ChunkBase) + z,
+					Rotation:  rnd.Intn(4),
+				})
+				break
+			}
+		}
+	}
+
+	return placements
+}
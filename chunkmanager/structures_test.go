@@ -0,0 +1,95 @@
+package chunkmanager
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Generates flat stone ground up to y=7 with a structure placed where the
+// placer is told to, in the chunk at the origin only.
+func useStructureTestGenerator(placement StructurePlacement) {
+	worldGenerator = &WorldGenerator{
+		Name: "structure test",
+		Terrain: func(pos ChunkCoord) *Chunk {
+			chunk := newEmptyChunk(pos)
+			if pos.Y != 0 {
+				return chunk
+			}
+			for x := 0; x < ChunkBase; x++ {
+				for y := 0; y < 8; y++ {
+					for z := 0; z < ChunkBase; z++ {
+						chunk.data[BlockCoord{x, y, z}] = &Block{position: BlockCoord{x, y, z}, blockType: BLOCK_STONE}
+					}
+				}
+			}
+			return chunk
+		},
+		Structures: []StructurePlacer{
+			func(chunk *Chunk, rnd *rand.Rand) []StructurePlacement {
+				if chunk.position != (ChunkCoord{}) {
+					return nil
+				}
+				return []StructurePlacement{placement}
+			},
+		},
+	}
+}
+
+// A structure straddling the border into a chunk that isn't part of the
+// world yet has to show up in full once that chunk is requested.
+func TestStructureIntoUnrequestedChunk(t *testing.T) {
+	defer func(gen *WorldGenerator) {
+		worldGenerator = gen
+		resetWorld()
+	}(worldGenerator)
+	resetWorld()
+	placement := StructurePlacement{Structure: treeStructure, X: 15, Y: 8, Z: 8}
+	useStructureTestGenerator(placement)
+
+	GenerateRegion(ChunkCoord{0, 0, 0}, ChunkCoord{0, 0, 0})
+	if len(pendingWrites[ChunkCoord{1, 0, 0}]) == 0 {
+		t.Fatal("no pending writes kept for the unrequested chunk")
+	}
+	GenerateRegion(ChunkCoord{1, 0, 0}, ChunkCoord{1, 0, 0})
+
+	// The trunk goes through the lowest leaves
+	want := map[WorldCoord]BlockType{}
+	for _, blk := range treeStructure.Blocks {
+		x, y, z := placement.blockPosition(blk.Position)
+		want[WorldCoord{x, y, z}] = blk.BlockType
+	}
+	for pos, wantType := range want {
+		if blockType, ok := GetWorldBlockType(pos.X, pos.Y, pos.Z); !ok || blockType != wantType {
+			t.Errorf("block at %v is %v, %v, want %v", pos, blockType, ok, wantType)
+		}
+	}
+	if len(pendingWrites) != 0 {
+		t.Errorf("pending writes left for %d chunks", len(pendingWrites))
+	}
+}
+
+func TestStructureCanReplace(t *testing.T) {
+	tests := []struct {
+		existing  *Block
+		blockType BlockType
+		want      bool
+	}{
+		{nil, BLOCK_LEAVES, true},
+		{nil, BLOCK_WOOD, true},
+		{&Block{blockType: BLOCK_LEAVES}, BLOCK_LEAVES, true},
+		{&Block{blockType: BLOCK_GRASS}, BLOCK_LEAVES, false},
+		{&Block{blockType: BLOCK_WOOD}, BLOCK_LEAVES, false},
+		{&Block{blockType: BLOCK_LEAVES}, BLOCK_WOOD, true},
+		{&Block{blockType: BLOCK_GRASS}, BLOCK_WOOD, true},
+	}
+
+	for _, test := range tests {
+		existing := "nothing"
+		if test.existing != nil {
+			existing = GetBlockTypeInfo(test.existing.blockType).Name
+		}
+		if got := structureCanReplace(test.existing, test.blockType); got != test.want {
+			t.Errorf("%s replacing %s: got %v, want %v", GetBlockTypeInfo(test.blockType).Name, existing, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package chunkmanager
+
+import (
+	"bedrock/math/simplex"
+	"math"
+)
+
+// simplex noise is in the 0..2 range, this moves it to -1..1
+func signedNoiseOctave(octaves int, x, y, z float64) float64 {
+	return simplex.NoiseOctave(octaves, x, y, z) - 1.0
+}
+
+type TerrainLayer struct {
+	Octaves int
+	// Blocks per noise unit, bigger is smoother
+	Scale     float64
+	Amplitude float64
+}
+
+type Biome struct {
+	Name string
+	// Climate the biome is centered on, both -1..1
+	Temperature, Humidity float64
+	Surface               BlockType
+	Subsurface            BlockType
+	SubsurfaceDepth       int
+	// Multiplies the terrain layers, flat biomes use < 1
+	HeightScale float64
+}
+
+type TerrainGenerator struct {
+	BaseHeight       float64
+	Layers           []TerrainLayer
+	TemperatureScale float64
+	HumidityScale    float64
+	// Width of the blend between biomes in climate space
+	BiomeBlend  float64
+	Biomes      []Biome
+	Underground BlockType
+}
+
+var defaultTerrain = TerrainGenerator{
+	BaseHeight: 28.0,
+	Layers: []TerrainLayer{
+		{Octaves: 4, Scale: 96.0, Amplitude: 14.0},
+		{Octaves: 2, Scale: 24.0, Amplitude: 3.0},
+	},
+	TemperatureScale: 256.0,
+	HumidityScale:    192.0,
+	BiomeBlend:       0.15,
+	Biomes: []Biome{
+		{Name: "plains", Temperature: 0.2, Humidity: 0.0, Surface: BLOCK_GRASS, Subsurface: BLOCK_DIRT, SubsurfaceDepth: 3, HeightScale: 0.6},
+		{Name: "forest", Temperature: 0.1, Humidity: 0.6, Surface: BLOCK_GRASS, Subsurface: BLOCK_DIRT, SubsurfaceDepth: 4, HeightScale: 1.0},
+		{Name: "desert", Temperature: 0.8, Humidity: -0.7, Surface: BLOCK_SAND, Subsurface: BLOCK_SAND, SubsurfaceDepth: 5, HeightScale: 0.4},
+		{Name: "tundra", Temperature: -0.7, Humidity: -0.2, Surface: BLOCK_SNOW, Subsurface: BLOCK_DIRT, SubsurfaceDepth: 2, HeightScale: 0.8},
+		{Name: "mountains", Temperature: -0.3, Humidity: 0.4, Surface: BLOCK_STONE, Subsurface: BLOCK_STONE, SubsurfaceDepth: 1, HeightScale: 2.2},
+	},
+	Underground: BLOCK_STONE,
+}
+
+// Offsets the noise lookups so each world seed gets different terrain.
+func (gen *TerrainGenerator) seedOffset(salt int64) float64 {
+	return float64((worldSeed^salt)%4096) * 7.31
+}
+
+// Returns the temperature and humidity at a world column, both -1..1.
+func (gen *TerrainGenerator) Climate(x, z int) (float64, float64) {
+	temperature := signedNoiseOctave(2, float64(x)/gen.TemperatureScale, gen.seedOffset(0x7e), float64(z)/gen.TemperatureScale)
+	humidity := signedNoiseOctave(2, float64(x)/gen.HumidityScale, gen.seedOffset(0x4d), float64(z)/gen.HumidityScale)
+	return temperature, humidity
+}
+
+// Returns the index of the closest biome and the blend weights of all
+// biomes for a climate.
+func (gen *TerrainGenerator) biomeWeights(temperature, humidity float64) (int, []float64) {
+	closest := 0
+	closestDist := math.MaxFloat64
+	dists := make([]float64, len(gen.Biomes))
+	for t, biome := range gen.Biomes {
+		dists[t] = math.Hypot(biome.Temperature-temperature, biome.Humidity-humidity)
+		if dists[t] < closestDist {
+			closestDist = dists[t]
+			closest = t
+		}
+	}
+
+	weights := make([]float64, len(gen.Biomes))
+	total := 0.0
+	for t := range dists {
+		// Only biomes within the blend width of the closest one contribute
+		weights[t] = math.Max(gen.BiomeBlend-(dists[t]-closestDist), 0.0)
+		total += weights[t]
+	}
+	for t := range weights {
+		if total > 0.0 {
+			weights[t] /= total
+		} else if t == closest {
+			weights[t] = 1.0
+		}
+	}
+
+	return closest, weights
+}
+
+// Returns the biome closest to the climate of a world column.
+func (gen *TerrainGenerator) BiomeAt(x, z int) *Biome {
+	temperature, humidity := gen.Climate(x, z)
+	biome, _ := gen.biomeWeights(temperature, humidity)
+	return &gen.Biomes[biome]
+}
+
+// Returns the terrain height and the biome of a world column.
+func (gen *TerrainGenerator) Column(x, z int) (int, *Biome) {
+	temperature, humidity := gen.Climate(x, z)
+	biome, weights := gen.biomeWeights(temperature, humidity)
+
+	heightScale := 0.0
+	for t := range weights {
+		heightScale += gen.Biomes[t].HeightScale * weights[t]
+	}
+
+	height := 0.0
+	for t, layer := range gen.Layers {
+		noise := signedNoiseOctave(layer.Octaves, float64(x)/layer.Scale, gen.seedOffset(int64(t)), float64(z)/layer.Scale)
+		height += noise * layer.Amplitude
+	}
+
+	return int(math.Floor(gen.BaseHeight + (height * heightScale))), &gen.Biomes[biome]
+}
+
+func (gen *TerrainGenerator) NewChunk(pos ChunkCoord) *Chunk {
+	chunk := newEmptyChunk(pos)
+
+	for x := 0; x < ChunkBase; x++ {
+		for z := 0; z < ChunkBase; z++ {
+			height, biome := gen.Column((pos.X*ChunkBase)+x, (pos.Z*ChunkBase)+z)
+
+			for y := 0; y < ChunkBase; y++ {
+				worldY := (pos.Y * ChunkBase) + y
+				if worldY > height {
+					break
+				}
+
+				blockType := gen.Underground
+				if worldY == height {
+					blockType = biome.Surface
+				} else if worldY > height-biome.SubsurfaceDepth {
+					blockType = biome.Subsurface
+				}
+
+				index := BlockCoord{x, y, z}
+				chunk.data[index] = &Block{
+					visible:   false,
+					position:  index,
+					blockType: blockType,
+				}
+			}
+		}
+	}
+
+	return chunk
+}
+
+// Grows the biome surface on subsurface blocks open to the sky, where caves
+// broke through, and turns it back into subsurface where something was
+// built on top.
+func (gen *TerrainGenerator) Decorate(chunk *Chunk) {
+	base := BlockCoord{chunk.position.X * ChunkBase, chunk.position.Y * ChunkBase, chunk.position.Z * ChunkBase}
+	biomes := [ChunkBase][ChunkBase]*Biome{}
+	for pos, blk := range chunk.data {
+		biome := biomes[pos.X][pos.Z]
+		if biome == nil {
+			biome = gen.BiomeAt(base.X+pos.X, base.Z+pos.Z)
+			biomes[pos.X][pos.Z] = biome
+		}
+		if biome.Surface == biome.Subsurface {
+			continue
+		}
+
+		covered := getWorldBlock(base.X+pos.X, base.Y+pos.Y+1, base.Z+pos.Z) != nil
+		if blk.blockType == biome.Subsurface && !covered {
+			blk.blockType = biome.Surface
+		} else if blk.blockType == biome.Surface && covered {
+			blk.blockType = biome.Subsurface
+		}
+	}
+}
+
+func newHeightmapChunk(pos ChunkCoord) *Chunk {
+	return defaultTerrain.NewChunk(pos)
+}
@@ -0,0 +1,49 @@
+package chunkmanager
+
+import (
+	"dwelling/renderer"
+	"fmt"
+	"strings"
+)
+
+var textureAtlas *renderer.TextureAtlas
+
+// Textures chunk faces from the atlas by the Textures of their block type,
+// nil goes back to untextured. Call before Start, meshes built earlier keep
+// their old tiles.
+// Returns an error naming the textures the atlas lacks, those faces are
+// drawn untextured.
+func SetTextureAtlas(atlas *renderer.TextureAtlas) error {
+	textureAtlas = atlas
+	renderer.Current().SetTextureAtlas(atlas)
+	if atlas == nil {
+		return nil
+	}
+
+	missing := []string{}
+	for _, info := range allBlockTypes() {
+		for _, name := range info.Textures {
+			if _, ok := atlas.Tile(name); name != "" && !ok {
+				missing = append(missing, fmt.Sprintf("%s (%s)", name, info.Name))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("textures: atlas has no tile for %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+func faceTile(blockType BlockType, face int) int {
+	if textureAtlas == nil {
+		return 0
+	}
+
+	tile, ok := textureAtlas.Tile(GetBlockTypeInfo(blockType).Textures[face])
+	if !ok {
+		tile, _ = textureAtlas.Tile(renderer.BLANK_TILE)
+	}
+
+	return tile
+}
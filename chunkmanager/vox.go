@@ -0,0 +1,351 @@
+package chunkmanager
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Reader/writer for MagicaVoxel .vox files.
+// Only the SIZE, XYZI and RGBA chunks are used, anything else is skipped.
+// MagicaVoxel is Z-up, so vox (x, y, z) maps to world (x, z, y).
+
+const voxVersion int32 = 150
+const voxMaxSize int = 256
+
+type Voxel struct {
+	X, Y, Z    uint8
+	ColorIndex uint8
+}
+
+type VoxModel struct {
+	SizeX, SizeY, SizeZ int
+	Voxels              []Voxel
+	// Palette[i] is the colour of ColorIndex i, 0 is unused
+	Palette [256]color.RGBA
+}
+
+// The palette MagicaVoxel uses when a file has no RGBA chunk. A 6x6x6 colour
+// cube (minus black) followed by red, green, blue and grey ramps.
+func defaultVoxPalette() [256]color.RGBA {
+	palette := [256]color.RGBA{}
+
+	steps := [6]uint8{0xff, 0xcc, 0x99, 0x66, 0x33, 0x00}
+	index := 1
+	for _, r := range steps {
+		for _, g := range steps {
+			for _, b := range steps {
+				if r == 0 && g == 0 && b == 0 {
+					continue
+				}
+				palette[index] = color.RGBA{r, g, b, 0xff}
+				index++
+			}
+		}
+	}
+
+	ramp := [10]uint8{0xee, 0xdd, 0xbb, 0xaa, 0x88, 0x77, 0x55, 0x44, 0x22, 0x11}
+	for _, v := range ramp {
+		palette[index] = color.RGBA{v, 0, 0, 0xff}
+		index++
+	}
+	for _, v := range ramp {
+		palette[index] = color.RGBA{0, v, 0, 0xff}
+		index++
+	}
+	for _, v := range ramp {
+		palette[index] = color.RGBA{0, 0, v, 0xff}
+		index++
+	}
+	for _, v := range ramp {
+		palette[index] = color.RGBA{v, v, v, 0xff}
+		index++
+	}
+
+	return palette
+}
+
+func LoadVoxFile(filename string) (*VoxModel, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadVox(bufio.NewReader(file))
+}
+
+func SaveVoxFile(filename string, model *VoxModel) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	if err := WriteVox(writer, model); err != nil {
+		file.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func readVoxChunkHeader(r io.Reader) (string, int32, int32, error) {
+	header := struct {
+		Id           [4]byte
+		ContentSize  int32
+		ChildrenSize int32
+	}{}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return "", 0, 0, err
+	}
+	if header.ContentSize < 0 || header.ChildrenSize < 0 {
+		return "", 0, 0, fmt.Errorf("vox: chunk %q has negative size", string(header.Id[:]))
+	}
+
+	return string(header.Id[:]), header.ContentSize, header.ChildrenSize, nil
+}
+
+// Reads the first model of a .vox file.
+func ReadVox(r io.Reader) (*VoxModel, error) {
+	magic := [4]byte{}
+	var version int32
+	if err := binary.Read(r, binary.LittleEndian, &magic); err != nil {
+		return nil, err
+	}
+	if string(magic[:]) != "VOX " {
+		return nil, errors.New("vox: not a .vox file")
+	}
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, err
+	}
+
+	id, contentSize, childrenSize, err := readVoxChunkHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if id != "MAIN" {
+		return nil, fmt.Errorf("vox: expected MAIN chunk, got %q", id)
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, int64(contentSize)); err != nil {
+		return nil, err
+	}
+
+	model := &VoxModel{
+		Palette: defaultVoxPalette(),
+	}
+	foundSize, foundVoxels := false, false
+	children := io.LimitReader(r, int64(childrenSize))
+	for {
+		id, contentSize, childrenSize, err := readVoxChunkHeader(children)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		content := io.LimitReader(children, int64(contentSize))
+
+		switch id {
+		case "SIZE":
+			// Only the first model is read
+			if foundSize {
+				break
+			}
+			size := [3]int32{}
+			if err := binary.Read(content, binary.LittleEndian, &size); err != nil {
+				return nil, fmt.Errorf("vox: bad SIZE chunk: %v", err)
+			}
+			model.SizeX, model.SizeY, model.SizeZ = int(size[0]), int(size[1]), int(size[2])
+			foundSize = true
+		case "XYZI":
+			if foundVoxels {
+				break
+			}
+			var numVoxels int32
+			if err := binary.Read(content, binary.LittleEndian, &numVoxels); err != nil {
+				return nil, fmt.Errorf("vox: bad XYZI chunk: %v", err)
+			}
+			if numVoxels < 0 || int64(numVoxels)*4 > int64(contentSize)-4 {
+				return nil, fmt.Errorf("vox: XYZI chunk claims %d voxels", numVoxels)
+			}
+			model.Voxels = make([]Voxel, numVoxels)
+			if err := binary.Read(content, binary.LittleEndian, model.Voxels); err != nil {
+				return nil, fmt.Errorf("vox: bad XYZI chunk: %v", err)
+			}
+			foundVoxels = true
+		case "RGBA":
+			rgba := [256][4]uint8{}
+			if err := binary.Read(content, binary.LittleEndian, &rgba); err != nil {
+				return nil, fmt.Errorf("vox: bad RGBA chunk: %v", err)
+			}
+			// Entry t describes colour index t+1
+			for t := 0; t < 255; t++ {
+				model.Palette[t+1] = color.RGBA{rgba[t][0], rgba[t][1], rgba[t][2], rgba[t][3]}
+			}
+		}
+
+		if _, err := io.Copy(ioutil.Discard, content); err != nil {
+			return nil, err
+		}
+		if _, err := io.CopyN(ioutil.Discard, children, int64(childrenSize)); err != nil {
+			return nil, err
+		}
+	}
+
+	if !foundSize || !foundVoxels {
+		return nil, errors.New("vox: missing SIZE or XYZI chunk")
+	}
+	for _, voxel := range model.Voxels {
+		if int(voxel.X) >= model.SizeX || int(voxel.Y) >= model.SizeY || int(voxel.Z) >= model.SizeZ {
+			return nil, fmt.Errorf("vox: voxel %v outside model size %dx%dx%d", voxel, model.SizeX, model.SizeY, model.SizeZ)
+		}
+	}
+
+	return model, nil
+}
+
+func WriteVox(w io.Writer, model *VoxModel) error {
+	if model.SizeX > voxMaxSize || model.SizeY > voxMaxSize || model.SizeZ > voxMaxSize {
+		return fmt.Errorf("vox: model size %dx%dx%d exceeds %d", model.SizeX, model.SizeY, model.SizeZ, voxMaxSize)
+	}
+
+	sizeContent := [3]int32{int32(model.SizeX), int32(model.SizeY), int32(model.SizeZ)}
+	xyziSize := int32(4 + len(model.Voxels)*4)
+	rgba := [256][4]uint8{}
+	for t := 0; t < 255; t++ {
+		col := model.Palette[t+1]
+		rgba[t] = [4]uint8{col.R, col.G, col.B, col.A}
+	}
+
+	chunkHeaderSize := int32(12)
+	childrenSize := (chunkHeaderSize + 12) + (chunkHeaderSize + xyziSize) + (chunkHeaderSize + 256*4)
+
+	data := []interface{}{
+		[4]byte{'V', 'O', 'X', ' '}, voxVersion,
+		[4]byte{'M', 'A', 'I', 'N'}, int32(0), childrenSize,
+		[4]byte{'S', 'I', 'Z', 'E'}, int32(12), int32(0), sizeContent,
+		[4]byte{'X', 'Y', 'Z', 'I'}, xyziSize, int32(0), int32(len(model.Voxels)), model.Voxels,
+		[4]byte{'R', 'G', 'B', 'A'}, int32(256 * 4), int32(0), rgba,
+	}
+	for _, value := range data {
+		if err := binary.Write(w, binary.LittleEndian, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Queues placing the model in the world with its minimum corner at the
+// given world block position, safe to call from any goroutine. Chunks that
+// don't exist yet are created. Stamps aren't part of the undo history.
+// Palette colours are matched to block types, registering new ones, when
+// the stamp is applied, a full registry is reported then.
+func StampVoxModel(model *VoxModel, x, y, z int) error {
+	tx := NewTransaction("stamp")
+	tx.expand = func(tx *Transaction) {
+		blockTypesUsed := map[uint8]BlockType{}
+		for _, voxel := range model.Voxels {
+			if _, ok := blockTypesUsed[voxel.ColorIndex]; ok {
+				continue
+			}
+			blockType, err := blockTypeForColor(model.Palette[voxel.ColorIndex])
+			if err != nil {
+				fmt.Printf("vox: can't stamp model: %v\n", err)
+				return
+			}
+			blockTypesUsed[voxel.ColorIndex] = blockType
+		}
+
+		for _, voxel := range model.Voxels {
+			tx.SetBlock(x+int(voxel.X), y+int(voxel.Z), z+int(voxel.Y), blockTypesUsed[voxel.ColorIndex])
+		}
+	}
+
+	return queueEditRequest(editRequest{EDIT_STAMP, tx})
+}
+
+// Stamps refresh the chunks next to the ones they write, and rebuilds read
+// a chunk further still.
+func stampBusy(tx *Transaction) bool {
+	checked := map[ChunkCoord]bool{}
+	for _, edit := range tx.Edits {
+		chnkPos, _ := WorldToChunkBlock(edit.Pos.X, edit.Pos.Y, edit.Pos.Z)
+		if checked[chnkPos] {
+			continue
+		}
+		checked[chnkPos] = true
+		if rebuildingNear(chnkPos, 2) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func runStamp(tx *Transaction) {
+	changed := map[ChunkCoord]bool{}
+	for _, edit := range tx.Edits {
+		chnkPos := setWorldBlock(edit.Pos.X, edit.Pos.Y, edit.Pos.Z, edit.New.Type)
+		changed[chnkPos] = true
+	}
+	refreshChunks(changed)
+}
+
+// Exports the blocks between the world block positions min and max
+// (inclusive) as a model, with a palette built from the block type colours.
+func ExportVoxRegion(min, max BlockCoord) (*VoxModel, error) {
+	model := &VoxModel{
+		SizeX: max.X - min.X + 1,
+		SizeY: max.Z - min.Z + 1,
+		SizeZ: max.Y - min.Y + 1,
+	}
+	if model.SizeX <= 0 || model.SizeY <= 0 || model.SizeZ <= 0 {
+		return nil, fmt.Errorf("vox: empty export region %v - %v", min, max)
+	}
+	if model.SizeX > voxMaxSize || model.SizeY > voxMaxSize || model.SizeZ > voxMaxSize {
+		return nil, fmt.Errorf("vox: export region %v - %v larger than %d", min, max, voxMaxSize)
+	}
+
+	colorIndices := map[BlockType]uint8{}
+	numColors := 0
+	for y := min.Y; y <= max.Y; y++ {
+		for z := min.Z; z <= max.Z; z++ {
+			for x := min.X; x <= max.X; x++ {
+				blk := getWorldBlock(x, y, z)
+				if blk == nil {
+					continue
+				}
+
+				colorIndex, ok := colorIndices[blk.blockType]
+				if !ok {
+					if numColors >= 255 {
+						return nil, errors.New("vox: region uses more than 255 block types")
+					}
+					numColors++
+					colorIndex = uint8(numColors)
+					colorIndices[blk.blockType] = colorIndex
+					model.Palette[colorIndex] = GetBlockTypeInfo(blk.blockType).Color
+				}
+
+				model.Voxels = append(model.Voxels, Voxel{
+					X:          uint8(x - min.X),
+					Y:          uint8(z - min.Z),
+					Z:          uint8(y - min.Y),
+					ColorIndex: colorIndex,
+				})
+			}
+		}
+	}
+
+	return model, nil
+}
@@ -0,0 +1,101 @@
+package chunkmanager
+
+import (
+	"bytes"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// testdata/model.vox is a 3x2x4 model with three voxels, an RGBA chunk
+// where entry t is (t, 255-t, 3t, 255) and a MATL chunk the reader skips.
+func TestReadVoxFixture(t *testing.T) {
+	model, err := LoadVoxFile("testdata/model.vox")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if model.SizeX != 3 || model.SizeY != 2 || model.SizeZ != 4 {
+		t.Errorf("size %dx%dx%d, want 3x2x4", model.SizeX, model.SizeY, model.SizeZ)
+	}
+	voxels := []Voxel{{0, 0, 0, 1}, {2, 1, 3, 2}, {1, 0, 2, 255}}
+	if !reflect.DeepEqual(model.Voxels, voxels) {
+		t.Errorf("voxels %v, want %v", model.Voxels, voxels)
+	}
+
+	if model.Palette[0] != (color.RGBA{}) {
+		t.Errorf("palette 0 is %v, want it unused", model.Palette[0])
+	}
+	for index := 1; index < 256; index++ {
+		entry := index - 1
+		want := color.RGBA{uint8(entry), uint8(255 - entry), uint8(entry * 3), 255}
+		if model.Palette[index] != want {
+			t.Fatalf("palette %d is %v, want %v", index, model.Palette[index], want)
+		}
+	}
+}
+
+func TestVoxRoundTrip(t *testing.T) {
+	model, err := LoadVoxFile("testdata/model.vox")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := WriteVox(buffer, model); err != nil {
+		t.Fatal(err)
+	}
+	written, err := ReadVox(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(written, model) {
+		t.Errorf("model changed writing and reading it back:\n%+v\n%+v", written, model)
+	}
+}
+
+func TestReadVoxDefaultPalette(t *testing.T) {
+	model := &VoxModel{
+		SizeX:  1,
+		SizeY:  1,
+		SizeZ:  1,
+		Voxels: []Voxel{{0, 0, 0, 1}},
+	}
+	buffer := &bytes.Buffer{}
+	if err := WriteVox(buffer, model); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut the RGBA chunk off and fix up the size of MAIN's children
+	data := buffer.Bytes()
+	data = data[:len(data)-(12+256*4)]
+	childrenSize := len(data) - 20
+	data[16], data[17], data[18], data[19] = byte(childrenSize), byte(childrenSize>>8), 0, 0
+
+	read, err := ReadVox(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Palette != defaultVoxPalette() {
+		t.Error("model without an RGBA chunk doesn't use the default palette")
+	}
+	if read.Palette[1] != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("default palette 1 is %v, want white", read.Palette[1])
+	}
+}
+
+func TestReadVoxRejectsVoxelOutside(t *testing.T) {
+	model := &VoxModel{
+		SizeX:  2,
+		SizeY:  2,
+		SizeZ:  2,
+		Voxels: []Voxel{{1, 2, 1, 1}},
+	}
+	buffer := &bytes.Buffer{}
+	if err := WriteVox(buffer, model); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadVox(buffer); err == nil {
+		t.Error("voxel outside the model size was accepted")
+	}
+}
@@ -0,0 +1,117 @@
+package clock
+
+import (
+	"fmt"
+	"math"
+)
+
+// World time of day. The clock advances once per logic tick in Update, on
+// the goroutine that renders. Other goroutines change it through requests
+// that are applied on the next Update.
+
+// Logic ticks in a day, at 16ms a tick a day lasts 20 minutes
+const TICKS_PER_DAY = 75000
+
+const (
+	CLOCK_SET int = iota
+	CLOCK_SKIP
+	CLOCK_FREEZE
+	CLOCK_TOGGLE_FREEZE
+)
+
+type clockRequest struct {
+	kind   int
+	hours  float64
+	frozen bool
+}
+
+var hours = 12.0
+var frozen = false
+
+var clockRequests = make(chan clockRequest, 16)
+
+func queueRequest(request clockRequest) error {
+	select {
+	case clockRequests <- request:
+		return nil
+	default:
+		return fmt.Errorf("clock: too many requests queued")
+	}
+}
+
+// Queues setting the time of day in hours, wrapped to [0, 24)
+func SetTime(hours float64) error {
+	return queueRequest(clockRequest{kind: CLOCK_SET, hours: hours})
+}
+
+// Queues moving the time of day, negative hours go back
+func Skip(hours float64) error {
+	return queueRequest(clockRequest{kind: CLOCK_SKIP, hours: hours})
+}
+
+// Queues stopping or restarting the clock
+func Freeze(frozen bool) error {
+	return queueRequest(clockRequest{kind: CLOCK_FREEZE, frozen: frozen})
+}
+
+func ToggleFreeze() error {
+	return queueRequest(clockRequest{kind: CLOCK_TOGGLE_FREEZE})
+}
+
+func wrapHours(value float64) float64 {
+	value = math.Mod(value, 24.0)
+	if value < 0.0 {
+		value += 24.0
+	}
+	return value
+}
+
+// Applies queued requests and, unless frozen, advances the clock one tick.
+// Only call from the goroutine that reads the time.
+func Update() {
+	changed := false
+	for pending := true; pending; {
+		select {
+		case request := <-clockRequests:
+			switch request.kind {
+			case CLOCK_SET:
+				hours = wrapHours(request.hours)
+			case CLOCK_SKIP:
+				hours = wrapHours(hours + request.hours)
+			case CLOCK_FREEZE:
+				frozen = request.frozen
+			case CLOCK_TOGGLE_FREEZE:
+				frozen = !frozen
+			}
+			changed = true
+		default:
+			pending = false
+		}
+	}
+	if changed {
+		fmt.Printf("Time: %s\n", Format())
+	}
+
+	if !frozen {
+		hours = wrapHours(hours + (24.0 / TICKS_PER_DAY))
+	}
+}
+
+// Time of day in hours, from 0 up to 24
+func Hours() float64 {
+	return hours
+}
+
+func Frozen() bool {
+	return frozen
+}
+
+// Returns: the time as hh:mm, marked when frozen
+func Format() string {
+	minutes := int(hours * 60.0)
+	text := fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+	if frozen {
+		text += " (frozen)"
+	}
+	return text
+}
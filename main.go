@@ -2,19 +2,72 @@ package main
 
 import (
 	"bedrock"
+	"bedrock/math/vector"
 	"dwelling/camera"
 	"dwelling/chunkmanager"
+	"dwelling/clock"
+	"dwelling/renderer"
+	"dwelling/renderer/glbackend"
+	"flag"
 	"fmt"
 	gl "github.com/chsc/gogl/gl33"
 	"github.com/jteeuwen/glfw"
 	"math"
+	"os"
 	"runtime"
+	"strconv"
+	"strings"
 	"time"
 )
 
 var cam = camera.Camera{}
 
 func main() {
+	seed := flag.Int64("seed", 0, "world seed, 0 picks one from the clock")
+	generator := flag.String("generator", "shapes", "generator name or path to a generator .json file")
+	textures := flag.String("textures", "textures", "directory of block texture PNGs, empty for untextured blocks")
+	headless := flag.String("headless", "", "render a single frame offscreen to this PNG and exit")
+	width := flag.Int("width", 1280, "headless render width")
+	height := flag.Int("height", 720, "headless render height")
+	camPos := flag.String("campos", "", "headless camera position as x,y,z")
+	camRot := flag.String("camrot", "", "headless camera rotation in degrees as x,y,z")
+	compare := flag.String("compare", "", "reference PNG to compare the headless render against")
+	tolerance := flag.Int("tolerance", 2, "per channel difference ignored by -compare")
+	environments := flag.String("environments", "environments", "directory of .sh9 lighting environments, loaded after the built in ones")
+	environment := flag.String("environment", "beach", "lighting environment to start with")
+	timeOfDay := flag.Float64("time", 10.0, "time of day in hours to start at")
+	freezeTime := flag.Bool("freezetime", false, "keep the time of day from advancing")
+	viewDistance := flag.Int("viewdistance", 12, "how far chunks are drawn, in chunks")
+	flag.Parse()
+
+	if err := chunkmanager.SetViewDistance(*viewDistance); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err := clock.SetTime(*timeOfDay); err != nil {
+		fmt.Println(err)
+	}
+	if err := clock.Freeze(*freezeTime); err != nil {
+		fmt.Println(err)
+	}
+	// The world is baked with shadows for the starting time
+	chunkmanager.SetSunDirection(renderer.DaylightAt(*timeOfDay).SunDir)
+
+	env, err := loadEnvironments(*environments, *environment)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *headless != "" {
+		if err := renderHeadless(*headless, *seed, *generator, *textures, env, *width, *height, *camPos, *camRot, *compare, *tolerance); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	runtime.LockOSThread()
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	fmt.Printf("Using %d cpus for concurrency\n", runtime.NumCPU())
@@ -24,12 +77,20 @@ func main() {
 		return
 	}
 
-	if err := cam.Init(); err != nil {
+	renderer.SetBackend(glbackend.New())
+	if err := renderer.Current().SetUp(); err != nil {
 		fmt.Println(err)
 		return
 	}
+	renderer.Current().SetEnvironment(env)
 
-	if err := chunkmanager.Start(); err != nil {
+	if err := cam.InitViewport(bedrock.ScreenWidth, bedrock.ScreenHeight); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	loadTextures(*textures)
+	if err := setUpWorld(*seed, *generator); err != nil {
 		fmt.Println(err)
 		return
 	}
@@ -38,15 +99,19 @@ func main() {
 	debugCh := make(chan bool)
 	logicCh := make(chan bool)
 	exitCh := make(chan bool)
-	go logicLoop(camCh, debugCh, logicCh, exitCh, &cam)
+	screenshotCh := make(chan bool)
+	environmentCh := make(chan bool)
+	go logicLoop(camCh, debugCh, logicCh, exitCh, screenshotCh, environmentCh, &cam)
 
-	gl.ClearColor(0.8, 0.8, 0.8, 1.0)
+	renderer.SetDaylight(renderer.DaylightAt(*timeOfDay))
 	currentTick := time.Now().UnixNano() / 1e6
 	frameCount := 0
 	debugMode := false
+	screenshot := false
 	running := true
 	for glfw.WindowParam(glfw.Opened) == 1 && running {
-		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+		renderer.Current().Clear()
+		renderer.Current().DrawSky(cam.ViewMatrix, cam.ProjectionMatrix)
 
 		select {
 		case <-camCh:
@@ -65,9 +130,18 @@ func main() {
 				chunkmanager.SetDebug(false)
 			}
 		case <-logicCh:
+			clock.Update()
+			daylight := renderer.DaylightAt(clock.Hours())
+			renderer.SetDaylight(daylight)
+			chunkmanager.SetSunDirection(daylight.SunDir)
 			chunkmanager.Update(&cam)
 		case <-exitCh:
 			running = false
+		case screenshot = <-screenshotCh:
+		case <-environmentCh:
+			env = nextEnvironment(env)
+			renderer.Current().SetEnvironment(env)
+			fmt.Printf("Environment: %s\n", env.Name)
 		default:
 		}
 
@@ -77,6 +151,16 @@ func main() {
 			cam.RenderDebugMeshes()
 		}
 
+		if screenshot {
+			screenshot = false
+			filename := renderer.ScreenshotFilename("screenshots")
+			if err := renderer.SavePNG(filename, renderer.Current().ReadPixels()); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Printf("Saved screenshot %s\n", filename)
+			}
+		}
+
 		if err := gl.GetError(); err != 0 {
 			fmt.Printf("Err: %d\n", err)
 			break
@@ -95,16 +179,261 @@ func main() {
 		runtime.Gosched()
 	}
 
+	// How much of the mesh pages a whole session left in use and how
+	// fragmented they got
+	fmt.Println(renderer.Current().MeshMemory())
+	renderer.Current().Cleanup()
 	bedrock.Cleanup()
 }
 
-func logicLoop(camCh chan<- bool, debugCh chan<- bool, logicCh chan<- bool, exitCh chan<- bool, cam *camera.Camera) {
+func setUpWorld(seed int64, generator string) error {
+	chunkmanager.SetSeed(seed)
+	if strings.HasSuffix(generator, ".json") {
+		gen, err := chunkmanager.LoadGeneratorFile(generator)
+		if err != nil {
+			return err
+		}
+		generator = gen.Name
+	}
+	if err := chunkmanager.SetGenerator(generator); err != nil {
+		return err
+	}
+
+	return chunkmanager.Start()
+}
+
+// Missing or broken textures aren't fatal, blocks are drawn untextured.
+func loadTextures(dir string) {
+	if dir == "" {
+		return
+	}
+
+	atlas, err := renderer.LoadTextureAtlas(dir, 16)
+	if err != nil {
+		fmt.Printf("Textures disabled: %v\n", err)
+		return
+	}
+	if err := chunkmanager.SetTextureAtlas(atlas); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Missing environments directories aren't fatal, the built in ones remain.
+// Returns: the environment called name
+func loadEnvironments(dir, name string) (*renderer.Environment, error) {
+	if dir != "" {
+		if err := renderer.LoadEnvironments(dir); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return renderer.FindEnvironment(name)
+}
+
+// Returns: the environment after env, wrapping around
+func nextEnvironment(env *renderer.Environment) *renderer.Environment {
+	envs := renderer.Environments()
+	for t, other := range envs {
+		if other == env {
+			return envs[(t+1)%len(envs)]
+		}
+	}
+
+	return envs[0]
+}
+
+func parseVector(value string) (vector.Vector3f, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 3 {
+		return vector.Vector3f{}, fmt.Errorf("expected x,y,z, got %q", value)
+	}
+
+	var values [3]float64
+	for t, part := range parts {
+		var err error
+		values[t], err = strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return vector.Vector3f{}, fmt.Errorf("expected x,y,z, got %q", value)
+		}
+	}
+
+	return vector.Vector3f{X: values[0], Y: values[1], Z: values[2]}, nil
+}
+
+// Renders the world from a fixed camera pose with the software backend, no
+// window or GPU needed. Same seed, generator and pose give the same image.
+func renderHeadless(filename string, seed int64, generator, textures string, env *renderer.Environment, width, height int, camPos, camRot, compare string, tolerance int) error {
+	if seed == 0 {
+		return fmt.Errorf("headless rendering needs a -seed to be reproducible")
+	}
+	if tolerance < 0 || tolerance > 255 {
+		return fmt.Errorf("-tolerance must be between 0 and 255, got %d", tolerance)
+	}
+
+	backend := renderer.NewSoftBackend(width, height)
+	backend.SetEnvironment(env)
+	renderer.SetBackend(backend)
+
+	if err := cam.InitViewport(width, height); err != nil {
+		return err
+	}
+	if camPos != "" {
+		pos, err := parseVector(camPos)
+		if err != nil {
+			return fmt.Errorf("-campos: %v", err)
+		}
+		cam.Pos = pos
+	}
+	if camRot != "" {
+		rot, err := parseVector(camRot)
+		if err != nil {
+			return fmt.Errorf("-camrot: %v", err)
+		}
+		cam.Rot = rot
+	}
+	cam.UpdateViewMatrix()
+	cam.UpdatePVMatrix()
+	cam.UpdateFrustum()
+	cam.CullPos = cam.Pos
+
+	loadTextures(textures)
+	if err := setUpWorld(seed, generator); err != nil {
+		return err
+	}
+	chunkmanager.Flush(&cam)
+
+	// A single frame, the clock stays where -time put it
+	if err := clock.Freeze(true); err != nil {
+		return err
+	}
+	clock.Update()
+	renderer.SetDaylight(renderer.DaylightAt(clock.Hours()))
+	backend.Clear()
+	backend.DrawSky(cam.ViewMatrix, cam.ProjectionMatrix)
+	chunkmanager.Render(&cam)
+
+	frame := backend.ReadPixels()
+	if err := renderer.SavePNG(filename, frame); err != nil {
+		return err
+	}
+	fmt.Printf("Saved %s\n", filename)
+
+	if compare != "" {
+		reference, err := renderer.LoadPNG(compare)
+		if err != nil {
+			return err
+		}
+
+		numDiffering, diff, err := renderer.DiffImages(frame, reference, uint8(tolerance))
+		if err != nil {
+			return err
+		}
+		if numDiffering > 0 {
+			diffFilename := strings.TrimSuffix(filename, ".png") + "_diff.png"
+			if err := renderer.SavePNG(diffFilename, diff); err != nil {
+				return err
+			}
+			return fmt.Errorf("%d pixels differ from %s, see %s", numDiffering, compare, diffFilename)
+		}
+		fmt.Printf("Matches %s\n", compare)
+	}
+
+	return nil
+}
+
+const (
+	TOOL_BLOCK int = iota
+	TOOL_BOX
+	TOOL_SPHERE
+	TOOL_LINE
+	TOOL_FLOOD
+)
+
+var toolNames = []string{"block", "box", "sphere", "line", "flood fill"}
+
+// Editing tool picked with the number keys. Clicks clear blocks, with shift
+// held they place blockType instead. Box and line take two clicks, one per
+// corner or end.
+type toolState struct {
+	tool      int
+	radius    float64
+	blockType chunkmanager.BlockType
+
+	corner    chunkmanager.WorldCoord
+	hasCorner bool
+}
+
+func (tools *toolState) use(mx, my int, cam *camera.Camera, fill bool) {
+	pos, ok := chunkmanager.PickBlock(mx, my, cam)
+	if !ok {
+		return
+	}
+
+	state := chunkmanager.BlockState{}
+	if fill {
+		state = chunkmanager.SolidBlock(tools.blockType)
+	}
+
+	var tx *chunkmanager.Transaction
+	switch tools.tool {
+	case TOOL_BOX, TOOL_LINE:
+		if !tools.hasCorner {
+			tools.corner = pos
+			tools.hasCorner = true
+			fmt.Printf("Tool: First point at %v\n", pos)
+			return
+		}
+		tools.hasCorner = false
+		if tools.tool == TOOL_BOX {
+			tx = chunkmanager.BoxBrush(tools.corner, pos, state)
+		} else {
+			tx = chunkmanager.LineBrush(tools.corner, pos, state)
+		}
+	case TOOL_SPHERE:
+		tx = chunkmanager.SphereBrush(pos, tools.radius, state)
+	case TOOL_FLOOD:
+		tx = chunkmanager.FloodFillBrush(pos, state)
+	default:
+		return
+	}
+
+	if err := chunkmanager.SubmitTransaction(tx); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Drops a fluid source a few blocks in front of the camera, along the
+// direction W moves in.
+func placeFluidAhead(cam *camera.Camera, blockType chunkmanager.BlockType) {
+	dist := 4.0
+	xRadii := -cam.Rot.X * (math.Pi / 180.0)
+	yRadii := -cam.Rot.Y * (math.Pi / 180.0)
+	x := cam.Pos.X - (math.Sin(yRadii) * dist)
+	y := cam.Pos.Y + (math.Sin(xRadii) * dist)
+	z := cam.Pos.Z - (math.Cos(yRadii) * dist)
+	if err := chunkmanager.PlaceFluid(int(math.Floor(x)), int(math.Floor(y)), int(math.Floor(z)), blockType); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func logicLoop(camCh chan<- bool, debugCh chan<- bool, logicCh chan<- bool, exitCh chan<- bool, screenshotCh chan<- bool, environmentCh chan<- bool, cam *camera.Camera) {
 	currentTick := time.Now().UnixNano() / 1e6
 
 	rotSpeed := 1.0
 	camSpeed := 0.25
 
 	keyF1Held := false
+	keyF12Held := false
+	keyQHeld := false
+	keyEHeld := false
+	keyZHeld := false
+	keyYHeld := false
+	keyNHeld := false
+	keyTHeld := false
+	keyTimeHeld := false
+	keyRadiusHeld := false
+	mouseLeftHeld := false
+	tools := toolState{tool: TOOL_BLOCK, radius: 3.0, blockType: chunkmanager.BLOCK_STONE}
 	debugMode := false
 
 	remainder := 0.0
@@ -131,6 +460,80 @@ func logicLoop(camCh chan<- bool, debugCh chan<- bool, logicCh chan<- bool, exit
 					debugCh <- debugMode
 					fmt.Printf("Debug mode: %v.\n", debugMode)
 				}
+				if !keyF12Held && glfw.Key(glfw.KeyF12) == glfw.KeyPress {
+					keyF12Held = true
+				}
+				if keyF12Held && glfw.Key(glfw.KeyF12) == glfw.KeyRelease {
+					keyF12Held = false
+					screenshotCh <- true
+				}
+
+				if !keyNHeld && glfw.Key('N') == glfw.KeyPress {
+					keyNHeld = true
+				}
+				if keyNHeld && glfw.Key('N') == glfw.KeyRelease {
+					keyNHeld = false
+					environmentCh <- true
+				}
+
+				if !keyTHeld && glfw.Key('T') == glfw.KeyPress {
+					keyTHeld = true
+				}
+				if keyTHeld && glfw.Key('T') == glfw.KeyRelease {
+					keyTHeld = false
+					if err := clock.ToggleFreeze(); err != nil {
+						fmt.Println(err)
+					}
+				}
+				if !keyTimeHeld && glfw.Key(',') == glfw.KeyPress {
+					keyTimeHeld = true
+					if err := clock.Skip(-1.0); err != nil {
+						fmt.Println(err)
+					}
+				}
+				if !keyTimeHeld && glfw.Key('.') == glfw.KeyPress {
+					keyTimeHeld = true
+					if err := clock.Skip(1.0); err != nil {
+						fmt.Println(err)
+					}
+				}
+				if keyTimeHeld && glfw.Key(',') == glfw.KeyRelease && glfw.Key('.') == glfw.KeyRelease {
+					keyTimeHeld = false
+				}
+
+				if !keyQHeld && glfw.Key('Q') == glfw.KeyPress {
+					keyQHeld = true
+				}
+				if keyQHeld && glfw.Key('Q') == glfw.KeyRelease {
+					keyQHeld = false
+					placeFluidAhead(cam, chunkmanager.BLOCK_WATER)
+				}
+				if !keyEHeld && glfw.Key('E') == glfw.KeyPress {
+					keyEHeld = true
+				}
+				if keyEHeld && glfw.Key('E') == glfw.KeyRelease {
+					keyEHeld = false
+					placeFluidAhead(cam, chunkmanager.BLOCK_LAVA)
+				}
+
+				if !keyZHeld && glfw.Key('Z') == glfw.KeyPress {
+					keyZHeld = true
+				}
+				if keyZHeld && glfw.Key('Z') == glfw.KeyRelease {
+					keyZHeld = false
+					if err := chunkmanager.Undo(); err != nil {
+						fmt.Println(err)
+					}
+				}
+				if !keyYHeld && glfw.Key('Y') == glfw.KeyPress {
+					keyYHeld = true
+				}
+				if keyYHeld && glfw.Key('Y') == glfw.KeyRelease {
+					keyYHeld = false
+					if err := chunkmanager.Redo(); err != nil {
+						fmt.Println(err)
+					}
+				}
 
 				if glfw.Key(glfw.KeyUp) == glfw.KeyPress {
 					cam.Rot.X = math.Max(cam.Rot.X-rotSpeed, -90.0)
@@ -188,10 +591,38 @@ func logicLoop(camCh chan<- bool, debugCh chan<- bool, logicCh chan<- bool, exit
 					update = true
 				}
 
+				for t, key := range []int{'1', '2', '3', '4', '5'} {
+					if glfw.Key(key) == glfw.KeyPress && tools.tool != t {
+						tools.tool = t
+						tools.hasCorner = false
+						fmt.Printf("Tool: %s\n", toolNames[t])
+					}
+				}
+				if !keyRadiusHeld && glfw.Key('[') == glfw.KeyPress {
+					keyRadiusHeld = true
+					tools.radius = math.Max(tools.radius-1.0, 1.0)
+					fmt.Printf("Sphere radius: %.0f\n", tools.radius)
+				}
+				if !keyRadiusHeld && glfw.Key(']') == glfw.KeyPress {
+					keyRadiusHeld = true
+					tools.radius = math.Min(tools.radius+1.0, 16.0)
+					fmt.Printf("Sphere radius: %.0f\n", tools.radius)
+				}
+				if keyRadiusHeld && glfw.Key('[') == glfw.KeyRelease && glfw.Key(']') == glfw.KeyRelease {
+					keyRadiusHeld = false
+				}
+
 				if glfw.MouseButton(glfw.MouseLeft) == glfw.KeyPress {
 					// Dangerous, race condition!
 					mx, my := glfw.MousePos()
-					chunkmanager.ClickedInChunk(mx, my, cam)
+					if tools.tool == TOOL_BLOCK {
+						chunkmanager.ClickedInChunk(mx, my, cam)
+					} else if !mouseLeftHeld {
+						tools.use(mx, my, cam, glfw.Key(glfw.KeyLshift) == glfw.KeyPress)
+					}
+					mouseLeftHeld = true
+				} else {
+					mouseLeftHeld = false
 				}
 
 				if debugMode {
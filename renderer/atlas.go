@@ -0,0 +1,152 @@
+package renderer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Name of the plain white tile every atlas starts with, used for faces
+// without a texture so they look the same as before textures existed.
+const BLANK_TILE = "blank"
+
+// Square tiles packed into one image on a grid, row by row. Meshes refer to
+// tiles by index, UVs are in blocks and wrap inside the tile, so a quad
+// spanning several blocks repeats the texture instead of stretching it.
+type TextureAtlas struct {
+	// Not premultiplied, the way GL blending expects it
+	Image    *image.NRGBA
+	TileSize int
+	Columns  int
+	Rows     int
+
+	names []string
+	tiles map[string]int
+}
+
+func nextPowerOfTwo(value int) int {
+	result := 1
+	for result < value {
+		result *= 2
+	}
+	return result
+}
+
+// Packs the given tiles, all tileSize by tileSize, after the blank tile.
+// Tiles are added in name order so indices don't depend on map order.
+func NewTextureAtlas(tileSize int, images map[string]image.Image) (*TextureAtlas, error) {
+	if tileSize <= 0 {
+		return nil, fmt.Errorf("atlas: invalid tile size %d", tileSize)
+	}
+
+	names := []string{}
+	for name, img := range images {
+		if name == BLANK_TILE {
+			return nil, fmt.Errorf("atlas: %q is reserved", BLANK_TILE)
+		}
+		bounds := img.Bounds()
+		if bounds.Dx() != tileSize || bounds.Dy() != tileSize {
+			return nil, fmt.Errorf("atlas: tile %q is %dx%d, expected %dx%d", name, bounds.Dx(), bounds.Dy(), tileSize, tileSize)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	names = append([]string{BLANK_TILE}, names...)
+
+	// Power of two sides, as square as possible
+	columns := 1
+	for columns*columns < len(names) {
+		columns *= 2
+	}
+	rows := nextPowerOfTwo((len(names) + columns - 1) / columns)
+
+	atlas := &TextureAtlas{
+		Image:    image.NewNRGBA(image.Rect(0, 0, columns*tileSize, rows*tileSize)),
+		TileSize: tileSize,
+		Columns:  columns,
+		Rows:     rows,
+		names:    names,
+		tiles:    map[string]int{},
+	}
+
+	for index, name := range names {
+		col := index % columns
+		row := index / columns
+		rect := image.Rect(col*tileSize, row*tileSize, (col+1)*tileSize, (row+1)*tileSize)
+		if name == BLANK_TILE {
+			draw.Draw(atlas.Image, rect, &image.Uniform{color.NRGBA{255, 255, 255, 255}}, image.ZP, draw.Src)
+		} else {
+			img := images[name]
+			draw.Draw(atlas.Image, rect, img, img.Bounds().Min, draw.Src)
+		}
+		atlas.tiles[name] = index
+	}
+
+	return atlas, nil
+}
+
+// Loads every PNG in dir as a tile named after the file, e.g. dir/stone.png
+// becomes "stone".
+func LoadTextureAtlas(dir string, tileSize int) (*TextureAtlas, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	images := map[string]image.Image{}
+	for _, file := range files {
+		if file.IsDir() || strings.ToLower(filepath.Ext(file.Name())) != ".png" {
+			continue
+		}
+
+		filename := filepath.Join(dir, file.Name())
+		img, err := decodePNG(filename)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		images[strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))] = img
+	}
+
+	return NewTextureAtlas(tileSize, images)
+}
+
+func (atlas *TextureAtlas) Tile(name string) (int, bool) {
+	index, ok := atlas.tiles[name]
+	return index, ok
+}
+
+func (atlas *TextureAtlas) TileNames() []string {
+	return append([]string{}, atlas.names...)
+}
+
+// Colour of the tile at u, v in blocks, wrapped to the tile the same way
+// chunk.frag does it, with nearest filtering.
+func (atlas *TextureAtlas) Sample(tile int, u, v float64) color.NRGBA {
+	u -= float64(int(u))
+	if u < 0.0 {
+		u += 1.0
+	}
+	v -= float64(int(v))
+	if v < 0.0 {
+		v += 1.0
+	}
+
+	x := int(u * float64(atlas.TileSize))
+	y := int(v * float64(atlas.TileSize))
+	if x >= atlas.TileSize {
+		x = atlas.TileSize - 1
+	}
+	if y >= atlas.TileSize {
+		y = atlas.TileSize - 1
+	}
+
+	col := tile % atlas.Columns
+	row := tile / atlas.Columns
+	// V points up the face, images go down
+	return atlas.Image.NRGBAAt((col*atlas.TileSize)+x, (row*atlas.TileSize)+(atlas.TileSize-1-y))
+}
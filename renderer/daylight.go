@@ -0,0 +1,96 @@
+package renderer
+
+import (
+	"bedrock/math/vector"
+	"math"
+)
+
+// Lighting that changes with the time of day. The environment's SH light is
+// tinted by Skylight and the sun adds diffuse light from SunDir.
+
+type Daylight struct {
+	// Unit vector pointing at the sun
+	SunDir vector.Vector3f
+	// Direct sunlight, black while the sun is down
+	SunColor vector.Vector3f
+	// Tint and intensity of the environment light
+	Skylight vector.Vector3f
+	// Sky gradient, fog takes on the horizon colour
+	ZenithColor  vector.Vector3f
+	HorizonColor vector.Vector3f
+}
+
+// Colours at a sun elevation, the sine of its angle above the horizon
+type daylightKey struct {
+	elevation    float64
+	sunColor     vector.Vector3f
+	skylight     vector.Vector3f
+	zenithColor  vector.Vector3f
+	horizonColor vector.Vector3f
+}
+
+// By rising elevation, colours in between are interpolated
+var daylightKeys = []daylightKey{
+	// Night
+	{-0.3, vector.Vector3f{0.0, 0.0, 0.0}, vector.Vector3f{0.08, 0.09, 0.16}, vector.Vector3f{0.01, 0.01, 0.04}, vector.Vector3f{0.03, 0.04, 0.09}},
+	// Dusk
+	{-0.05, vector.Vector3f{0.0, 0.0, 0.0}, vector.Vector3f{0.3, 0.28, 0.4}, vector.Vector3f{0.08, 0.09, 0.22}, vector.Vector3f{0.4, 0.25, 0.25}},
+	// Sunrise and sunset
+	{0.05, vector.Vector3f{0.5, 0.25, 0.12}, vector.Vector3f{0.6, 0.55, 0.55}, vector.Vector3f{0.3, 0.4, 0.6}, vector.Vector3f{0.95, 0.6, 0.35}},
+	// Day
+	{0.3, vector.Vector3f{0.55, 0.52, 0.47}, vector.Vector3f{1.0, 1.0, 1.0}, vector.Vector3f{0.35, 0.55, 0.85}, vector.Vector3f{0.78, 0.84, 0.9}},
+	{1.0, vector.Vector3f{0.6, 0.58, 0.54}, vector.Vector3f{1.0, 1.0, 1.0}, vector.Vector3f{0.3, 0.5, 0.85}, vector.Vector3f{0.8, 0.85, 0.9}},
+}
+
+// Backends start lit the way they are at noon
+const NOON_HOURS = 12.0
+
+// Tilt of the sun's path from straight overhead, towards +z, so noon light
+// isn't straight down
+const sunTilt = 0.35
+
+func mixVector(a, b vector.Vector3f, t float64) vector.Vector3f {
+	return a.MulScalar(1.0 - t).Add(b.MulScalar(t))
+}
+
+// Lighting at a time of day in hours, the sun rises in +x at 6 and sets in
+// -x at 18.
+func DaylightAt(hours float64) Daylight {
+	angle := (hours - 6.0) / 24.0 * 2.0 * math.Pi
+	sunDir := vector.Vector3f{
+		math.Cos(angle),
+		math.Sin(angle) * math.Cos(sunTilt),
+		math.Sin(angle) * math.Sin(sunTilt),
+	}
+
+	first := daylightKeys[0]
+	last := daylightKeys[len(daylightKeys)-1]
+	lower, upper, t := first, first, 0.0
+	switch {
+	case sunDir.Y <= first.elevation:
+	case sunDir.Y >= last.elevation:
+		lower, upper = last, last
+	default:
+		for k := 1; k < len(daylightKeys); k++ {
+			if sunDir.Y <= daylightKeys[k].elevation {
+				lower, upper = daylightKeys[k-1], daylightKeys[k]
+				t = (sunDir.Y - lower.elevation) / (upper.elevation - lower.elevation)
+				break
+			}
+		}
+	}
+
+	return Daylight{
+		SunDir:       sunDir,
+		SunColor:     mixVector(lower.sunColor, upper.sunColor, t),
+		Skylight:     mixVector(lower.skylight, upper.skylight, t),
+		ZenithColor:  mixVector(lower.zenithColor, upper.zenithColor, t),
+		HorizonColor: mixVector(lower.horizonColor, upper.horizonColor, t),
+	}
+}
+
+// Sets the daylight of the current backend and clears to the horizon colour
+func SetDaylight(light Daylight) {
+	current.SetDaylight(light)
+	current.SetClearColor(light.HorizonColor.X, light.HorizonColor.Y, light.HorizonColor.Z)
+}
@@ -0,0 +1,157 @@
+package renderer
+
+import (
+	"bedrock/math/vector"
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Environments light chunk faces with two sets of SH coefficients, Outside
+// for faces that see the whole sky and Inside for fully occluded ones,
+// mixed by the face's occlusion factor.
+//
+// Coefficient files (.sh9) are plain text, one "r g b" line per coefficient
+// in SHNames order. Anything after a # is a comment.
+
+type Environment struct {
+	Name    string
+	Outside SHCoefficients
+	Inside  SHCoefficients
+}
+
+// Share of its own light an environment loaded from a single coefficient
+// file gives fully occluded faces
+const INSIDE_LIGHT_SCALE = 0.04
+
+var environments = []*Environment{
+	{Name: "beach", Outside: SHBeach, Inside: SHGroove.Scale(INSIDE_LIGHT_SCALE)},
+	{Name: "groove", Outside: SHGroove, Inside: SHGroove.Scale(INSIDE_LIGHT_SCALE)},
+	{Name: "tomb", Outside: SHTomb, Inside: SHTomb.Scale(INSIDE_LIGHT_SCALE)},
+}
+
+var DefaultEnvironment = environments[0]
+
+// Known environments, built in ones first and loaded ones in name order
+func Environments() []*Environment {
+	return append([]*Environment{}, environments...)
+}
+
+func FindEnvironment(name string) (*Environment, error) {
+	for _, env := range environments {
+		if env.Name == name {
+			return env, nil
+		}
+	}
+
+	names := []string{}
+	for _, env := range environments {
+		names = append(names, env.Name)
+	}
+	return nil, fmt.Errorf("environment: unknown %q, have %s", name, strings.Join(names, ", "))
+}
+
+// Adds an environment, replacing any with the same name
+func AddEnvironment(env *Environment) {
+	for t, existing := range environments {
+		if existing.Name == env.Name {
+			environments[t] = env
+			return
+		}
+	}
+
+	environments = append(environments, env)
+}
+
+func LoadSHCoefficients(filename string) (SHCoefficients, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return SHCoefficients{}, err
+	}
+	defer file.Close()
+
+	values := [9]vector.Vector3f{}
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if index := strings.Index(line, "#"); index >= 0 {
+			line = line[:index]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return SHCoefficients{}, fmt.Errorf("%s:%d: expected r g b, got %q", filename, lineNum, line)
+		}
+		if count == len(values) {
+			return SHCoefficients{}, fmt.Errorf("%s:%d: more than %d coefficients", filename, lineNum, len(values))
+		}
+
+		rgb := [3]float64{}
+		for t, field := range fields {
+			rgb[t], err = strconv.ParseFloat(field, 64)
+			if err != nil {
+				return SHCoefficients{}, fmt.Errorf("%s:%d: %q isn't a number", filename, lineNum, field)
+			}
+		}
+		values[count] = vector.Vector3f{rgb[0], rgb[1], rgb[2]}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return SHCoefficients{}, fmt.Errorf("%s: %v", filename, err)
+	}
+	if count != len(values) {
+		return SHCoefficients{}, fmt.Errorf("%s: expected %d coefficients, got %d", filename, len(values), count)
+	}
+
+	return SHFromArray(values), nil
+}
+
+// Writes coefficients in the format LoadSHCoefficients reads, comment lines
+// first.
+func SaveSHCoefficients(filename string, sh SHCoefficients, comments []string) error {
+	lines := []string{}
+	for _, comment := range comments {
+		lines = append(lines, "# "+comment)
+	}
+	for t, value := range sh.Array() {
+		lines = append(lines, fmt.Sprintf("%10.7f %10.7f %10.7f  # %s", value.X, value.Y, value.Z, SHNames[t]))
+	}
+
+	return ioutil.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// Loads every .sh9 file in dir as an environment named after the file, its
+// occluded light the same coefficients scaled by INSIDE_LIGHT_SCALE.
+func LoadEnvironments(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || strings.ToLower(filepath.Ext(file.Name())) != ".sh9" {
+			continue
+		}
+
+		sh, err := LoadSHCoefficients(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+		AddEnvironment(&Environment{
+			Name:    strings.TrimSuffix(file.Name(), filepath.Ext(file.Name())),
+			Outside: sh,
+			Inside:  sh.Scale(INSIDE_LIGHT_SCALE),
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,515 @@
+package glbackend
+
+import (
+	"bedrock"
+	"bedrock/math/matrix"
+	"bedrock/math/vector"
+	"bedrock/shader"
+	"dwelling/renderer"
+	gl "github.com/chsc/gogl/gl33"
+	"image"
+	"unsafe"
+)
+
+// Face groups one after another in a range of a page, see pool.go
+type glChunkMesh struct {
+	page      *glMeshPage
+	quads     quadRange
+	firstQuad [6]int
+	numQuads  [6]int
+}
+
+type glMeshPage struct {
+	*quadAllocator
+	vao    gl.Uint
+	buffer gl.Uint
+}
+
+type glLineMesh struct {
+	buffer      gl.Uint
+	numVertices gl.Sizei
+}
+
+type GLBackend struct {
+	chunkShader *shader.ShaderProgram
+	debugShader *shader.ShaderProgram
+	debugVao    gl.Uint
+	skyShader   *shader.ShaderProgram
+	skyVao      gl.Uint
+	skyBuffer   gl.Uint
+
+	// Indices of every chunk mesh, see renderer.QuadIndices
+	quadIndexBuffer gl.Uint
+	numQuadIndices  int
+	// Ranges of the current DrawChunk
+	drawCounts   []gl.Sizei
+	drawIndices  []gl.Pointer
+	drawVertices []gl.Int
+
+	atlas        *renderer.TextureAtlas
+	atlasTexture gl.Uint
+	environment  *renderer.Environment
+	daylight     renderer.Daylight
+	fogDistance  float64
+
+	chunkMeshes map[renderer.MeshId]*glChunkMesh
+	meshPages   []*glMeshPage
+	lineMeshes  map[renderer.MeshId]*glLineMesh
+	nextId      renderer.MeshId
+}
+
+func New() *GLBackend {
+	return &GLBackend{
+		chunkMeshes: map[renderer.MeshId]*glChunkMesh{},
+		lineMeshes:  map[renderer.MeshId]*glLineMesh{},
+		nextId:      1,
+		environment: renderer.DefaultEnvironment,
+		daylight:    renderer.DaylightAt(renderer.NOON_HOURS),
+	}
+}
+
+func (backend *GLBackend) SetUp() error {
+	var err error
+	backend.chunkShader, err = shader.LoadShaderProgram("chunk", []shader.AttribLocation{
+		{
+			Position: 0,
+			Location: "packedVertex",
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	backend.debugShader, err = shader.LoadShaderProgram("debug", nil)
+	if err != nil {
+		return err
+	}
+
+	gl.GenVertexArrays(1, &backend.debugVao)
+	gl.BindVertexArray(backend.debugVao)
+	gl.EnableVertexAttribArray(0)
+
+	backend.skyShader, err = shader.LoadShaderProgram("sky", []shader.AttribLocation{
+		{
+			Position: 0,
+			Location: "vertexPos",
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// One triangle covering the screen
+	skyVertices := []float32{-1.0, -1.0, 3.0, -1.0, -1.0, 3.0}
+	gl.GenVertexArrays(1, &backend.skyVao)
+	gl.BindVertexArray(backend.skyVao)
+	backend.skyBuffer = createMeshBuffer(&skyVertices, len(skyVertices))
+	gl.EnableVertexAttribArray(0)
+	gl.VertexAttribPointer(0, 2, gl.FLOAT, gl.FALSE, 0, nil)
+	gl.BindVertexArray(0)
+
+	return nil
+}
+
+func (backend *GLBackend) SetClearColor(r, g, b float64) {
+	gl.ClearColor(gl.Float(r), gl.Float(g), gl.Float(b), 1.0)
+}
+
+func (backend *GLBackend) Clear() {
+	// Depth isn't cleared while writes are off after a translucent pass
+	gl.DepthMask(gl.TRUE)
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+}
+
+func createMeshBuffer(faceBuffer *[]float32, size int) gl.Uint {
+	var buffer gl.Uint
+	sizeFloat := int(unsafe.Sizeof([1]float32{}))
+	bufferPtr := (*faceBuffer)
+
+	gl.GenBuffers(1, &buffer)
+	gl.BindBuffer(gl.ARRAY_BUFFER, buffer)
+	gl.BufferData(gl.ARRAY_BUFFER, gl.Sizeiptr(sizeFloat*size), gl.Pointer(&bufferPtr[0]), gl.STATIC_DRAW)
+
+	return buffer
+}
+
+// Grows the shared index buffer to cover numQuads quads.
+func (backend *GLBackend) reserveQuadIndices(numQuads int) {
+	if numQuads*6 <= backend.numQuadIndices {
+		return
+	}
+
+	// Room for a few more so it's rarely grown
+	indices := renderer.QuadIndices(numQuads * 2)
+	sizeInt := int(unsafe.Sizeof([1]uint32{}))
+	if backend.quadIndexBuffer == 0 {
+		gl.GenBuffers(1, &backend.quadIndexBuffer)
+	}
+	// Filled through ARRAY_BUFFER, binding ELEMENT_ARRAY_BUFFER would
+	// change whichever VAO is bound. The buffer keeps its name, so VAOs
+	// using it see the new data.
+	gl.BindBuffer(gl.ARRAY_BUFFER, backend.quadIndexBuffer)
+	gl.BufferData(gl.ARRAY_BUFFER, gl.Sizeiptr(sizeInt*len(indices)), gl.Pointer(&indices[0]), gl.STATIC_DRAW)
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+	backend.numQuadIndices = len(indices)
+}
+
+const sizeQuad = 4 * renderer.CHUNK_VERTEX_WORDS * 4
+
+func (backend *GLBackend) newMeshPage(numQuads int) *glMeshPage {
+	page := &glMeshPage{quadAllocator: newQuadAllocator(numQuads)}
+	gl.GenVertexArrays(1, &page.vao)
+	gl.GenBuffers(1, &page.buffer)
+	gl.BindVertexArray(page.vao)
+	gl.EnableVertexAttribArray(0)
+	gl.BindBuffer(gl.ARRAY_BUFFER, page.buffer)
+	gl.BufferData(gl.ARRAY_BUFFER, gl.Sizeiptr(sizeQuad*numQuads), nil, gl.DYNAMIC_DRAW)
+	gl.VertexAttribIPointer(0, renderer.CHUNK_VERTEX_WORDS, gl.UNSIGNED_INT, 0, nil)
+	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, backend.quadIndexBuffer)
+	gl.BindVertexArray(0)
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+
+	backend.meshPages = append(backend.meshPages, page)
+	return page
+}
+
+// Takes numQuads from the first page with room, or a new one.
+func (backend *GLBackend) allocateQuads(numQuads int) (*glMeshPage, quadRange) {
+	for _, page := range backend.meshPages {
+		if quads, ok := page.allocate(numQuads); ok {
+			return page, quads
+		}
+	}
+
+	pageQuads := POOL_PAGE_QUADS
+	if numQuads > pageQuads {
+		pageQuads = numQuads
+	}
+	page := backend.newMeshPage(pageQuads)
+	quads, _ := page.allocate(numQuads)
+	return page, quads
+}
+
+func (backend *GLBackend) releaseQuads(mesh *glChunkMesh) {
+	if mesh.page != nil {
+		mesh.page.release(mesh.quads)
+	}
+	mesh.page = nil
+	mesh.quads = quadRange{}
+}
+
+func (backend *GLBackend) UpdateChunkMesh(id renderer.MeshId, data *renderer.ChunkMeshData) renderer.MeshId {
+	mesh, ok := backend.chunkMeshes[id]
+	if !ok {
+		id = backend.nextId
+		backend.nextId++
+		mesh = &glChunkMesh{}
+		backend.chunkMeshes[id] = mesh
+	}
+
+	numQuads := 0
+	for t := 0; t < 6; t++ {
+		mesh.firstQuad[t] = numQuads
+		mesh.numQuads[t] = data.NumVertices(t) / 4
+		numQuads += mesh.numQuads[t]
+	}
+	// The old range is free for this or any other mesh
+	backend.releaseQuads(mesh)
+	if numQuads == 0 {
+		return id
+	}
+	// Neighbouring groups are drawn as one range, all of them at worst
+	backend.reserveQuadIndices(numQuads)
+	mesh.page, mesh.quads = backend.allocateQuads(numQuads)
+
+	gl.BindBuffer(gl.ARRAY_BUFFER, mesh.page.buffer)
+	for t := 0; t < 6; t++ {
+		if mesh.numQuads[t] > 0 {
+			offset := sizeQuad * (mesh.quads.first + mesh.firstQuad[t])
+			gl.BufferSubData(gl.ARRAY_BUFFER, gl.Intptr(offset), gl.Sizeiptr(sizeQuad*mesh.numQuads[t]), gl.Pointer(&data.Vertices[t][0]))
+		}
+	}
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+
+	return id
+}
+
+func (backend *GLBackend) DeleteChunkMesh(id renderer.MeshId) {
+	if mesh, ok := backend.chunkMeshes[id]; ok {
+		backend.releaseQuads(mesh)
+		delete(backend.chunkMeshes, id)
+	}
+}
+
+func (backend *GLBackend) MeshMemory() renderer.MeshMemory {
+	mem := renderer.MeshMemory{
+		NumBuffers: len(backend.meshPages),
+		IndexBytes: backend.numQuadIndices * int(unsafe.Sizeof([1]uint32{})),
+	}
+	for _, page := range backend.meshPages {
+		mem.TotalBytes += sizeQuad * page.numQuads
+		mem.UsedBytes += sizeQuad * (page.numQuads - page.freeQuads())
+		if sizeQuad*page.largestFree() > mem.LargestFreeBytes {
+			mem.LargestFreeBytes = sizeQuad * page.largestFree()
+		}
+	}
+
+	return mem
+}
+
+func (backend *GLBackend) DrawSky(view, projection *matrix.Matrix) {
+	gl.Disable(gl.DEPTH_TEST)
+	gl.DepthMask(gl.FALSE)
+
+	right, up, forward := renderer.SkyBasis(view, projection)
+	backend.skyShader.Use()
+	backend.skyShader.SetUniformVector3f("right", right)
+	backend.skyShader.SetUniformVector3f("up", up)
+	backend.skyShader.SetUniformVector3f("forward", forward)
+	backend.skyShader.SetUniformVector3f("zenithColor", backend.daylight.ZenithColor)
+	backend.skyShader.SetUniformVector3f("horizonColor", backend.daylight.HorizonColor)
+	backend.skyShader.SetUniformVector3f("sunDir", backend.daylight.SunDir)
+	backend.skyShader.SetUniformVector3f("sunColor", backend.daylight.SunColor)
+
+	gl.BindVertexArray(backend.skyVao)
+	gl.DrawArrays(gl.TRIANGLES, 0, 3)
+	gl.BindVertexArray(0)
+
+	gl.Enable(gl.DEPTH_TEST)
+	gl.DepthMask(gl.TRUE)
+}
+
+func (backend *GLBackend) SetFogDistance(distance float64) {
+	backend.fogDistance = distance
+}
+
+func (backend *GLBackend) BeginChunks(pv *matrix.Matrix, eye vector.Vector3f, onlyOcclusion bool) {
+	gl.Disable(gl.BLEND)
+	gl.DepthMask(gl.TRUE)
+
+	backend.chunkShader.Use()
+	backend.chunkShader.SetUniformInt("translucent", 0)
+	backend.chunkShader.SetUniformMatrix("pv", pv)
+	if onlyOcclusion {
+		backend.chunkShader.SetUniformInt("onlyOccFac", 1)
+	} else {
+		backend.chunkShader.SetUniformInt("onlyOccFac", 0)
+	}
+
+	outside := backend.environment.Outside.Array()
+	inside := backend.environment.Inside.Array()
+	for t, name := range renderer.SHNames {
+		backend.chunkShader.SetUniformVector3f("outsideSH."+name, outside[t])
+		backend.chunkShader.SetUniformVector3f("insideSH."+name, inside[t])
+	}
+	backend.chunkShader.SetUniformVector3f("sunDir", backend.daylight.SunDir)
+	backend.chunkShader.SetUniformVector3f("sunColor", backend.daylight.SunColor)
+	backend.chunkShader.SetUniformVector3f("skylight", backend.daylight.Skylight)
+	backend.chunkShader.SetUniformVector3f("eye", eye)
+	backend.chunkShader.SetUniformVector3f("fog", vector.Vector3f{backend.fogDistance, renderer.FOG_FALLOFF, 0.0})
+	backend.chunkShader.SetUniformVector3f("fogColor", backend.daylight.HorizonColor)
+
+	if backend.atlas != nil {
+		gl.ActiveTexture(gl.TEXTURE0)
+		gl.BindTexture(gl.TEXTURE_2D, backend.atlasTexture)
+		backend.chunkShader.SetUniformInt("atlas", 0)
+		backend.chunkShader.SetUniformInt("atlasColumns", backend.atlas.Columns)
+		backend.chunkShader.SetUniformInt("atlasRows", backend.atlas.Rows)
+		backend.chunkShader.SetUniformInt("useAtlas", 1)
+	} else {
+		backend.chunkShader.SetUniformInt("useAtlas", 0)
+	}
+}
+
+func (backend *GLBackend) BeginTranslucentChunks() {
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	gl.DepthMask(gl.FALSE)
+
+	backend.chunkShader.SetUniformInt("translucent", 1)
+}
+
+func (backend *GLBackend) SetEnvironment(env *renderer.Environment) {
+	backend.environment = env
+}
+
+func (backend *GLBackend) SetDaylight(light renderer.Daylight) {
+	backend.daylight = light
+}
+
+func (backend *GLBackend) SetTextureAtlas(atlas *renderer.TextureAtlas) {
+	backend.atlas = atlas
+	if atlas == nil {
+		return
+	}
+
+	if backend.atlasTexture == 0 {
+		gl.GenTextures(1, &backend.atlasTexture)
+	}
+	gl.BindTexture(gl.TEXTURE_2D, backend.atlasTexture)
+	// Nearest and no mipmaps, so tiles never bleed into each other
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.Int(gl.NEAREST))
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.Int(gl.NEAREST))
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.Int(gl.CLAMP_TO_EDGE))
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.Int(gl.CLAMP_TO_EDGE))
+
+	bounds := atlas.Image.Bounds()
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.Int(gl.RGBA), gl.Sizei(bounds.Dx()), gl.Sizei(bounds.Dy()), 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Pointer(&atlas.Image.Pix[0]))
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+}
+
+// Every range starts at the first of the shared quad indices, its base
+// vertex moves it to the range's own vertices in the page.
+func (backend *GLBackend) DrawChunk(id renderer.MeshId, faces [6]bool, model *matrix.Matrix, mouseHit, wireframe bool) {
+	mesh, ok := backend.chunkMeshes[id]
+	if !ok || mesh.page == nil {
+		return
+	}
+
+	counts := backend.drawCounts[:0]
+	indices := backend.drawIndices[:0]
+	baseVertices := backend.drawVertices[:0]
+	rangeEnd := -1
+	for t := 0; t < 6; t++ {
+		if !faces[t] || mesh.numQuads[t] == 0 {
+			continue
+		}
+		if mesh.firstQuad[t] == rangeEnd {
+			counts[len(counts)-1] += gl.Sizei(mesh.numQuads[t] * 6)
+		} else {
+			counts = append(counts, gl.Sizei(mesh.numQuads[t]*6))
+			indices = append(indices, nil)
+			baseVertices = append(baseVertices, gl.Int((mesh.quads.first+mesh.firstQuad[t])*4))
+		}
+		rangeEnd = mesh.firstQuad[t] + mesh.numQuads[t]
+	}
+	backend.drawCounts, backend.drawIndices, backend.drawVertices = counts, indices, baseVertices
+	if len(counts) == 0 {
+		return
+	}
+
+	hit := 0
+	if mouseHit {
+		hit = 1
+	}
+	backend.chunkShader.SetUniformMatrix("model", model)
+	backend.chunkShader.SetUniformInt("mouseHit", hit)
+
+	mode := gl.TRIANGLES
+	if wireframe {
+		mode = gl.LINES
+	}
+	gl.BindVertexArray(mesh.page.vao)
+	gl.MultiDrawElementsBaseVertex(mode, &counts[0], gl.UNSIGNED_INT, &indices[0], gl.Sizei(len(counts)), &baseVertices[0])
+	gl.BindVertexArray(0)
+}
+
+func (backend *GLBackend) UpdateLineMesh(id renderer.MeshId, vertices []float32) renderer.MeshId {
+	mesh, ok := backend.lineMeshes[id]
+	if !ok {
+		id = backend.nextId
+		backend.nextId++
+		mesh = &glLineMesh{}
+		backend.lineMeshes[id] = mesh
+	}
+
+	sizeFloat := int(unsafe.Sizeof([1]float32{}))
+
+	gl.BindVertexArray(backend.debugVao)
+	if mesh.buffer == 0 {
+		gl.GenBuffers(1, &mesh.buffer)
+	}
+	gl.BindBuffer(gl.ARRAY_BUFFER, mesh.buffer)
+	gl.BufferData(gl.ARRAY_BUFFER, gl.Sizeiptr(sizeFloat*len(vertices)), gl.Pointer(&vertices[0]), gl.STATIC_DRAW)
+	gl.EnableVertexAttribArray(0)
+	gl.VertexAttribPointer(0, 3, gl.FLOAT, gl.FALSE, 0, nil)
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+
+	mesh.numVertices = gl.Sizei(len(vertices) / 3)
+
+	return id
+}
+
+func (backend *GLBackend) DrawLines(id renderer.MeshId, pv, model *matrix.Matrix, color vector.Vector3f) {
+	mesh, ok := backend.lineMeshes[id]
+	if !ok {
+		return
+	}
+
+	gl.BindVertexArray(backend.debugVao)
+	backend.debugShader.Use()
+	backend.debugShader.SetUniformMatrix("pv", pv)
+	backend.debugShader.SetUniformMatrix("model", model)
+	backend.debugShader.SetUniformVector3f("flatColor", color)
+
+	gl.BindBuffer(gl.ARRAY_BUFFER, mesh.buffer)
+	gl.VertexAttribPointer(0, 3, gl.FLOAT, gl.FALSE, 0, nil)
+	gl.DrawArrays(gl.LINES, 0, mesh.numVertices)
+}
+
+func (backend *GLBackend) ReadPixels() *image.RGBA {
+	width := bedrock.ScreenWidth
+	height := bedrock.ScreenHeight
+	pixels := make([]uint8, width*height*4)
+	gl.PixelStorei(gl.PACK_ALIGNMENT, 1)
+	gl.ReadPixels(0, 0, gl.Sizei(width), gl.Sizei(height), gl.RGBA, gl.UNSIGNED_BYTE, gl.Pointer(&pixels[0]))
+
+	// GL reads bottom row first
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	stride := width * 4
+	for y := 0; y < height; y++ {
+		copy(img.Pix[y*img.Stride:y*img.Stride+stride], pixels[(height-y-1)*stride:(height-y)*stride])
+	}
+
+	return img
+}
+
+func (backend *GLBackend) Cleanup() {
+	for _, page := range backend.meshPages {
+		gl.DeleteBuffers(1, &page.buffer)
+		gl.DeleteVertexArrays(1, &page.vao)
+	}
+	backend.meshPages = nil
+	backend.chunkMeshes = map[renderer.MeshId]*glChunkMesh{}
+
+	for _, mesh := range backend.lineMeshes {
+		gl.DeleteBuffers(1, &mesh.buffer)
+	}
+	backend.lineMeshes = map[renderer.MeshId]*glLineMesh{}
+
+	for _, buffer := range []*gl.Uint{&backend.quadIndexBuffer, &backend.skyBuffer} {
+		if *buffer != 0 {
+			gl.DeleteBuffers(1, buffer)
+			*buffer = 0
+		}
+	}
+	backend.numQuadIndices = 0
+	for _, vao := range []*gl.Uint{&backend.skyVao, &backend.debugVao} {
+		if *vao != 0 {
+			gl.DeleteVertexArrays(1, vao)
+			*vao = 0
+		}
+	}
+	if backend.atlasTexture != 0 {
+		gl.DeleteTextures(1, &backend.atlasTexture)
+		backend.atlasTexture = 0
+	}
+
+	for _, program := range []**shader.ShaderProgram{&backend.chunkShader, &backend.debugShader, &backend.skyShader} {
+		if *program != nil {
+			deleteProgram(*program)
+			*program = nil
+		}
+	}
+}
+
+// The shader package can't free a program, its name is taken from GL by
+// making it current.
+func deleteProgram(program *shader.ShaderProgram) {
+	program.Use()
+	var name gl.Int
+	gl.GetIntegerv(gl.CURRENT_PROGRAM, &name)
+	gl.UseProgram(0)
+	gl.DeleteProgram(gl.Uint(name))
+}
@@ -0,0 +1,88 @@
+package glbackend
+
+// Chunk meshes are suballocated from pages, large vertex buffers shared by
+// many meshes. A mesh takes one range of quads in a page, the first that
+// fits, and hands it back when it's rebuilt or deleted. Neighbouring free
+// ranges are merged, free space split into ranges too small for the next
+// mesh shows up as fragmentation.
+
+// Quads in a page, 2MiB of packed vertices. Meshes larger than that get a
+// page of their own.
+const POOL_PAGE_QUADS = 1 << 16
+
+type quadRange struct {
+	first, count int
+}
+
+// Free ranges of a page, ordered by first and never touching
+type quadAllocator struct {
+	numQuads int
+	free     []quadRange
+}
+
+func newQuadAllocator(numQuads int) *quadAllocator {
+	return &quadAllocator{
+		numQuads: numQuads,
+		free:     []quadRange{{0, numQuads}},
+	}
+}
+
+// Returns: the range and whether the page had room for it
+func (alloc *quadAllocator) allocate(count int) (quadRange, bool) {
+	for t, free := range alloc.free {
+		if free.count < count {
+			continue
+		}
+
+		if free.count == count {
+			alloc.free = append(alloc.free[:t], alloc.free[t+1:]...)
+		} else {
+			alloc.free[t] = quadRange{free.first + count, free.count - count}
+		}
+		return quadRange{free.first, count}, true
+	}
+
+	return quadRange{}, false
+}
+
+func (alloc *quadAllocator) release(used quadRange) {
+	if used.count == 0 {
+		return
+	}
+
+	t := 0
+	for t < len(alloc.free) && alloc.free[t].first < used.first {
+		t++
+	}
+	alloc.free = append(alloc.free, quadRange{})
+	copy(alloc.free[t+1:], alloc.free[t:])
+	alloc.free[t] = used
+
+	// Merge with the ranges after and before it
+	if t+1 < len(alloc.free) && used.first+used.count == alloc.free[t+1].first {
+		alloc.free[t].count += alloc.free[t+1].count
+		alloc.free = append(alloc.free[:t+1], alloc.free[t+2:]...)
+	}
+	if t > 0 && alloc.free[t-1].first+alloc.free[t-1].count == alloc.free[t].first {
+		alloc.free[t-1].count += alloc.free[t].count
+		alloc.free = append(alloc.free[:t], alloc.free[t+1:]...)
+	}
+}
+
+func (alloc *quadAllocator) freeQuads() int {
+	total := 0
+	for _, free := range alloc.free {
+		total += free.count
+	}
+	return total
+}
+
+func (alloc *quadAllocator) largestFree() int {
+	largest := 0
+	for _, free := range alloc.free {
+		if free.count > largest {
+			largest = free.count
+		}
+	}
+	return largest
+}
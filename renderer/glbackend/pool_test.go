@@ -0,0 +1,87 @@
+package glbackend
+
+import (
+	"reflect"
+	"testing"
+)
+
+func checkFree(t *testing.T, alloc *quadAllocator, want []quadRange) {
+	t.Helper()
+	if !reflect.DeepEqual(alloc.free, want) {
+		t.Errorf("free ranges %v, want %v", alloc.free, want)
+	}
+}
+
+func TestQuadAllocatorExactFit(t *testing.T) {
+	alloc := newQuadAllocator(100)
+	used, ok := alloc.allocate(100)
+	if !ok || used != (quadRange{0, 100}) {
+		t.Fatalf("allocated %v, %v, want the whole page", used, ok)
+	}
+	checkFree(t, alloc, []quadRange{})
+
+	if _, ok := alloc.allocate(1); ok {
+		t.Error("allocated from a full page")
+	}
+}
+
+func TestQuadAllocatorSplit(t *testing.T) {
+	alloc := newQuadAllocator(100)
+	first, _ := alloc.allocate(30)
+	second, _ := alloc.allocate(20)
+	if first != (quadRange{0, 30}) || second != (quadRange{30, 20}) {
+		t.Errorf("allocated %v and %v, want them one after another from the start", first, second)
+	}
+	checkFree(t, alloc, []quadRange{{50, 50}})
+	if alloc.freeQuads() != 50 || alloc.largestFree() != 50 {
+		t.Errorf("%d quads free, largest %d, want 50 and 50", alloc.freeQuads(), alloc.largestFree())
+	}
+}
+
+// Takes four ranges of ten quads and releases some of the first three in
+// order, each has to merge with the free ranges it touches. The fourth stays
+// in use, keeping the rest of the page apart.
+func TestQuadAllocatorMerge(t *testing.T) {
+	tests := []struct {
+		name    string
+		release []int
+		want    []quadRange
+	}{
+		{"previous", []int{1, 2}, []quadRange{{10, 20}, {40, 60}}},
+		{"next", []int{1, 0}, []quadRange{{0, 20}, {40, 60}}},
+		{"both", []int{0, 2, 1}, []quadRange{{0, 30}, {40, 60}}},
+		{"none", []int{0, 2}, []quadRange{{0, 10}, {20, 10}, {40, 60}}},
+	}
+
+	for _, test := range tests {
+		alloc := newQuadAllocator(100)
+		ranges := []quadRange{}
+		for _, count := range []int{10, 10, 10, 10} {
+			used, _ := alloc.allocate(count)
+			ranges = append(ranges, used)
+		}
+		for _, index := range test.release {
+			alloc.release(ranges[index])
+		}
+		if !reflect.DeepEqual(alloc.free, test.want) {
+			t.Errorf("%s: free ranges %v, want %v", test.name, alloc.free, test.want)
+		}
+	}
+}
+
+func TestQuadAllocatorFirstFit(t *testing.T) {
+	alloc := newQuadAllocator(100)
+	a, _ := alloc.allocate(10)
+	alloc.allocate(10)
+	alloc.release(a)
+
+	used, ok := alloc.allocate(5)
+	if !ok || used != (quadRange{0, 5}) {
+		t.Errorf("allocated %v, %v, want the start of the first free range", used, ok)
+	}
+	used, ok = alloc.allocate(10)
+	if !ok || used != (quadRange{20, 10}) {
+		t.Errorf("allocated %v, %v, want it past the range too small for it", used, ok)
+	}
+	checkFree(t, alloc, []quadRange{{5, 5}, {30, 70}})
+}
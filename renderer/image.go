@@ -0,0 +1,105 @@
+package renderer
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func SavePNG(filename string, img image.Image) error {
+	if dir := filepath.Dir(filename); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func decodePNG(filename string) (image.Image, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return png.Decode(file)
+}
+
+func LoadPNG(filename string) (*image.RGBA, error) {
+	img, err := decodePNG(filename)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	return rgba, nil
+}
+
+// Returns: dir/dwelling_<date>_<time>.png, down to the millisecond so
+// screenshots taken in quick succession don't overwrite each other
+func ScreenshotFilename(dir string) string {
+	stamp := time.Now().Format("20060102_150405.000")
+	return filepath.Join(dir, fmt.Sprintf("dwelling_%s.png", stamp))
+}
+
+// Compares two frames channel by channel, differences up to tolerance are
+// ignored.
+// Returns: number of differing pixels and an image with those marked red
+// over a faded copy of a
+func DiffImages(a, b *image.RGBA, tolerance uint8) (int, *image.RGBA, error) {
+	if a.Rect.Dx() != b.Rect.Dx() || a.Rect.Dy() != b.Rect.Dy() {
+		return 0, nil, fmt.Errorf("image sizes differ, %dx%d vs %dx%d", a.Rect.Dx(), a.Rect.Dy(), b.Rect.Dx(), b.Rect.Dy())
+	}
+
+	width := a.Rect.Dx()
+	height := a.Rect.Dy()
+	diff := image.NewRGBA(image.Rect(0, 0, width, height))
+	numDiffering := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pa := a.Pix[a.PixOffset(a.Rect.Min.X+x, a.Rect.Min.Y+y):]
+			pb := b.Pix[b.PixOffset(b.Rect.Min.X+x, b.Rect.Min.Y+y):]
+			pd := diff.Pix[diff.PixOffset(x, y):]
+
+			differs := false
+			for c := 0; c < 4; c++ {
+				delta := int(pa[c]) - int(pb[c])
+				if delta < 0 {
+					delta = -delta
+				}
+				if delta > int(tolerance) {
+					differs = true
+				}
+			}
+
+			if differs {
+				numDiffering++
+				pd[0], pd[1], pd[2] = 255, 0, 0
+			} else {
+				grey := uint8((int(pa[0]) + int(pa[1]) + int(pa[2])) / 3)
+				grey = 192 + grey/4
+				pd[0], pd[1], pd[2] = grey, grey, grey
+			}
+			pd[3] = 255
+		}
+	}
+
+	return numDiffering, diff, nil
+}
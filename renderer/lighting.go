@@ -0,0 +1,115 @@
+package renderer
+
+import (
+	"bedrock/math/vector"
+	"math"
+)
+
+// Go side of the spherical harmonics lighting in chunk.frag.
+
+type SHCoefficients struct {
+	L00, L1m1, L10, L11, L2m2, L2m1, L20, L21, L22 vector.Vector3f
+}
+
+// Field names in the order of Array, the same as the SHC struct in
+// chunk.frag
+var SHNames = [9]string{"L00", "L1m1", "L10", "L11", "L2m2", "L2m1", "L20", "L21", "L22"}
+
+func (sh SHCoefficients) Array() [9]vector.Vector3f {
+	return [9]vector.Vector3f{sh.L00, sh.L1m1, sh.L10, sh.L11, sh.L2m2, sh.L2m1, sh.L20, sh.L21, sh.L22}
+}
+
+func SHFromArray(values [9]vector.Vector3f) SHCoefficients {
+	return SHCoefficients{values[0], values[1], values[2], values[3], values[4], values[5], values[6], values[7], values[8]}
+}
+
+// Same lighting, factor times as bright
+func (sh SHCoefficients) Scale(factor float64) SHCoefficients {
+	values := sh.Array()
+	for t := range values {
+		values[t] = values[t].MulScalar(factor)
+	}
+	return SHFromArray(values)
+}
+
+var SHGroove = SHCoefficients{
+	vector.Vector3f{0.3783264, 0.4260425, 0.4504587},
+	vector.Vector3f{0.2887813, 0.3586803, 0.4147053},
+	vector.Vector3f{0.0379030, 0.0295216, 0.0098567},
+	vector.Vector3f{-0.1033028, -0.1031690, -0.0884924},
+	vector.Vector3f{-0.0621750, -0.0554432, -0.0396779},
+	vector.Vector3f{0.0077820, -0.0148312, -0.0471301},
+	vector.Vector3f{-0.0935561, -0.1254260, -0.1525629},
+	vector.Vector3f{-0.0572703, -0.0502192, -0.0363410},
+	vector.Vector3f{0.0203348, -0.0044201, -0.0452180},
+}
+
+var SHBeach = SHCoefficients{
+	vector.Vector3f{0.6841148, 0.6929004, 0.7069543},
+	vector.Vector3f{0.3173355, 0.3694407, 0.4406839},
+	vector.Vector3f{-0.1747193, -0.1737154, -0.1657420},
+	vector.Vector3f{-0.4496467, -0.4155184, -0.3416573},
+	vector.Vector3f{-0.1690202, -0.1703022, -0.1525870},
+	vector.Vector3f{-0.0837808, -0.0940454, -0.1027518},
+	vector.Vector3f{-0.0319670, -0.0214051, -0.0147691},
+	vector.Vector3f{0.1641816, 0.1377558, 0.1010403},
+	vector.Vector3f{0.3697189, 0.3097930, 0.2029923},
+}
+
+var SHTomb = SHCoefficients{
+	vector.Vector3f{1.0351604, 0.7603549, 0.7074635},
+	vector.Vector3f{0.4442150, 0.3430402, 0.3403777},
+	vector.Vector3f{-0.2247797, -0.1828517, -0.1705181},
+	vector.Vector3f{0.7110400, 0.5423169, 0.5587956},
+	vector.Vector3f{0.6430452, 0.4971454, 0.5156357},
+	vector.Vector3f{-0.1150112, -0.0936603, -0.0839287},
+	vector.Vector3f{-0.3742487, -0.2755962, -0.2875017},
+	vector.Vector3f{-0.1694954, -0.1343096, -0.1335315},
+	vector.Vector3f{0.5515260, 0.4222179, 0.4162488},
+}
+
+// Same as sh_light in chunk.frag
+func SHLight(normal vector.Vector3f, l SHCoefficients) vector.Vector3f {
+	x := normal.X
+	y := normal.Y
+	z := normal.Z
+
+	const C1 = 0.429043
+	const C2 = 0.511664
+	const C3 = 0.743125
+	const C4 = 0.886227
+	const C5 = 0.247708
+
+	result := l.L22.MulScalar(C1 * ((x * x) - (y * y)))
+	result = result.Add(l.L20.MulScalar(C3 * z * z))
+	result = result.Add(l.L00.MulScalar(C4))
+	result = result.Sub(l.L20.MulScalar(C5))
+	result = result.Add(l.L2m2.MulScalar(2.0 * C1 * x * y))
+	result = result.Add(l.L21.MulScalar(2.0 * C1 * x * z))
+	result = result.Add(l.L2m1.MulScalar(2.0 * C1 * y * z))
+	result = result.Add(l.L11.MulScalar(2.0 * C2 * x))
+	result = result.Add(l.L1m1.MulScalar(2.0 * C2 * y))
+	result = result.Add(l.L10.MulScalar(2.0 * C2 * z))
+	return result
+}
+
+// Fragment colour of chunk.frag for a face normal, occlusion and shadow
+// factor.
+func shadeChunkFragment(normal vector.Vector3f, occFac, shadowFac float64, onlyOcclusion bool, env *Environment, light *Daylight) vector.Vector3f {
+	if onlyOcclusion {
+		return vector.Vector3f{occFac, occFac, occFac}
+	}
+
+	outside := SHLight(normal, env.Outside)
+	inside := SHLight(normal, env.Inside)
+	ambient := inside.MulScalar(1.0 - occFac).Add(outside.MulScalar(occFac))
+	ambient = vector.Vector3f{ambient.X * light.Skylight.X, ambient.Y * light.Skylight.Y, ambient.Z * light.Skylight.Z}
+	sun := light.SunColor.MulScalar(math.Max(vector.DotProduct(normal, light.SunDir), 0.0) * shadowFac)
+	ambient = ambient.Add(sun).MulScalar(0.5)
+
+	return vector.Vector3f{
+		math.Pow(math.Max(ambient.X, 0.0), 1.0/2.0),
+		math.Pow(math.Max(ambient.Y, 0.0), 1.0/2.0),
+		math.Pow(math.Max(ambient.Z, 0.0), 1.0/2.0),
+	}
+}
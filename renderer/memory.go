@@ -0,0 +1,36 @@
+package renderer
+
+import (
+	"fmt"
+)
+
+// GPU memory held for chunk meshes
+type MeshMemory struct {
+	// Vertex buffers and their size
+	NumBuffers int
+	TotalBytes int
+	// Of TotalBytes, what meshes use and the largest free range
+	UsedBytes        int
+	LargestFreeBytes int
+	// The index buffer all meshes share
+	IndexBytes int
+}
+
+// Returns: share of the free space outside the largest free range, 0 when
+// it's all in one piece
+func (mem MeshMemory) Fragmentation() float64 {
+	free := mem.TotalBytes - mem.UsedBytes
+	if free == 0 {
+		return 0.0
+	}
+	return 1.0 - (float64(mem.LargestFreeBytes) / float64(free))
+}
+
+func (mem MeshMemory) String() string {
+	return fmt.Sprintf("Chunk meshes: %d buffers, %.2fMiB, %.2fMiB used, %.1f%% fragmented, %.2fMiB indices",
+		mem.NumBuffers, toMiB(mem.TotalBytes), toMiB(mem.UsedBytes), mem.Fragmentation()*100.0, toMiB(mem.IndexBytes))
+}
+
+func toMiB(bytes int) float64 {
+	return float64(bytes) / (1024.0 * 1024.0)
+}
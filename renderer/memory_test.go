@@ -0,0 +1,25 @@
+package renderer
+
+import (
+	"testing"
+)
+
+func TestMeshMemoryFragmentation(t *testing.T) {
+	tests := []struct {
+		mem  MeshMemory
+		want float64
+	}{
+		// Nothing free, or all of it in one piece
+		{MeshMemory{TotalBytes: 100, UsedBytes: 100}, 0.0},
+		{MeshMemory{TotalBytes: 100, UsedBytes: 40, LargestFreeBytes: 60}, 0.0},
+		// Free space in pieces, the largest a quarter of it
+		{MeshMemory{TotalBytes: 100, UsedBytes: 20, LargestFreeBytes: 20}, 0.75},
+		{MeshMemory{TotalBytes: 200, UsedBytes: 100, LargestFreeBytes: 50}, 0.5},
+	}
+
+	for _, test := range tests {
+		if got := test.mem.Fragmentation(); got != test.want {
+			t.Errorf("%+v is %v fragmented, want %v", test.mem, got, test.want)
+		}
+	}
+}
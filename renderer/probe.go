@@ -0,0 +1,140 @@
+package renderer
+
+import (
+	"bedrock/math/vector"
+	"fmt"
+	"image"
+	"math"
+)
+
+// Projects environment images onto the first nine spherical harmonics, the
+// coefficients SHLight and chunk.frag turn into irradiance. Directions are
+// world axes, +y up, with the camera's default view along -z as the middle
+// of an equirectangular image and the front face of a cube map.
+//
+// Pixels are sRGB and linearised before projecting. A uniformly white image
+// gives an irradiance of pi, exposure scales the result.
+
+// Horizontal cross cube map faces, 4 by 3 cells
+type cubeFace struct {
+	column, row       int
+	center, right, up vector.Vector3f
+}
+
+var cubeCrossFaces = []cubeFace{
+	// Front
+	{1, 1, vector.Vector3f{0, 0, -1}, vector.Vector3f{1, 0, 0}, vector.Vector3f{0, 1, 0}},
+	// Left
+	{0, 1, vector.Vector3f{-1, 0, 0}, vector.Vector3f{0, 0, -1}, vector.Vector3f{0, 1, 0}},
+	// Right
+	{2, 1, vector.Vector3f{1, 0, 0}, vector.Vector3f{0, 0, 1}, vector.Vector3f{0, 1, 0}},
+	// Back
+	{3, 1, vector.Vector3f{0, 0, 1}, vector.Vector3f{-1, 0, 0}, vector.Vector3f{0, 1, 0}},
+	// Top, its bottom edge joins the top of the front face
+	{1, 0, vector.Vector3f{0, 1, 0}, vector.Vector3f{1, 0, 0}, vector.Vector3f{0, 0, 1}},
+	// Bottom
+	{1, 2, vector.Vector3f{0, -1, 0}, vector.Vector3f{1, 0, 0}, vector.Vector3f{0, 0, -1}},
+}
+
+// Values of the nine basis functions for a unit direction, in SHNames order
+func shBasis(dir vector.Vector3f) [9]float64 {
+	x, y, z := dir.X, dir.Y, dir.Z
+	return [9]float64{
+		0.282095,
+		0.488603 * y,
+		0.488603 * z,
+		0.488603 * x,
+		1.092548 * x * y,
+		1.092548 * y * z,
+		0.315392 * ((3.0 * z * z) - 1.0),
+		1.092548 * x * z,
+		0.546274 * ((x * x) - (y * y)),
+	}
+}
+
+func srgbToLinear(value uint32) float64 {
+	return math.Pow(float64(value)/65535.0, 2.2)
+}
+
+type shProjection struct {
+	sums [9]vector.Vector3f
+}
+
+// Adds the light from pixel (x, y) of img arriving from dir over a solid
+// angle of weight
+func (proj *shProjection) add(img image.Image, x, y int, dir vector.Vector3f, weight float64) {
+	r, g, b, _ := img.At(x, y).RGBA()
+	radiance := vector.Vector3f{srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)}
+	for t, basis := range shBasis(dir) {
+		proj.sums[t] = proj.sums[t].Add(radiance.MulScalar(basis * weight))
+	}
+}
+
+// Projects an equirectangular image, twice as wide as it's high, top row
+// straight up.
+func SHFromEquirect(img image.Image, exposure float64) (SHCoefficients, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width != height*2 {
+		return SHCoefficients{}, fmt.Errorf("probe: equirectangular image must be 2:1, got %dx%d", width, height)
+	}
+
+	proj := shProjection{}
+	for y := 0; y < height; y++ {
+		theta := math.Pi * (float64(y) + 0.5) / float64(height)
+		weight := (2.0 * math.Pi / float64(width)) * (math.Pi / float64(height)) * math.Sin(theta)
+		for x := 0; x < width; x++ {
+			phi := 2.0 * math.Pi * (float64(x) + 0.5) / float64(width)
+			dir := vector.Vector3f{
+				-math.Sin(theta) * math.Sin(phi),
+				math.Cos(theta),
+				math.Sin(theta) * math.Cos(phi),
+			}
+			proj.add(img, bounds.Min.X+x, bounds.Min.Y+y, dir, weight)
+		}
+	}
+
+	return SHFromArray(proj.sums).Scale(exposure), nil
+}
+
+// Projects a cube map laid out as a horizontal cross, 4 by 3 square faces:
+// left, front, right and back in the middle row, top and bottom above and
+// below the front face.
+func SHFromCubeCross(img image.Image, exposure float64) (SHCoefficients, error) {
+	bounds := img.Bounds()
+	size := bounds.Dx() / 4
+	if size == 0 || bounds.Dx() != size*4 || bounds.Dy() != size*3 {
+		return SHCoefficients{}, fmt.Errorf("probe: cube cross must be 4:3 with square faces, got %dx%d", bounds.Dx(), bounds.Dy())
+	}
+
+	proj := shProjection{}
+	for _, face := range cubeCrossFaces {
+		for y := 0; y < size; y++ {
+			// Face coordinates from -1 to 1, b up
+			b := 1.0 - (2.0 * (float64(y) + 0.5) / float64(size))
+			for x := 0; x < size; x++ {
+				a := (2.0 * (float64(x) + 0.5) / float64(size)) - 1.0
+				dir := face.center.Add(face.right.MulScalar(a)).Add(face.up.MulScalar(b))
+				// Solid angle of a texel shrinks towards the face's edges
+				lengthSq := 1.0 + (a * a) + (b * b)
+				weight := (4.0 / float64(size*size)) / (lengthSq * math.Sqrt(lengthSq))
+				proj.add(img, bounds.Min.X+(face.column*size)+x, bounds.Min.Y+(face.row*size)+y, dir.MulScalar(1.0/math.Sqrt(lengthSq)), weight)
+			}
+		}
+	}
+
+	return SHFromArray(proj.sums).Scale(exposure), nil
+}
+
+// Projects either layout, told apart by the image's aspect ratio.
+func SHFromImage(img image.Image, exposure float64) (SHCoefficients, error) {
+	bounds := img.Bounds()
+	if bounds.Dx() == bounds.Dy()*2 {
+		return SHFromEquirect(img, exposure)
+	}
+	if bounds.Dx()*3 == bounds.Dy()*4 {
+		return SHFromCubeCross(img, exposure)
+	}
+
+	return SHCoefficients{}, fmt.Errorf("probe: %dx%d is neither a 2:1 equirectangular image nor a 4:3 cube cross", bounds.Dx(), bounds.Dy())
+}
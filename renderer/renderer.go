@@ -0,0 +1,92 @@
+package renderer
+
+import (
+	"bedrock/math/matrix"
+	"bedrock/math/vector"
+	"image"
+)
+
+// Everything drawn goes through a Backend, the GL one in glbackend for the
+// game and the software one for rendering without a GPU. This package
+// doesn't depend on GL, only programs opening a window link it.
+
+type MeshId int
+
+// Chunk geometry grouped by face direction (FRONT, BACK, LEFT, RIGHT, TOP,
+// BOTTOM). Vertices are packed as in vertex.go, four per face quad. Backends
+// keep the groups one after another in a single buffer.
+type ChunkMeshData struct {
+	Vertices [6][]uint32
+}
+
+func (data *ChunkMeshData) AppendVertex(face int, v ChunkVertex) {
+	words := PackChunkVertex(v)
+	data.Vertices[face] = append(data.Vertices[face], words[:]...)
+}
+
+func (data *ChunkMeshData) NumVertices(face int) int {
+	return len(data.Vertices[face]) / CHUNK_VERTEX_WORDS
+}
+
+func (data *ChunkMeshData) Vertex(face, index int) ChunkVertex {
+	return UnpackChunkVertex(data.Vertices[face][index*CHUNK_VERTEX_WORDS:])
+}
+
+// Returns: bytes of vertex data, indices are shared by every mesh
+func (data *ChunkMeshData) Bytes() int {
+	size := 0
+	for t := 0; t < 6; t++ {
+		size += len(data.Vertices[t]) * 4
+	}
+	return size
+}
+
+type Backend interface {
+	SetUp() error
+	SetClearColor(r, g, b float64)
+	Clear()
+
+	// Creates or, given an existing id, replaces a chunk mesh
+	UpdateChunkMesh(id MeshId, data *ChunkMeshData) MeshId
+	DeleteChunkMesh(id MeshId)
+	MeshMemory() MeshMemory
+	// Gradient from the current Daylight over the whole screen, drawn first
+	DrawSky(view, projection *matrix.Matrix)
+	// Chunk faces further than this from the eye are hidden by fog, 0 turns
+	// it off
+	SetFogDistance(distance float64)
+	BeginChunks(pv *matrix.Matrix, eye vector.Vector3f, onlyOcclusion bool)
+	// Faces drawn from here until the next BeginChunks are blended and
+	// don't write depth
+	BeginTranslucentChunks()
+	// Draws the face groups set in faces with a single call, the caller
+	// leaves out the ones facing away
+	DrawChunk(id MeshId, faces [6]bool, model *matrix.Matrix, mouseHit, wireframe bool)
+
+	// Line lists, xyz per vertex
+	UpdateLineMesh(id MeshId, vertices []float32) MeshId
+	DrawLines(id MeshId, pv, model *matrix.Matrix, color vector.Vector3f)
+
+	// Texture for chunk faces, nil draws them untextured
+	SetTextureAtlas(atlas *TextureAtlas)
+	// Lighting of chunk faces
+	SetEnvironment(env *Environment)
+	SetDaylight(light Daylight)
+
+	// Copy of the current frame, top row first
+	ReadPixels() *image.RGBA
+	// Frees everything the backend holds, call last
+	Cleanup()
+}
+
+// A software backend until SetBackend replaces it, the game sets the GL one
+// at startup
+var current Backend = NewSoftBackend(1, 1)
+
+func SetBackend(backend Backend) {
+	current = backend
+}
+
+func Current() Backend {
+	return current
+}
@@ -0,0 +1,50 @@
+package renderer
+
+import (
+	"bedrock/math/matrix"
+	"bedrock/math/vector"
+	"math"
+)
+
+// Go side of sky.frag and the fog in chunk.frag. The sky is a gradient from
+// the horizon to the zenith colour of the current Daylight with a glow
+// around the sun. Fog fades chunks into the horizon colour, clear up to
+// half the fog distance and nearly opaque at it, so chunks past the view
+// distance never pop in or out in plain sight.
+
+// Fog left at the fog distance is exp(-FOG_FALLOFF^2), 2%
+const FOG_FALLOFF = 1.978
+
+// Returns: view ray directions are forward + x*right + y*up for x and y in
+// normalized device coordinates
+func SkyBasis(view, projection *matrix.Matrix) (right, up, forward vector.Vector3f) {
+	v := view.Values
+	p := projection.Values
+	right = vector.Vector3f{v[0], v[1], v[2]}.MulScalar(1.0 / p[0])
+	up = vector.Vector3f{v[4], v[5], v[6]}.MulScalar(1.0 / p[5])
+	// The camera looks down its -z axis
+	forward = vector.Vector3f{-v[8], -v[9], -v[10]}
+	return right, up, forward
+}
+
+// Same as sky_color in sky.frag, dir is a unit vector
+func skyColor(dir vector.Vector3f, light *Daylight) vector.Vector3f {
+	height := math.Sqrt(math.Max(dir.Y, 0.0))
+	col := mixVector(light.HorizonColor, light.ZenithColor, height)
+
+	sun := math.Max(vector.DotProduct(dir, light.SunDir), 0.0)
+	glow := (math.Pow(sun, 8.0) * 0.3) + (math.Pow(sun, 512.0) * 2.0)
+	return col.Add(light.SunColor.MulScalar(glow))
+}
+
+// Same as fog_visibility in chunk.frag.
+// Returns: share of a fragment's own colour left at distance from the eye
+func fogVisibility(distance, fogDistance float64) float64 {
+	if fogDistance <= 0.0 {
+		return 1.0
+	}
+
+	start := fogDistance * 0.5
+	depth := math.Max(distance-start, 0.0) * (FOG_FALLOFF / (fogDistance - start))
+	return math.Exp(-depth * depth)
+}
@@ -0,0 +1,442 @@
+package renderer
+
+import (
+	"bedrock/math/matrix"
+	"bedrock/math/vector"
+	"image"
+	"image/color"
+	"math"
+)
+
+// CPU rasterizer producing the same picture as the GL backend, for
+// rendering without a GPU. Matrices are row-major like the rest of bedrock.
+
+// Chunks under the mouse are mixed this far towards mouseHitColor, same as
+// in chunk.frag
+const MOUSE_HIT_TINT = 0.3
+
+var mouseHitColor = vector.Vector3f{1.0, 0.9, 0.5}
+
+type softVertex struct {
+	// Clip space position
+	x, y, z, w float64
+	occ        float64
+	shadow     float64
+	u, v       float64
+	// For fog
+	world vector.Vector3f
+}
+
+type SoftBackend struct {
+	Width, Height int
+	Image         *image.RGBA
+
+	depth      []float64
+	clearColor color.RGBA
+
+	chunkMeshes map[MeshId]*ChunkMeshData
+	lineMeshes  map[MeshId][]float32
+	nextId      MeshId
+	quadIndices []uint32
+
+	pv            *matrix.Matrix
+	onlyOcclusion bool
+	translucent   bool
+	mouseHit      bool
+	atlas         *TextureAtlas
+	environment   *Environment
+	daylight      Daylight
+	eye           vector.Vector3f
+	fogDistance   float64
+}
+
+func NewSoftBackend(width, height int) *SoftBackend {
+	return &SoftBackend{
+		Width:       width,
+		Height:      height,
+		Image:       image.NewRGBA(image.Rect(0, 0, width, height)),
+		depth:       make([]float64, width*height),
+		clearColor:  color.RGBA{0, 0, 0, 255},
+		chunkMeshes: map[MeshId]*ChunkMeshData{},
+		lineMeshes:  map[MeshId][]float32{},
+		nextId:      1,
+		pv:          matrix.NewIdentityMatrix(),
+		environment: DefaultEnvironment,
+		daylight:    DaylightAt(NOON_HOURS),
+	}
+}
+
+func (backend *SoftBackend) SetUp() error {
+	return nil
+}
+
+func toByte(value float64) uint8 {
+	return uint8(math.Max(math.Min(value, 1.0), 0.0)*255.0 + 0.5)
+}
+
+func (backend *SoftBackend) SetClearColor(r, g, b float64) {
+	backend.clearColor = color.RGBA{toByte(r), toByte(g), toByte(b), 255}
+}
+
+func (backend *SoftBackend) Clear() {
+	for y := 0; y < backend.Height; y++ {
+		for x := 0; x < backend.Width; x++ {
+			backend.Image.SetRGBA(x, y, backend.clearColor)
+		}
+	}
+	for t := range backend.depth {
+		backend.depth[t] = 1.0
+	}
+}
+
+func (backend *SoftBackend) UpdateChunkMesh(id MeshId, data *ChunkMeshData) MeshId {
+	if _, ok := backend.chunkMeshes[id]; !ok {
+		id = backend.nextId
+		backend.nextId++
+	}
+
+	meshCopy := &ChunkMeshData{}
+	for t := 0; t < 6; t++ {
+		meshCopy.Vertices[t] = append([]uint32{}, data.Vertices[t]...)
+	}
+	backend.chunkMeshes[id] = meshCopy
+
+	return id
+}
+
+func (backend *SoftBackend) DeleteChunkMesh(id MeshId) {
+	delete(backend.chunkMeshes, id)
+}
+
+// Meshes are plain slices, one buffer each that's always full
+func (backend *SoftBackend) MeshMemory() MeshMemory {
+	mem := MeshMemory{NumBuffers: len(backend.chunkMeshes)}
+	for _, mesh := range backend.chunkMeshes {
+		mem.TotalBytes += mesh.Bytes()
+	}
+	mem.UsedBytes = mem.TotalBytes
+	mem.IndexBytes = len(backend.quadIndices) * 4
+
+	return mem
+}
+
+func (backend *SoftBackend) DrawSky(view, projection *matrix.Matrix) {
+	right, up, forward := SkyBasis(view, projection)
+	for y := 0; y < backend.Height; y++ {
+		ndcY := 1.0 - (2.0 * (float64(y) + 0.5) / float64(backend.Height))
+		for x := 0; x < backend.Width; x++ {
+			ndcX := (2.0 * (float64(x) + 0.5) / float64(backend.Width)) - 1.0
+			dir := forward.Add(right.MulScalar(ndcX)).Add(up.MulScalar(ndcY))
+			dir = dir.MulScalar(1.0 / math.Sqrt(vector.DotProduct(dir, dir)))
+			col := skyColor(dir, &backend.daylight)
+			backend.Image.SetRGBA(x, y, color.RGBA{toByte(col.X), toByte(col.Y), toByte(col.Z), 255})
+		}
+	}
+}
+
+func (backend *SoftBackend) SetFogDistance(distance float64) {
+	backend.fogDistance = distance
+}
+
+func (backend *SoftBackend) BeginChunks(pv *matrix.Matrix, eye vector.Vector3f, onlyOcclusion bool) {
+	backend.pv = pv
+	backend.eye = eye
+	backend.onlyOcclusion = onlyOcclusion
+	backend.translucent = false
+}
+
+func (backend *SoftBackend) BeginTranslucentChunks() {
+	backend.translucent = true
+}
+
+func transform(m *matrix.Matrix, x, y, z float64) (float64, float64, float64, float64) {
+	v := m.Values
+	return (v[0] * x) + (v[1] * y) + (v[2] * z) + v[3],
+		(v[4] * x) + (v[5] * y) + (v[6] * z) + v[7],
+		(v[8] * x) + (v[9] * y) + (v[10] * z) + v[11],
+		(v[12] * x) + (v[13] * y) + (v[14] * z) + v[15]
+}
+
+func (backend *SoftBackend) DrawChunk(id MeshId, faces [6]bool, model *matrix.Matrix, mouseHit, wireframe bool) {
+	mesh, ok := backend.chunkMeshes[id]
+	if !ok {
+		return
+	}
+
+	backend.mouseHit = mouseHit
+	for face := 0; face < 6; face++ {
+		if faces[face] {
+			backend.drawChunkFaces(mesh, face, model, wireframe)
+		}
+	}
+}
+
+// Chunks are only translated, their normals are the same in the world
+func (backend *SoftBackend) drawChunkFaces(mesh *ChunkMeshData, face int, model *matrix.Matrix, wireframe bool) {
+	normal := FaceNormals[face]
+	pvm := matrix.MultiplyMatrix(backend.pv, model)
+	clipped := make([]softVertex, mesh.NumVertices(face))
+	tiles := make([]int, len(clipped))
+	for t := range clipped {
+		vertex := mesh.Vertex(face, t)
+		vx, vy, vz := vertex.Position()
+		x, y, z, w := transform(pvm, vx, vy, vz)
+		wx, wy, wz, _ := transform(model, vx, vy, vz)
+		u, v := vertex.TexCoord()
+		clipped[t] = softVertex{x, y, z, w, vertex.Occlusion, vertex.Shadow, u, v, vector.Vector3f{wx, wy, wz}}
+		tiles[t] = vertex.Tile
+	}
+
+	if len(backend.quadIndices) < len(clipped)/4*6 {
+		backend.quadIndices = QuadIndices(len(clipped) / 4)
+	}
+	indices := backend.quadIndices[:len(clipped)/4*6]
+	if wireframe {
+		// Same pairing as GL drawing the triangle indices as LINES
+		col := shadeChunkFragment(normal, 1.0, 1.0, backend.onlyOcclusion, backend.environment, &backend.daylight)
+		for t := 0; t+1 < len(indices); t += 2 {
+			backend.drawLine(clipped[indices[t]], clipped[indices[t+1]], col)
+		}
+		return
+	}
+
+	for t := 0; t+2 < len(indices); t += 3 {
+		tile := -1
+		if backend.atlas != nil {
+			tile = tiles[indices[t]]
+		}
+
+		polygon := clipNear([]softVertex{clipped[indices[t]], clipped[indices[t+1]], clipped[indices[t+2]]})
+		for u := 1; u+1 < len(polygon); u++ {
+			backend.fillTriangle(polygon[0], polygon[u], polygon[u+1], normal, tile)
+		}
+	}
+}
+
+// Clips a polygon against the near plane, z >= -w.
+func clipNear(polygon []softVertex) []softVertex {
+	result := []softVertex{}
+	for t := range polygon {
+		a := polygon[t]
+		b := polygon[(t+1)%len(polygon)]
+		aIn := a.z+a.w >= 0.0
+		bIn := b.z+b.w >= 0.0
+
+		if aIn {
+			result = append(result, a)
+		}
+		if aIn != bIn {
+			f := (a.z + a.w) / ((a.z + a.w) - (b.z + b.w))
+			result = append(result, softVertex{
+				a.x + (b.x-a.x)*f,
+				a.y + (b.y-a.y)*f,
+				a.z + (b.z-a.z)*f,
+				a.w + (b.w-a.w)*f,
+				a.occ + (b.occ-a.occ)*f,
+				a.shadow + (b.shadow-a.shadow)*f,
+				a.u + (b.u-a.u)*f,
+				a.v + (b.v-a.v)*f,
+				a.world.Add(b.world.Sub(a.world).MulScalar(f)),
+			})
+		}
+	}
+
+	return result
+}
+
+type screenVertex struct {
+	x, y, z float64
+	// Perspective correct interpolation
+	invW, occOverW float64
+	shadowOverW    float64
+	uOverW, vOverW float64
+	worldOverW     vector.Vector3f
+}
+
+func (backend *SoftBackend) toScreen(v softVertex) screenVertex {
+	invW := 1.0 / v.w
+	return screenVertex{
+		x:           ((v.x * invW) + 1.0) * 0.5 * float64(backend.Width),
+		y:           (1.0 - (v.y * invW)) * 0.5 * float64(backend.Height),
+		z:           ((v.z * invW) + 1.0) * 0.5,
+		invW:        invW,
+		occOverW:    v.occ * invW,
+		shadowOverW: v.shadow * invW,
+		uOverW:      v.u * invW,
+		vOverW:      v.v * invW,
+		worldOverW:  v.world.MulScalar(invW),
+	}
+}
+
+func edge(ax, ay, bx, by, px, py float64) float64 {
+	return ((bx - ax) * (py - ay)) - ((by - ay) * (px - ax))
+}
+
+// Pixels exactly on an edge shared by two triangles belong to only one of
+// them, otherwise the result depends on drawing order.
+func insideEdge(weight float64, a, b screenVertex) bool {
+	if weight != 0.0 {
+		return weight > 0.0
+	}
+	return (a.y == b.y && b.x > a.x) || b.y < a.y
+}
+
+// A negative tile draws the triangle untextured.
+func (backend *SoftBackend) fillTriangle(va, vb, vc softVertex, normal vector.Vector3f, tile int) {
+	a := backend.toScreen(va)
+	b := backend.toScreen(vb)
+	c := backend.toScreen(vc)
+
+	area := edge(a.x, a.y, b.x, b.y, c.x, c.y)
+	if area == 0.0 {
+		return
+	}
+	if area < 0.0 {
+		b, c = c, b
+		area = -area
+	}
+
+	minX := int(math.Max(math.Floor(math.Min(a.x, math.Min(b.x, c.x))), 0.0))
+	maxX := int(math.Min(math.Ceil(math.Max(a.x, math.Max(b.x, c.x))), float64(backend.Width-1)))
+	minY := int(math.Max(math.Floor(math.Min(a.y, math.Min(b.y, c.y))), 0.0))
+	maxY := int(math.Min(math.Ceil(math.Max(a.y, math.Max(b.y, c.y))), float64(backend.Height-1)))
+
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			px := float64(x) + 0.5
+			py := float64(y) + 0.5
+			w0 := edge(b.x, b.y, c.x, c.y, px, py) / area
+			w1 := edge(c.x, c.y, a.x, a.y, px, py) / area
+			w2 := edge(a.x, a.y, b.x, b.y, px, py) / area
+			if !insideEdge(w0, b, c) || !insideEdge(w1, c, a) || !insideEdge(w2, a, b) {
+				continue
+			}
+
+			z := (w0 * a.z) + (w1 * b.z) + (w2 * c.z)
+			index := (y * backend.Width) + x
+			if z < 0.0 || z > 1.0 || z >= backend.depth[index] {
+				continue
+			}
+
+			invW := (w0 * a.invW) + (w1 * b.invW) + (w2 * c.invW)
+			occ := ((w0 * a.occOverW) + (w1 * b.occOverW) + (w2 * c.occOverW)) / invW
+			shadow := ((w0 * a.shadowOverW) + (w1 * b.shadowOverW) + (w2 * c.shadowOverW)) / invW
+			col := shadeChunkFragment(normal, occ, shadow, backend.onlyOcclusion, backend.environment, &backend.daylight)
+			alpha := 1.0
+			if tile >= 0 && !backend.onlyOcclusion {
+				u := ((w0 * a.uOverW) + (w1 * b.uOverW) + (w2 * c.uOverW)) / invW
+				v := ((w0 * a.vOverW) + (w1 * b.vOverW) + (w2 * c.vOverW)) / invW
+				texel := backend.atlas.Sample(tile, u, v)
+				col.X *= float64(texel.R) / 255.0
+				col.Y *= float64(texel.G) / 255.0
+				col.Z *= float64(texel.B) / 255.0
+				alpha = float64(texel.A) / 255.0
+			}
+			if backend.mouseHit && !backend.onlyOcclusion {
+				col = mixVector(col, mouseHitColor, MOUSE_HIT_TINT)
+			}
+			if !backend.onlyOcclusion {
+				world := a.worldOverW.MulScalar(w0).Add(b.worldOverW.MulScalar(w1)).Add(c.worldOverW.MulScalar(w2)).MulScalar(1.0 / invW)
+				offset := world.Sub(backend.eye)
+				col = mixVector(backend.daylight.HorizonColor, col, fogVisibility(math.Sqrt(vector.DotProduct(offset, offset)), backend.fogDistance))
+			}
+
+			if backend.translucent {
+				// Blended over what's there, depth left alone
+				dst := backend.Image.RGBAAt(x, y)
+				col.X = (col.X * alpha) + ((float64(dst.R) / 255.0) * (1.0 - alpha))
+				col.Y = (col.Y * alpha) + ((float64(dst.G) / 255.0) * (1.0 - alpha))
+				col.Z = (col.Z * alpha) + ((float64(dst.B) / 255.0) * (1.0 - alpha))
+			} else {
+				if alpha < 0.5 {
+					continue
+				}
+				backend.depth[index] = z
+			}
+			backend.Image.SetRGBA(x, y, color.RGBA{toByte(col.X), toByte(col.Y), toByte(col.Z), 255})
+		}
+	}
+}
+
+func (backend *SoftBackend) drawLine(va, vb softVertex, col vector.Vector3f) {
+	line := clipNear([]softVertex{va, vb})
+	if len(line) < 2 {
+		return
+	}
+	a := backend.toScreen(line[0])
+	b := backend.toScreen(line[1])
+
+	steps := int(math.Ceil(math.Max(math.Abs(b.x-a.x), math.Abs(b.y-a.y))))
+	if steps > backend.Width+backend.Height {
+		steps = backend.Width + backend.Height
+	}
+	rgba := color.RGBA{toByte(col.X), toByte(col.Y), toByte(col.Z), 255}
+	for t := 0; t <= steps; t++ {
+		f := 0.0
+		if steps > 0 {
+			f = float64(t) / float64(steps)
+		}
+		x := int(a.x + (b.x-a.x)*f)
+		y := int(a.y + (b.y-a.y)*f)
+		z := a.z + (b.z-a.z)*f
+		if x < 0 || x >= backend.Width || y < 0 || y >= backend.Height {
+			continue
+		}
+		index := (y * backend.Width) + x
+		if z > backend.depth[index] {
+			continue
+		}
+		backend.depth[index] = z
+		backend.Image.SetRGBA(x, y, rgba)
+	}
+}
+
+func (backend *SoftBackend) UpdateLineMesh(id MeshId, vertices []float32) MeshId {
+	if _, ok := backend.lineMeshes[id]; !ok {
+		id = backend.nextId
+		backend.nextId++
+	}
+
+	backend.lineMeshes[id] = append([]float32{}, vertices...)
+
+	return id
+}
+
+func (backend *SoftBackend) DrawLines(id MeshId, pv, model *matrix.Matrix, col vector.Vector3f) {
+	vertices, ok := backend.lineMeshes[id]
+	if !ok {
+		return
+	}
+
+	pvm := matrix.MultiplyMatrix(pv, model)
+	for t := 0; t+5 < len(vertices); t += 6 {
+		ax, ay, az, aw := transform(pvm, float64(vertices[t]), float64(vertices[t+1]), float64(vertices[t+2]))
+		bx, by, bz, bw := transform(pvm, float64(vertices[t+3]), float64(vertices[t+4]), float64(vertices[t+5]))
+		backend.drawLine(softVertex{x: ax, y: ay, z: az, w: aw}, softVertex{x: bx, y: by, z: bz, w: bw}, col)
+	}
+}
+
+func (backend *SoftBackend) SetTextureAtlas(atlas *TextureAtlas) {
+	backend.atlas = atlas
+}
+
+func (backend *SoftBackend) SetEnvironment(env *Environment) {
+	backend.environment = env
+}
+
+func (backend *SoftBackend) SetDaylight(light Daylight) {
+	backend.daylight = light
+}
+
+func (backend *SoftBackend) ReadPixels() *image.RGBA {
+	img := image.NewRGBA(backend.Image.Rect)
+	copy(img.Pix, backend.Image.Pix)
+
+	return img
+}
+
+func (backend *SoftBackend) Cleanup() {
+	backend.chunkMeshes = map[MeshId]*ChunkMeshData{}
+	backend.lineMeshes = map[MeshId][]float32{}
+	backend.quadIndices = nil
+}
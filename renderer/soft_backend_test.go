@@ -0,0 +1,130 @@
+package renderer
+
+import (
+	"bedrock/math/matrix"
+	"bedrock/math/vector"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden images in testdata")
+
+// Blocks of a small chunk with every face, occlusion darkening towards the
+// ground and the sun only reaching the tops.
+func goldenChunkMesh() *ChunkMeshData {
+	mesh := &ChunkMeshData{}
+	blocks := [][3]int{{0, 0, 0}, {1, 0, 0}, {2, 0, 0}, {0, 0, 1}, {0, 0, 2}, {0, 1, 0}, {2, 0, 2}}
+	for index, block := range blocks {
+		height := 1.0
+		// The last one is a lowered fluid block
+		if index == len(blocks)-1 {
+			height = 0.5
+		}
+
+		for face := 0; face < 6; face++ {
+			shadow := 0.3
+			// TOP in FaceNormals
+			if face == 4 {
+				shadow = 1.0
+			}
+			for corner := 0; corner < 4; corner++ {
+				mesh.AppendVertex(face, ChunkVertex{
+					X:         block[0],
+					Y:         block[1],
+					Z:         block[2],
+					Face:      face,
+					Corner:    corner,
+					Height:    height,
+					Occlusion: 0.4 + (0.6 * float64(FaceCorners[face][corner][1])),
+					Shadow:    shadow,
+				})
+			}
+		}
+	}
+
+	return mesh
+}
+
+// Two copies of the same chunk, the second under the mouse, seen from
+// above at nine in the morning with fog.
+func renderGoldenScene() *SoftBackend {
+	backend := NewSoftBackend(96, 64)
+	backend.SetDaylight(DaylightAt(9.0))
+	backend.SetFogDistance(32.0)
+
+	view := matrix.NewIdentityMatrix()
+	view.RotateX(-40.0)
+	view.RotateY(-160.0)
+	view.Translate(0.0, -5.0, 2.0)
+	projection := matrix.NewPerspectiveMatrix(53.13, 96.0/64.0, 1.0, 100.0)
+
+	id := backend.UpdateChunkMesh(0, goldenChunkMesh())
+	backend.Clear()
+	backend.DrawSky(view, projection)
+	backend.BeginChunks(matrix.MultiplyMatrix(projection, view), vector.Vector3f{0.0, 5.0, -2.0}, false)
+
+	faces := [6]bool{true, true, true, true, true, true}
+	model := matrix.NewIdentityMatrix()
+	backend.DrawChunk(id, faces, model, false, false)
+	hitModel := matrix.NewIdentityMatrix()
+	hitModel.Translate(0.0, 0.0, 4.0)
+	backend.DrawChunk(id, faces, hitModel, true, false)
+
+	return backend
+}
+
+func TestSoftBackendGoldenScene(t *testing.T) {
+	frame := renderGoldenScene().ReadPixels()
+	golden := filepath.Join("testdata", "soft_scene.png")
+	if *updateGolden {
+		if err := SavePNG(golden, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reference, err := LoadPNG(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Room for rounding differences between platforms
+	numDiffering, diff, err := DiffImages(frame, reference, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numDiffering > 0 {
+		diffFilename := filepath.Join(os.TempDir(), "soft_scene_diff.png")
+		SavePNG(diffFilename, diff)
+		t.Errorf("%d pixels differ from %s, see %s, rerun with -update if the change is intended", numDiffering, golden, diffFilename)
+	}
+}
+
+func TestSoftBackendMouseHit(t *testing.T) {
+	backend := NewSoftBackend(32, 32)
+	id := backend.UpdateChunkMesh(0, goldenChunkMesh())
+	view := matrix.NewIdentityMatrix()
+	view.RotateX(-40.0)
+	view.RotateY(-160.0)
+	view.Translate(0.0, -5.0, 2.0)
+	pv := matrix.MultiplyMatrix(matrix.NewPerspectiveMatrix(53.13, 1.0, 1.0, 100.0), view)
+	faces := [6]bool{true, true, true, true, true, true}
+
+	frames := [2][]uint8{}
+	for t, mouseHit := range []bool{false, true} {
+		backend.Clear()
+		backend.BeginChunks(pv, vector.Vector3f{0.0, 5.0, -2.0}, false)
+		backend.DrawChunk(id, faces, matrix.NewIdentityMatrix(), mouseHit, false)
+		frames[t] = backend.ReadPixels().Pix
+	}
+
+	differing := 0
+	for t := range frames[0] {
+		if frames[0][t] != frames[1][t] {
+			differing++
+		}
+	}
+	if differing == 0 {
+		t.Error("chunk under the mouse isn't highlighted")
+	}
+}
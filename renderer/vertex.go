@@ -0,0 +1,140 @@
+package renderer
+
+import (
+	"bedrock/math/vector"
+	"fmt"
+)
+
+// Chunk vertices are packed into CHUNK_VERTEX_WORDS 32-bit words, decoded
+// by chunk.vert and unpacked the same way by the software backend:
+//
+// word 0: bits 0-14 block in the chunk (5 bits each for x, y and z),
+// 15-17 face, 18-19 corner of the face, 20-27 height of the block's top
+// word 1: bits 0-7 occlusion, 8-15 shadow, 16-31 atlas tile
+//
+// Positions, normals and texture coordinates follow from the block, face
+// and corner. Every face is a quad of four vertices in a row, so all meshes
+// share the indices from QuadIndices.
+
+const CHUNK_VERTEX_WORDS = 2
+
+// Blocks per axis a packed vertex can address
+const MAX_CHUNK_BLOCKS = 32
+
+// Atlas tiles a packed vertex can address
+const MAX_CHUNK_TILES = 65536
+
+// Normals of the faces, FRONT, BACK, LEFT, RIGHT, TOP and BOTTOM. Same as
+// normals in chunk.vert.
+var FaceNormals = [6]vector.Vector3f{
+	{0.0, 0.0, 1.0},
+	{0.0, 0.0, -1.0},
+	{-1.0, 0.0, 0.0},
+	{1.0, 0.0, 0.0},
+	{0.0, 1.0, 0.0},
+	{0.0, -1.0, 0.0},
+}
+
+// Corners of each face relative to the block, counter clockwise seen from
+// the outside. Same as corners in chunk.vert.
+var FaceCorners = [6][4][3]int{
+	{{0, 0, 1}, {1, 0, 1}, {1, 1, 1}, {0, 1, 1}},
+	{{1, 1, 0}, {1, 0, 0}, {0, 0, 0}, {0, 1, 0}},
+	{{0, 0, 0}, {0, 0, 1}, {0, 1, 1}, {0, 1, 0}},
+	{{1, 1, 1}, {1, 0, 1}, {1, 0, 0}, {1, 1, 0}},
+	{{1, 1, 1}, {1, 1, 0}, {0, 1, 0}, {0, 1, 1}},
+	{{0, 0, 0}, {1, 0, 0}, {1, 0, 1}, {0, 0, 1}},
+}
+
+// Texture coordinates of the corners in FaceCorners. Same as texCoords in
+// chunk.vert.
+var FaceTexCoords = [6][4][2]float64{
+	{{0.0, 0.0}, {1.0, 0.0}, {1.0, 1.0}, {0.0, 1.0}},
+	{{0.0, 1.0}, {0.0, 0.0}, {1.0, 0.0}, {1.0, 1.0}},
+	{{0.0, 0.0}, {1.0, 0.0}, {1.0, 1.0}, {0.0, 1.0}},
+	{{0.0, 1.0}, {0.0, 0.0}, {1.0, 0.0}, {1.0, 1.0}},
+	{{1.0, 1.0}, {1.0, 0.0}, {0.0, 0.0}, {0.0, 1.0}},
+	{{0.0, 0.0}, {1.0, 0.0}, {1.0, 1.0}, {0.0, 1.0}},
+}
+
+type ChunkVertex struct {
+	// Block in the chunk
+	X, Y, Z int
+	// Face direction and which of its FaceCorners
+	Face, Corner int
+	// Top of the block, 1 for full blocks and lower for fluids
+	Height float64
+	// From 0 to 1, stored with 8 bits
+	Occlusion float64
+	Shadow    float64
+	Tile      int
+}
+
+func toUnorm8(value float64) uint32 {
+	if value <= 0.0 {
+		return 0
+	}
+	if value >= 1.0 {
+		return 255
+	}
+	return uint32((value * 255.0) + 0.5)
+}
+
+// Panics on fields that don't fit their bits, they'd spill into the
+// neighbouring ones and break the mesh in ways that are hard to trace.
+func PackChunkVertex(v ChunkVertex) [CHUNK_VERTEX_WORDS]uint32 {
+	if v.X < 0 || v.X >= MAX_CHUNK_BLOCKS || v.Y < 0 || v.Y >= MAX_CHUNK_BLOCKS || v.Z < 0 || v.Z >= MAX_CHUNK_BLOCKS {
+		panic(fmt.Sprintf("renderer: block %d,%d,%d outside the %d blocks a packed vertex addresses", v.X, v.Y, v.Z, MAX_CHUNK_BLOCKS))
+	}
+	if v.Face < 0 || v.Face >= 6 || v.Corner < 0 || v.Corner >= 4 {
+		panic(fmt.Sprintf("renderer: no corner %d of face %d", v.Corner, v.Face))
+	}
+	if v.Tile < 0 || v.Tile >= MAX_CHUNK_TILES {
+		panic(fmt.Sprintf("renderer: tile %d outside the %d tiles a packed vertex addresses", v.Tile, MAX_CHUNK_TILES))
+	}
+
+	position := uint32(v.X) | (uint32(v.Y) << 5) | (uint32(v.Z) << 10)
+	return [CHUNK_VERTEX_WORDS]uint32{
+		position | (uint32(v.Face) << 15) | (uint32(v.Corner) << 18) | (toUnorm8(v.Height) << 20),
+		toUnorm8(v.Occlusion) | (toUnorm8(v.Shadow) << 8) | (uint32(v.Tile) << 16),
+	}
+}
+
+func UnpackChunkVertex(words []uint32) ChunkVertex {
+	return ChunkVertex{
+		X:         int(words[0] & 31),
+		Y:         int((words[0] >> 5) & 31),
+		Z:         int((words[0] >> 10) & 31),
+		Face:      int((words[0] >> 15) & 7),
+		Corner:    int((words[0] >> 18) & 3),
+		Height:    float64((words[0]>>20)&255) / 255.0,
+		Occlusion: float64(words[1]&255) / 255.0,
+		Shadow:    float64((words[1]>>8)&255) / 255.0,
+		Tile:      int(words[1] >> 16),
+	}
+}
+
+// Returns: the vertex in chunk space
+func (v ChunkVertex) Position() (float64, float64, float64) {
+	corner := FaceCorners[v.Face][v.Corner]
+	return float64(v.X + corner[0]), float64(v.Y) + (float64(corner[1]) * v.Height), float64(v.Z + corner[2])
+}
+
+func (v ChunkVertex) TexCoord() (float64, float64) {
+	coord := FaceTexCoords[v.Face][v.Corner]
+	return coord[0], coord[1]
+}
+
+// Two triangles for each of numQuads quads
+func QuadIndices(numQuads int) []uint32 {
+	indices := make([]uint32, 0, numQuads*6)
+	for quad := 0; quad < numQuads; quad++ {
+		a := uint32(quad * 4)
+		indices = append(indices,
+			a, a+1, a+2,
+			a+2, a+3, a,
+		)
+	}
+
+	return indices
+}
@@ -0,0 +1,49 @@
+package renderer
+
+import (
+	"testing"
+)
+
+// Every field at its lowest and highest value comes back unchanged, without
+// spilling into its neighbours.
+func TestPackChunkVertexLimits(t *testing.T) {
+	vertices := []ChunkVertex{
+		{X: 0, Y: 0, Z: 0, Face: 0, Corner: 0, Height: 0.0, Occlusion: 0.0, Shadow: 0.0, Tile: 0},
+		{X: MAX_CHUNK_BLOCKS - 1, Y: MAX_CHUNK_BLOCKS - 1, Z: MAX_CHUNK_BLOCKS - 1, Face: 5, Corner: 3, Height: 1.0, Occlusion: 1.0, Shadow: 1.0, Tile: MAX_CHUNK_TILES - 1},
+		{X: MAX_CHUNK_BLOCKS - 1, Y: 0, Z: MAX_CHUNK_BLOCKS - 1, Face: 0, Corner: 3, Height: 0.0, Occlusion: 1.0, Shadow: 0.0, Tile: MAX_CHUNK_TILES - 1},
+		{X: 0, Y: MAX_CHUNK_BLOCKS - 1, Z: 0, Face: 5, Corner: 0, Height: 1.0, Occlusion: 0.0, Shadow: 1.0, Tile: 0},
+		{X: 7, Y: 19, Z: 30, Face: 4, Corner: 2, Height: 128.0 / 255.0, Occlusion: 64.0 / 255.0, Shadow: 200.0 / 255.0, Tile: 1234},
+	}
+
+	for _, vertex := range vertices {
+		words := PackChunkVertex(vertex)
+		unpacked := UnpackChunkVertex(words[:])
+		if unpacked != vertex {
+			t.Errorf("packed %+v, unpacked %+v", vertex, unpacked)
+		}
+	}
+}
+
+func TestPackChunkVertexOutOfRange(t *testing.T) {
+	vertices := []ChunkVertex{
+		{X: MAX_CHUNK_BLOCKS},
+		{Y: MAX_CHUNK_BLOCKS},
+		{Z: MAX_CHUNK_BLOCKS},
+		{X: -1},
+		{Face: 6},
+		{Corner: 4},
+		{Tile: MAX_CHUNK_TILES},
+		{Tile: -1},
+	}
+
+	for _, vertex := range vertices {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("packing %+v didn't panic", vertex)
+				}
+			}()
+			PackChunkVertex(vertex)
+		}()
+	}
+}
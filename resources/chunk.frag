@@ -5,41 +5,14 @@ struct SHC{
 	vec3 L00, L1m1, L10, L11, L2m2, L2m1, L20, L21, L22;
 };
 
-SHC groove = SHC(
-	vec3( 0.3783264,  0.4260425,  0.4504587),
-	vec3( 0.2887813,  0.3586803,  0.4147053),
-	vec3( 0.0379030,  0.0295216,  0.0098567),
-	vec3(-0.1033028, -0.1031690, -0.0884924),
-	vec3(-0.0621750, -0.0554432, -0.0396779),
-	vec3( 0.0077820, -0.0148312, -0.0471301),
-	vec3(-0.0935561, -0.1254260, -0.1525629),
-	vec3(-0.0572703, -0.0502192, -0.0363410),
-	vec3( 0.0203348, -0.0044201, -0.0452180)
-);
-
-SHC beach = SHC(
-	vec3( 0.6841148,  0.6929004,  0.7069543),
-	vec3( 0.3173355,  0.3694407,  0.4406839),
-	vec3(-0.1747193, -0.1737154, -0.1657420),
-	vec3(-0.4496467, -0.4155184, -0.3416573),
-	vec3(-0.1690202, -0.1703022, -0.1525870),
-	vec3(-0.0837808, -0.0940454, -0.1027518),
-	vec3(-0.0319670, -0.0214051, -0.0147691),
-	vec3( 0.1641816,  0.1377558,  0.1010403),
-	vec3( 0.3697189,  0.3097930,  0.2029923)
-);
-
-SHC tomb = SHC(
-	vec3( 1.0351604,  0.7603549,  0.7074635),
-	vec3( 0.4442150,  0.3430402,  0.3403777),
-	vec3(-0.2247797, -0.1828517, -0.1705181),
-	vec3( 0.7110400,  0.5423169,  0.5587956),
-	vec3( 0.6430452,  0.4971454,  0.5156357),
-	vec3(-0.1150112, -0.0936603, -0.0839287),
-	vec3(-0.3742487, -0.2755962, -0.2875017),
-	vec3(-0.1694954, -0.1343096, -0.1335315),
-	vec3( 0.5515260,  0.4222179,  0.4162488)
-);
+/* set from Go, see renderer.Environment */
+uniform SHC outsideSH;
+uniform SHC insideSH;
+
+/* time of day, see renderer.Daylight */
+uniform vec3 sunDir;
+uniform vec3 sunColor;
+uniform vec3 skylight;
 
 vec3 sh_light(vec3 normal, SHC l){
 	float x = normal.x;
@@ -71,20 +44,78 @@ vec3 gamma(vec3 color) {
 }
 
 in float occFac;
+in float shadowFac;
 in vec3 eyeNormal;
+in vec2 uv;
+in float tile;
+in vec3 worldPos;
 out vec4 fragment;
 
 uniform int mouseHit;
 uniform int onlyOccFac;
 
+uniform sampler2D atlas;
+uniform int atlasColumns;
+uniform int atlasRows;
+uniform int useAtlas;
+uniform int translucent;
+
+/* distance fog, x is the fog distance, none when it's 0, y is
+   renderer.FOG_FALLOFF. There's no float uniform setter, hence the vec3 */
+uniform vec3 eye;
+uniform vec3 fog;
+uniform vec3 fogColor;
+
+/* clear up to half the fog distance, 2% left at it */
+float fog_visibility(float dist) {
+	float fogDistance = fog.x;
+	if (fogDistance <= 0.0) {
+		return 1.0;
+	}
+	float start = fogDistance * 0.5;
+	float depth = max(dist - start, 0.0) * (fog.y / (fogDistance - start));
+	return exp(-depth * depth);
+}
+
+/* uv counts blocks, wrap it inside the tile so merged quads repeat it */
+vec4 atlas_texel(float index, vec2 coord) {
+	float columns = float(atlasColumns);
+	float col = mod(index, columns);
+	float row = floor(index / columns);
+	vec2 atlasCoord = vec2(
+		(col + fract(coord.x)) / columns,
+		(row + 1.0 - fract(coord.y)) / float(atlasRows)
+	);
+	return texture(atlas, atlasCoord);
+}
+
 void main() {
-	vec3 outside = sh_light(eyeNormal, beach);
-	vec3 inside = sh_light(eyeNormal, groove)*0.04;
-	vec3 ambient = mix(inside, outside, occFac);
+	vec3 outside = sh_light(eyeNormal, outsideSH);
+	vec3 inside = sh_light(eyeNormal, insideSH);
+	vec3 ambient = mix(inside, outside, occFac) * skylight;
+	ambient += sunColor * max(dot(eyeNormal, sunDir), 0.0) * shadowFac;
 
 	if (onlyOccFac == 1) {
 		fragment = vec4(occFac, occFac, occFac, 1.0);
 	} else {
-		fragment = vec4(gamma(ambient * 0.5), 1.0);
+		vec4 texel = vec4(1.0);
+		if (useAtlas == 1) {
+			texel = atlas_texel(floor(tile + 0.5), uv);
+		}
+
+		/* opaque pass cuts out, e.g. the holes in leaves */
+		float alpha = 1.0;
+		if (translucent == 1) {
+			alpha = texel.a;
+		} else if (texel.a < 0.5) {
+			discard;
+		}
+		vec3 color = gamma(ambient * 0.5) * texel.rgb;
+		/* chunk under the mouse, see renderer.MOUSE_HIT_TINT */
+		if (mouseHit == 1) {
+			color = mix(color, vec3(1.0, 0.9, 0.5), 0.3);
+		}
+		color = mix(fogColor, color, fog_visibility(distance(worldPos, eye)));
+		fragment = vec4(color, alpha);
 	}
 }
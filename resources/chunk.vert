@@ -1,18 +1,57 @@
 #version 130
 
-in vec4 vertexPos;
-in float occFactor;
+/* packed as described in renderer/vertex.go */
+in uvec2 packedVertex;
 
 out vec3 eyeNormal;
 out float occFac;
+out float shadowFac;
+out vec2 uv;
+out float tile;
+out vec3 worldPos;
 
 uniform mat4 pv;
 uniform mat4 model;
-uniform vec3 normal;
+
+/* FaceNormals, FaceCorners and FaceTexCoords, four corners per face */
+const vec3 normals[6] = vec3[6](
+	vec3(0, 0, 1), vec3(0, 0, -1), vec3(-1, 0, 0), vec3(1, 0, 0), vec3(0, 1, 0), vec3(0, -1, 0)
+);
+const vec3 corners[24] = vec3[24](
+	vec3(0, 0, 1), vec3(1, 0, 1), vec3(1, 1, 1), vec3(0, 1, 1),
+	vec3(1, 1, 0), vec3(1, 0, 0), vec3(0, 0, 0), vec3(0, 1, 0),
+	vec3(0, 0, 0), vec3(0, 0, 1), vec3(0, 1, 1), vec3(0, 1, 0),
+	vec3(1, 1, 1), vec3(1, 0, 1), vec3(1, 0, 0), vec3(1, 1, 0),
+	vec3(1, 1, 1), vec3(1, 1, 0), vec3(0, 1, 0), vec3(0, 1, 1),
+	vec3(0, 0, 0), vec3(1, 0, 0), vec3(1, 0, 1), vec3(0, 0, 1)
+);
+const vec2 texCoords[24] = vec2[24](
+	vec2(0, 0), vec2(1, 0), vec2(1, 1), vec2(0, 1),
+	vec2(0, 1), vec2(0, 0), vec2(1, 0), vec2(1, 1),
+	vec2(0, 0), vec2(1, 0), vec2(1, 1), vec2(0, 1),
+	vec2(0, 1), vec2(0, 0), vec2(1, 0), vec2(1, 1),
+	vec2(1, 1), vec2(1, 0), vec2(0, 0), vec2(0, 1),
+	vec2(0, 0), vec2(1, 0), vec2(1, 1), vec2(0, 1)
+);
 
 void main() {
-	occFac = occFactor;
+	uint position = packedVertex.x;
+	vec3 block = vec3(float(position & 31u), float((position >> 5u) & 31u), float((position >> 10u) & 31u));
+	int face = int((position >> 15u) & 7u);
+	int corner = (face * 4) + int((position >> 18u) & 3u);
+	float height = float((position >> 20u) & 255u) / 255.0;
+	vec3 offset = corners[corner];
+	offset.y *= height;
+	vec4 vertexPos = vec4(block + offset, 1.0);
+
+	occFac = float(packedVertex.y & 255u) / 255.0;
+	shadowFac = float((packedVertex.y >> 8u) & 255u) / 255.0;
+	tile = float(packedVertex.y >> 16u);
+	uv = texCoords[corner];
+
 	mat4 pvm = pv * model;
-	eyeNormal = normal;
+	/* chunks are only translated */
+	eyeNormal = normals[face];
+	worldPos = (model * vertexPos).xyz;
 	gl_Position = pvm * vertexPos;
 }
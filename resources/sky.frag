@@ -0,0 +1,28 @@
+#version 130
+
+in vec2 ndc;
+out vec4 fragment;
+
+/* view ray is forward + ndc.x*right + ndc.y*up */
+uniform vec3 right;
+uniform vec3 up;
+uniform vec3 forward;
+
+uniform vec3 zenithColor;
+uniform vec3 horizonColor;
+uniform vec3 sunDir;
+uniform vec3 sunColor;
+
+vec3 sky_color(vec3 dir) {
+	float height = sqrt(max(dir.y, 0.0));
+	vec3 color = mix(horizonColor, zenithColor, height);
+
+	float sun = max(dot(dir, sunDir), 0.0);
+	float glow = pow(sun, 8.0) * 0.3 + pow(sun, 512.0) * 2.0;
+	return color + sunColor * glow;
+}
+
+void main() {
+	vec3 dir = normalize(forward + ndc.x * right + ndc.y * up);
+	fragment = vec4(sky_color(dir), 1.0);
+}
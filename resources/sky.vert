@@ -0,0 +1,10 @@
+#version 130
+
+in vec2 vertexPos;
+
+out vec2 ndc;
+
+void main() {
+	ndc = vertexPos;
+	gl_Position = vec4(vertexPos, 0.0, 1.0);
+}
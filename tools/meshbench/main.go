@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bedrock"
+	"dwelling/chunkmanager"
+	"dwelling/renderer"
+	"dwelling/renderer/glbackend"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// Bytes per vertex and per quad of chunk meshes before vertices were packed:
+// position, occlusion, shadow, uv and tile as float32 and six uint32
+// indices per quad in every mesh
+const unpackedVertexBytes = 8 * 4
+const unpackedQuadBytes = 6 * 4
+
+// Generates a world and times building and uploading the mesh of every
+// chunk, with the memory the meshes take next to what the unpacked layout
+// needed. Rebuilding them all again shows how the backend reuses freed
+// buffer space. Uploads go to the software backend unless -gl opens a
+// window, run that from the resources directory for the shaders.
+func main() {
+	seed := flag.Int64("seed", 1, "world seed")
+	generator := flag.String("generator", "terrain", "generator name")
+	size := flag.Int("size", 6, "world size in chunks per axis")
+	useGL := flag.Bool("gl", false, "upload to the GL backend instead of the software one")
+	rebuilds := flag.Int("rebuilds", 2, "times to rebuild every mesh after the first build")
+	flag.Parse()
+
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	if *useGL {
+		runtime.LockOSThread()
+		if err := bedrock.Init(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer bedrock.Cleanup()
+		renderer.SetBackend(glbackend.New())
+		if err := renderer.Current().SetUp(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else {
+		renderer.SetBackend(renderer.NewSoftBackend(1, 1))
+	}
+
+	chunkmanager.SetSeed(*seed)
+	if err := chunkmanager.SetGenerator(*generator); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	chunkmanager.MeasureStartup(*size)
+	timing := chunkmanager.MeasureMeshes()
+
+	numQuads := timing.NumVertices / 4
+	packed := timing.VertexBytes + timing.IndexBytes
+	unpacked := (timing.NumVertices * unpackedVertexBytes) + (numQuads * unpackedQuadBytes)
+	fmt.Printf("Seed %d, %s generator, %d chunks, %d vertices\n", *seed, *generator, timing.NumChunks, timing.NumVertices)
+	fmt.Printf("%-10s %12s %12s %12s\n", "layout", "vertices", "indices", "total")
+	fmt.Printf("%-10s %12d %12d %12d\n", "packed", timing.VertexBytes, timing.IndexBytes, packed)
+	fmt.Printf("%-10s %12d %12d %12d\n", "unpacked", timing.NumVertices*unpackedVertexBytes, numQuads*unpackedQuadBytes, unpacked)
+	if unpacked > 0 {
+		fmt.Printf("Packed meshes take %.1f%% of the memory\n", float64(packed)*100.0/float64(unpacked))
+	}
+	fmt.Printf("Build %v, upload %v\n", timing.Build, timing.Upload)
+	fmt.Println(renderer.Current().MeshMemory())
+
+	for t := 0; t < *rebuilds; t++ {
+		timing = chunkmanager.MeasureMeshes()
+		fmt.Printf("Rebuild %d: build %v, upload %v\n", t+1, timing.Build, timing.Upload)
+		fmt.Println(renderer.Current().MeshMemory())
+	}
+	renderer.Current().Cleanup()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"dwelling/chunkmanager"
+	"dwelling/renderer"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+func parseInts(value string) ([]int, error) {
+	values := []int{}
+	for _, part := range strings.Split(value, ",") {
+		number, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("expected a comma separated list of numbers, got %q", value)
+		}
+		values = append(values, number)
+	}
+
+	return values, nil
+}
+
+// Times world start-up, generation and the shadow and occlusion bakes, for
+// worlds of several sizes and worker counts.
+func main() {
+	seed := flag.Int64("seed", 1, "world seed")
+	generator := flag.String("generator", "shapes", "generator name, the demo world uses shapes")
+	sizes := flag.String("sizes", "4,6,8", "world sizes in chunks per axis")
+	workers := flag.String("workers", fmt.Sprintf("1,%d", runtime.NumCPU()), "occlusion worker counts")
+	rays := flag.Int("rays", 16, "occlusion rays over the full sphere")
+	timeOfDay := flag.Float64("time", 10.0, "time of day in hours shadows are baked for")
+	flag.Parse()
+
+	sizeList, err := parseInts(*sizes)
+	if err != nil {
+		fmt.Println("-sizes:", err)
+		os.Exit(1)
+	}
+	workerList, err := parseInts(*workers)
+	if err != nil {
+		fmt.Println("-workers:", err)
+		os.Exit(1)
+	}
+
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	chunkmanager.SetSeed(*seed)
+	if err := chunkmanager.SetGenerator(*generator); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := chunkmanager.SetOcclusionRays(*rays); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	chunkmanager.SetSunDirection(renderer.DaylightAt(*timeOfDay).SunDir)
+
+	fmt.Printf("Seed %d, %s generator, %d rays\n", *seed, *generator, *rays)
+	fmt.Printf("%6s %8s %8s %10s %12s %12s %12s %12s\n", "size", "workers", "chunks", "blocks", "generation", "shadows", "occlusion", "total")
+	for _, size := range sizeList {
+		for _, numWorkers := range workerList {
+			if err := chunkmanager.SetOcclusionWorkers(numWorkers); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			timing := chunkmanager.MeasureStartup(size)
+			fmt.Printf("%6d %8d %8d %10d %12v %12v %12v %12v\n", size, numWorkers, timing.NumChunks, timing.NumBlocks,
+				timing.Generation, timing.Shadows, timing.Occlusion, timing.Generation+timing.Shadows+timing.Occlusion)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"dwelling/chunkmanager"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Prints how many blocks of each resource the ore pass produces over a
+// region, for balancing the ore distribution without starting the game.
+func main() {
+	seed := flag.Int64("seed", 1, "world seed")
+	generator := flag.String("generator", "terrain", "generator name")
+	radius := flag.Int("radius", 4, "horizontal radius of the sampled region in chunks")
+	minY := flag.Int("miny", -4, "lowest chunk Y to sample")
+	maxY := flag.Int("maxy", 3, "highest chunk Y to sample")
+	flag.Parse()
+
+	chunkmanager.SetSeed(*seed)
+	if err := chunkmanager.SetGenerator(*generator); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	stats := chunkmanager.SampleOreStatistics(
+		chunkmanager.ChunkCoord{-*radius, *minY, -*radius},
+		chunkmanager.ChunkCoord{*radius - 1, *maxY, *radius - 1},
+	)
+
+	types := []int{}
+	for blockType := range stats.Counts {
+		types = append(types, int(blockType))
+	}
+	sort.Ints(types)
+
+	fmt.Printf("Seed %d, %d chunks sampled, %d host blocks\n", *seed, stats.NumChunks, stats.HostBlocks)
+	if stats.NumChunks == 0 {
+		return
+	}
+	fmt.Printf("%-12s %10s %10s %10s\n", "resource", "blocks", "per chunk", "% of host")
+	for _, t := range types {
+		blockType := chunkmanager.BlockType(t)
+		count := stats.Counts[blockType]
+		perChunk := float64(count) / float64(stats.NumChunks)
+		percent := 0.0
+		if stats.HostBlocks > 0 {
+			percent = float64(count) / float64(stats.HostBlocks) * 100.0
+		}
+		fmt.Printf("%-12s %10d %10.2f %10.3f\n", chunkmanager.GetBlockTypeInfo(blockType).Name, count, perChunk, percent)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"dwelling/renderer"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+)
+
+func decodeImage(filename string) (image.Image, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return img, nil
+}
+
+// Computes SH9 lighting coefficients from an environment image, a 2:1
+// equirectangular one or a 4:3 horizontal cross cube map, and writes them
+// as a .sh9 file the game loads from its environments directory.
+func main() {
+	in := flag.String("in", "", "PNG or JPEG environment image")
+	out := flag.String("out", "", ".sh9 file to write, empty prints the coefficients")
+	exposure := flag.Float64("exposure", 1.0, "scale applied to the image's linear radiance")
+	flag.Parse()
+
+	if *in == "" {
+		fmt.Println("-in is required")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	img, err := decodeImage(*in)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	sh, err := renderer.SHFromImage(img, *exposure)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *out == "" {
+		for t, value := range sh.Array() {
+			fmt.Printf("%-4s %10.7f %10.7f %10.7f\n", renderer.SHNames[t], value.X, value.Y, value.Z)
+		}
+		return
+	}
+
+	comments := []string{fmt.Sprintf("From %s, exposure %g", filepath.Base(*in), *exposure)}
+	if err := renderer.SaveSHCoefficients(*out, sh, comments); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("Saved %s\n", *out)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"dwelling/chunkmanager"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strings"
+	"time"
+)
+
+// Generates a region without opening a window and writes a top-down height
+// map, a top-down colour map and a horizontal and vertical slice as PNGs.
+// Nothing it imports links GL, so it runs on machines without a display.
+
+var seed = flag.Int64("seed", 1, "world seed")
+var generator = flag.String("generator", "terrain", "generator name or path to a generator .json file")
+var radius = flag.Int("radius", 4, "horizontal radius of the region in chunks")
+var minY = flag.Int("miny", -1, "lowest chunk Y to generate")
+var maxY = flag.Int("maxy", 3, "highest chunk Y to generate")
+var sliceY = flag.Int("slicey", 24, "world Y of the horizontal slice")
+var sliceZ = flag.Int("slicez", 0, "world Z of the vertical slice")
+var scale = flag.Int("scale", 2, "pixels per block")
+var out = flag.String("out", "preview", "output file prefix")
+
+var airColor = color.RGBA{0, 0, 0, 255}
+
+type region struct {
+	minX, minY, minZ int
+	maxX, maxY, maxZ int
+}
+
+func main() {
+	flag.Parse()
+
+	chunkmanager.SetSeed(*seed)
+	if strings.HasSuffix(*generator, ".json") {
+		gen, err := chunkmanager.LoadGeneratorFile(*generator)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		*generator = gen.Name
+	}
+	if err := chunkmanager.SetGenerator(*generator); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	start := time.Now()
+	minChunk := chunkmanager.ChunkCoord{-*radius, *minY, -*radius}
+	maxChunk := chunkmanager.ChunkCoord{*radius - 1, *maxY, *radius - 1}
+	chunkmanager.GenerateRegion(minChunk, maxChunk)
+	fmt.Printf("Generated %v - %v in %v\n", minChunk, maxChunk, time.Since(start))
+
+	reg := region{
+		minChunk.X * chunkmanager.ChunkBase, minChunk.Y * chunkmanager.ChunkBase, minChunk.Z * chunkmanager.ChunkBase,
+		((maxChunk.X + 1) * chunkmanager.ChunkBase) - 1, ((maxChunk.Y + 1) * chunkmanager.ChunkBase) - 1, ((maxChunk.Z + 1) * chunkmanager.ChunkBase) - 1,
+	}
+
+	heightImg, colorImg := topDown(reg)
+	sliceYImg := horizontalSlice(reg, *sliceY)
+	sliceZImg := verticalSlice(reg, *sliceZ)
+
+	images := map[string]image.Image{
+		*out + "_height.png":                           heightImg,
+		*out + "_color.png":                            colorImg,
+		fmt.Sprintf("%s_slice_y%d.png", *out, *sliceY): sliceYImg,
+		fmt.Sprintf("%s_slice_z%d.png", *out, *sliceZ): sliceZImg,
+	}
+	for filename, img := range images {
+		if err := writePNG(filename, img); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote %s\n", filename)
+	}
+}
+
+func blockColor(x, y, z int) (color.RGBA, bool) {
+	blockType, ok := chunkmanager.GetWorldBlockType(x, y, z)
+	if !ok {
+		return airColor, false
+	}
+	return chunkmanager.GetBlockTypeInfo(blockType).Color, true
+}
+
+func shade(col color.RGBA, factor float64) color.RGBA {
+	if factor < 0.0 {
+		factor = 0.0
+	} else if factor > 1.0 {
+		factor = 1.0
+	}
+	return color.RGBA{uint8(float64(col.R) * factor), uint8(float64(col.G) * factor), uint8(float64(col.B) * factor), 255}
+}
+
+// Height map scaled to the region height, and the colour of the top block
+// darkened by depth.
+func topDown(reg region) (*image.RGBA, *image.RGBA) {
+	width := reg.maxX - reg.minX + 1
+	depth := reg.maxZ - reg.minZ + 1
+	heightImg := image.NewRGBA(image.Rect(0, 0, width**scale, depth**scale))
+	colorImg := image.NewRGBA(image.Rect(0, 0, width**scale, depth**scale))
+
+	span := float64(reg.maxY - reg.minY)
+	for z := reg.minZ; z <= reg.maxZ; z++ {
+		for x := reg.minX; x <= reg.maxX; x++ {
+			heightCol, topCol := airColor, airColor
+			for y := reg.maxY; y >= reg.minY; y-- {
+				if col, ok := blockColor(x, y, z); ok {
+					level := float64(y-reg.minY) / span
+					heightCol = shade(color.RGBA{255, 255, 255, 255}, level)
+					topCol = shade(col, 0.4+(level*0.6))
+					break
+				}
+			}
+			fillPixel(heightImg, x-reg.minX, z-reg.minZ, heightCol)
+			fillPixel(colorImg, x-reg.minX, z-reg.minZ, topCol)
+		}
+	}
+
+	return heightImg, colorImg
+}
+
+// XZ plane at the given Y, seen from above.
+func horizontalSlice(reg region, y int) *image.RGBA {
+	width := reg.maxX - reg.minX + 1
+	depth := reg.maxZ - reg.minZ + 1
+	img := image.NewRGBA(image.Rect(0, 0, width**scale, depth**scale))
+
+	for z := reg.minZ; z <= reg.maxZ; z++ {
+		for x := reg.minX; x <= reg.maxX; x++ {
+			col, _ := blockColor(x, y, z)
+			fillPixel(img, x-reg.minX, z-reg.minZ, col)
+		}
+	}
+
+	return img
+}
+
+// XY plane at the given Z, Y up.
+func verticalSlice(reg region, z int) *image.RGBA {
+	width := reg.maxX - reg.minX + 1
+	height := reg.maxY - reg.minY + 1
+	img := image.NewRGBA(image.Rect(0, 0, width**scale, height**scale))
+
+	for y := reg.minY; y <= reg.maxY; y++ {
+		for x := reg.minX; x <= reg.maxX; x++ {
+			col, _ := blockColor(x, y, z)
+			fillPixel(img, x-reg.minX, reg.maxY-y, col)
+		}
+	}
+
+	return img
+}
+
+func fillPixel(img *image.RGBA, x, y int, col color.RGBA) {
+	for py := 0; py < *scale; py++ {
+		for px := 0; px < *scale; px++ {
+			img.SetRGBA((x**scale)+px, (y**scale)+py, col)
+		}
+	}
+}
+
+func writePNG(filename string, img image.Image) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}